@@ -1,7 +1,47 @@
 package sourcegraph
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 const DefaultPerPage = 10
 
+// repoListCursorNoUpdatedAt marks a cursor position within the region
+// of repos that have no UpdatedAt, which sort after all others.
+const repoListCursorNoUpdatedAt = "null"
+
+// RepoListCursor returns an opaque cursor representing the position
+// immediately after repo in a repo listing ordered by (UpdatedAt desc,
+// URI desc). Pass it in RepoListOptions.Cursor to resume listing from
+// that position.
+func RepoListCursor(repo *Repo) string {
+	if repo.UpdatedAt == nil {
+		return repoListCursorNoUpdatedAt + "|" + repo.URI
+	}
+	return repo.UpdatedAt.Time().UTC().Format(time.RFC3339Nano) + "|" + repo.URI
+}
+
+// ParseRepoListCursor parses a cursor produced by RepoListCursor into
+// its (UpdatedAt, URI) position. A nil updatedAt means the cursor is
+// within the region of repos with no UpdatedAt.
+func ParseRepoListCursor(cursor string) (updatedAt *time.Time, uri string, err error) {
+	i := strings.Index(cursor, "|")
+	if i == -1 {
+		return nil, "", fmt.Errorf("invalid repo list cursor: %q", cursor)
+	}
+	ts, uri := cursor[:i], cursor[i+1:]
+	if ts == repoListCursorNoUpdatedAt {
+		return nil, uri, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid repo list cursor %q: %s", cursor, err)
+	}
+	return &t, uri, nil
+}
+
 func (o ListOptions) PageOrDefault() int {
 	if o.Page <= 0 {
 		return 1
@@ -10,6 +10,7 @@ import (
 func TestProtobuf_RepoListOptions(t *testing.T) {
 	v := RepoListOptions{
 		Owner:       "o",
+		Cursor:      "2016-05-19T14:05:56Z|r/r",
 		ListOptions: ListOptions{Page: 5},
 	}
 	b, err := proto.Marshal(&v)
@@ -26,3 +27,70 @@ func TestProtobuf_RepoListOptions(t *testing.T) {
 		t.Errorf("got %+v, want %+v", v2, v)
 	}
 }
+
+func TestProtobuf_BuildListOptions(t *testing.T) {
+	v := BuildListOptions{
+		Repo:        "r/r",
+		CommitID:    "c",
+		Branch:      "b",
+		Manual:      true,
+		Automated:   true,
+		ListOptions: ListOptions{Page: 5},
+	}
+	b, err := proto.Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v2 BuildListOptions
+	if err := proto.Unmarshal(b, &v2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(v, v2) {
+		t.Errorf("got %+v, want %+v", v2, v)
+	}
+}
+
+func TestProtobuf_Build(t *testing.T) {
+	v := Build{
+		Repo:       "r/r",
+		ID:         7,
+		CommitID:   "c",
+		Branch:     "b",
+		CreatorUID: 42,
+	}
+	b, err := proto.Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v2 Build
+	if err := proto.Unmarshal(b, &v2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(v, v2) {
+		t.Errorf("got %+v, want %+v", v2, v)
+	}
+}
+
+func TestProtobuf_RepoList(t *testing.T) {
+	v := RepoList{
+		Repos:  []*Repo{{URI: "r/r"}},
+		Cursor: "2016-05-19T14:05:56Z|r/r",
+	}
+	b, err := proto.Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v2 RepoList
+	if err := proto.Unmarshal(b, &v2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(v, v2) {
+		t.Errorf("got %+v, want %+v", v2, v)
+	}
+}
@@ -3,163 +3,165 @@
 // DO NOT EDIT!
 
 /*
-	Package sourcegraph is a generated protocol buffer package.
-
-	It is generated from these files:
-		sourcegraph.proto
-
-	It has these top-level messages:
-		CombinedStatus
-		ListOptions
-		ListResponse
-		StreamResponse
-		RepoConfig
-		Repo
-		RepoListOptions
-		RepoRevSpec
-		RepoSpec
-		RepoStatus
-		RepoStatusList
-		RepoStatusesCreateOp
-		RepoList
-		ReposResolveRevOp
-		ResolvedRev
-		ReceivePackOp
-		UploadPackOp
-		Packet
-		RepoResolveOp
-		RepoResolution
-		ReposListRemoteOptions
-		RemoteRepoList
-		SrclibDataVersion
-		RepoConfigureAppOp
-		ReposCreateOp
-		ReposUpdateOp
-		ReposListCommitsOp
-		RepoListCommitsOptions
-		CommitList
-		ReposListBranchesOp
-		RepoListBranchesOptions
-		BranchList
-		ReposListTagsOp
-		ReposListCommittersOp
-		RepoListCommittersOptions
-		CommitterList
-		RepoListTagsOptions
-		TagList
-		MirrorReposRefreshVCSOp
-		VCSCredentials
-		RemoteRepo
-		Build
-		BuildConfig
-		BuildJob
-		BuildGetLogOptions
-		BuildListOptions
-		BuildSpec
-		BuildTask
-		BuildTaskListOptions
-		BuildUpdate
-		BuildList
-		BuildsCreateOp
-		BuildsUpdateOp
-		BuildsListBuildTasksOp
-		BuildTaskList
-		BuildsCreateTasksOp
-		BuildsUpdateTaskOp
-		BuildsGetTaskLogOp
-		BuildsDequeueNextOp
-		EmailAddr
-		LogEntries
-		Org
-		OrgListMembersOptions
-		OrgSpec
-		OrgsListMembersOp
-		UserList
-		UserCount
-		Person
-		PersonSpec
-		TaskSpec
-		TaskUpdate
-		User
-		UserSpec
-		UsersListOptions
-		OrgsListOp
-		EmailAddrList
-		OrgList
-		CreatedAccount
-		PasswordResetToken
-		PendingPasswordReset
-		NewPassword
-		NewAccount
-		LoginCredentials
-		GitHubAuthCode
-		AccessTokenRequest
-		AccessTokenResponse
-		GitHubUser
-		AuthInfo
-		ExternalTokenSpec
-		ExternalToken
-		Def
-		DefGetOptions
-		DefListOptions
-		DefListRefsOptions
-		DefSpec
-		DefsGetOp
-		DefList
-		DefsListRefsOp
-		RefList
-		DefListRefLocationsOptions
-		DefsListRefLocationsOp
-		RefLocationsList
-		DefRepoRef
-		DefFileRef
-		Delta
-		FileDiff
-		DeltaFiles
-		DeltaFilter
-		DeltaListFilesOptions
-		DeltaSpec
-		DeltasListFilesOp
-		RepoTreeGetOptions
-		GetFileOptions
-		RepoTreeSearchOptions
-		RepoTreeSearchResult
-		RepoTreeGetOp
-		RepoTreeSearchOp
-		RepoTreeListOp
-		RepoTreeListResult
-		VCSSearchResultList
-		TreeEntry
-		BasicTreeEntry
-		TreeEntrySpec
-		FileRange
-		DefsRefreshIndexOp
-		AuthorshipInfo
-		DefAuthor
-		DefAuthorship
-		DefListAuthorsOptions
-		DefsListAuthorsOp
-		DefAuthorList
-		Checklist
-		FileToken
-		ServerStatus
-		ServerConfig
-		UserEvent
-		Event
-		EventList
-		NotifyGenericEvent
-		Annotation
-		AnnotationList
-		AnnotationsListOptions
-		SearchOptions
-		SearchOp
-		SearchResult
-		SearchResultsList
-		SearchRefreshIndexOp
-		ChannelListenOp
-		ChannelAction
-		ChannelSendOp
-		ChannelSendResult
+Package sourcegraph is a generated protocol buffer package.
+
+It is generated from these files:
+
+	sourcegraph.proto
+
+It has these top-level messages:
+
+	CombinedStatus
+	ListOptions
+	ListResponse
+	StreamResponse
+	RepoConfig
+	Repo
+	RepoListOptions
+	RepoRevSpec
+	RepoSpec
+	RepoStatus
+	RepoStatusList
+	RepoStatusesCreateOp
+	RepoList
+	ReposResolveRevOp
+	ResolvedRev
+	ReceivePackOp
+	UploadPackOp
+	Packet
+	RepoResolveOp
+	RepoResolution
+	ReposListRemoteOptions
+	RemoteRepoList
+	SrclibDataVersion
+	RepoConfigureAppOp
+	ReposCreateOp
+	ReposUpdateOp
+	ReposListCommitsOp
+	RepoListCommitsOptions
+	CommitList
+	ReposListBranchesOp
+	RepoListBranchesOptions
+	BranchList
+	ReposListTagsOp
+	ReposListCommittersOp
+	RepoListCommittersOptions
+	CommitterList
+	RepoListTagsOptions
+	TagList
+	MirrorReposRefreshVCSOp
+	VCSCredentials
+	RemoteRepo
+	Build
+	BuildConfig
+	BuildJob
+	BuildGetLogOptions
+	BuildListOptions
+	BuildSpec
+	BuildTask
+	BuildTaskListOptions
+	BuildUpdate
+	BuildList
+	BuildsCreateOp
+	BuildsUpdateOp
+	BuildsListBuildTasksOp
+	BuildTaskList
+	BuildsCreateTasksOp
+	BuildsUpdateTaskOp
+	BuildsGetTaskLogOp
+	BuildsDequeueNextOp
+	EmailAddr
+	LogEntries
+	Org
+	OrgListMembersOptions
+	OrgSpec
+	OrgsListMembersOp
+	UserList
+	UserCount
+	Person
+	PersonSpec
+	TaskSpec
+	TaskUpdate
+	User
+	UserSpec
+	UsersListOptions
+	OrgsListOp
+	EmailAddrList
+	OrgList
+	CreatedAccount
+	PasswordResetToken
+	PendingPasswordReset
+	NewPassword
+	NewAccount
+	LoginCredentials
+	GitHubAuthCode
+	AccessTokenRequest
+	AccessTokenResponse
+	GitHubUser
+	AuthInfo
+	ExternalTokenSpec
+	ExternalToken
+	Def
+	DefGetOptions
+	DefListOptions
+	DefListRefsOptions
+	DefSpec
+	DefsGetOp
+	DefList
+	DefsListRefsOp
+	RefList
+	DefListRefLocationsOptions
+	DefsListRefLocationsOp
+	RefLocationsList
+	DefRepoRef
+	DefFileRef
+	Delta
+	FileDiff
+	DeltaFiles
+	DeltaFilter
+	DeltaListFilesOptions
+	DeltaSpec
+	DeltasListFilesOp
+	RepoTreeGetOptions
+	GetFileOptions
+	RepoTreeSearchOptions
+	RepoTreeSearchResult
+	RepoTreeGetOp
+	RepoTreeSearchOp
+	RepoTreeListOp
+	RepoTreeListResult
+	VCSSearchResultList
+	TreeEntry
+	BasicTreeEntry
+	TreeEntrySpec
+	FileRange
+	DefsRefreshIndexOp
+	AuthorshipInfo
+	DefAuthor
+	DefAuthorship
+	DefListAuthorsOptions
+	DefsListAuthorsOp
+	DefAuthorList
+	Checklist
+	FileToken
+	ServerStatus
+	ServerConfig
+	UserEvent
+	Event
+	EventList
+	NotifyGenericEvent
+	Annotation
+	AnnotationList
+	AnnotationsListOptions
+	SearchOptions
+	SearchOp
+	SearchResult
+	SearchResultsList
+	SearchRefreshIndexOp
+	ChannelListenOp
+	ChannelAction
+	ChannelSendOp
+	ChannelSendResult
 */
 package sourcegraph
 
@@ -351,7 +353,20 @@ type RepoListOptions struct {
 	Type                           string   `protobuf:"bytes,8,opt,name=Type,proto3" json:"Type,omitempty" url:",omitempty"`
 	Owner                          string   `protobuf:"bytes,10,opt,name=Owner,proto3" json:"Owner,omitempty" url:",omitempty"`
 	SlowlyIncludePublicGitHubRepos bool     `protobuf:"varint,12,opt,name=SlowlyIncludePublicGitHubRepos,proto3" json:"SlowlyIncludePublicGitHubRepos,omitempty" url:",omitempty"`
-	ListOptions                    `protobuf:"bytes,11,opt,name=ListOptions,embedded=ListOptions" json:""`
+	// Cursor, if set, is an opaque keyset-pagination cursor (as
+	// returned in RepoList.Cursor) identifying the position to resume
+	// listing from. It is only consulted by listings that support
+	// keyset pagination and takes precedence over Page.
+	Cursor string `protobuf:"bytes,13,opt,name=Cursor,proto3" json:"Cursor,omitempty" url:",omitempty"`
+	// NoArchived excludes archived repositories from the list of
+	// repositories. It is only consulted by the public GitHub repo
+	// listing used for the sitemap.
+	NoArchived bool `protobuf:"varint,14,opt,name=NoArchived,proto3" json:"NoArchived,omitempty" url:",omitempty"`
+	// NoMirror excludes mirror repositories from the list of
+	// repositories. It is only consulted by the public GitHub repo
+	// listing used for the sitemap.
+	NoMirror    bool `protobuf:"varint,15,opt,name=NoMirror,proto3" json:"NoMirror,omitempty" url:",omitempty"`
+	ListOptions `protobuf:"bytes,11,opt,name=ListOptions,embedded=ListOptions" json:""`
 }
 
 func (m *RepoListOptions) Reset()                    { *m = RepoListOptions{} }
@@ -421,13 +436,19 @@ type RepoStatusesCreateOp struct {
 	Status RepoStatus  `protobuf:"bytes,2,opt,name=Status" json:"Status"`
 }
 
-func (m *RepoStatusesCreateOp) Reset()                    { *m = RepoStatusesCreateOp{} }
-func (m *RepoStatusesCreateOp) String() string            { return proto.CompactTextString(m) }
-func (*RepoStatusesCreateOp) ProtoMessage()               {}
-func (*RepoStatusesCreateOp) Descriptor() ([]byte, []int) { return fileDescriptorSourcegraph, []int{11} }
+func (m *RepoStatusesCreateOp) Reset()         { *m = RepoStatusesCreateOp{} }
+func (m *RepoStatusesCreateOp) String() string { return proto.CompactTextString(m) }
+func (*RepoStatusesCreateOp) ProtoMessage()    {}
+func (*RepoStatusesCreateOp) Descriptor() ([]byte, []int) {
+	return fileDescriptorSourcegraph, []int{11}
+}
 
 type RepoList struct {
 	Repos []*Repo `protobuf:"bytes,1,rep,name=Repos" json:"Repos,omitempty"`
+	// Cursor is an opaque cursor that can be passed in
+	// RepoListOptions.Cursor to resume listing where this page left
+	// off. It is only set by listings that support keyset pagination.
+	Cursor string `protobuf:"bytes,2,opt,name=Cursor,proto3" json:"Cursor,omitempty"`
 }
 
 func (m *RepoList) Reset()                    { *m = RepoList{} }
@@ -1108,8 +1129,12 @@ type Build struct {
 	// are never killed for lack of a heartbeat.
 	Killed bool `protobuf:"varint,10,opt,name=Killed,proto3" json:"Killed,omitempty"`
 	// Host is the hostname of the machine that is working on this build.
-	Host        string `protobuf:"bytes,11,opt,name=Host,proto3" json:"Host,omitempty"`
-	Purged      bool   `protobuf:"varint,12,opt,name=Purged,proto3" json:"Purged,omitempty"`
+	Host   string `protobuf:"bytes,11,opt,name=Host,proto3" json:"Host,omitempty"`
+	Purged bool   `protobuf:"varint,12,opt,name=Purged,proto3" json:"Purged,omitempty"`
+	// CreatorUID is the UID of the authenticated user on whose behalf
+	// the build was created, or 0 for builds created by unauthenticated
+	// or internal callers (e.g. CI hooks and workers).
+	CreatorUID  int32 `protobuf:"varint,16,opt,name=CreatorUID,proto3" json:"CreatorUID,omitempty"`
 	BuildConfig `protobuf:"bytes,13,opt,name=BuildConfig,embedded=BuildConfig" json:""`
 }
 
@@ -1188,6 +1213,15 @@ type BuildListOptions struct {
 	Sort        string `protobuf:"bytes,9,opt,name=Sort,proto3" json:"Sort,omitempty" url:",omitempty"`
 	Direction   string `protobuf:"bytes,10,opt,name=Direction,proto3" json:"Direction,omitempty" url:",omitempty"`
 	ListOptions `protobuf:"bytes,11,opt,name=ListOptions,embedded=ListOptions" json:""`
+	// Branch filters the results to builds whose commit was built on
+	// the named VCS branch.
+	Branch string `protobuf:"bytes,12,opt,name=Branch,proto3" json:"Branch,omitempty" url:",omitempty"`
+	// Manual filters the results to builds created on behalf of an
+	// authenticated user (CreatorUID != 0); Automated filters to
+	// builds created by unauthenticated or internal callers (e.g. CI
+	// hooks and workers). Setting both matches no builds.
+	Manual    bool `protobuf:"varint,13,opt,name=Manual,proto3" json:"Manual,omitempty" url:",omitempty"`
+	Automated bool `protobuf:"varint,14,opt,name=Automated,proto3" json:"Automated,omitempty" url:",omitempty"`
 }
 
 func (m *BuildListOptions) Reset()                    { *m = BuildListOptions{} }
@@ -1255,10 +1289,12 @@ type BuildTaskListOptions struct {
 	ListOptions `protobuf:"bytes,1,opt,name=ListOptions,embedded=ListOptions" json:""`
 }
 
-func (m *BuildTaskListOptions) Reset()                    { *m = BuildTaskListOptions{} }
-func (m *BuildTaskListOptions) String() string            { return proto.CompactTextString(m) }
-func (*BuildTaskListOptions) ProtoMessage()               {}
-func (*BuildTaskListOptions) Descriptor() ([]byte, []int) { return fileDescriptorSourcegraph, []int{48} }
+func (m *BuildTaskListOptions) Reset()         { *m = BuildTaskListOptions{} }
+func (m *BuildTaskListOptions) String() string { return proto.CompactTextString(m) }
+func (*BuildTaskListOptions) ProtoMessage()    {}
+func (*BuildTaskListOptions) Descriptor() ([]byte, []int) {
+	return fileDescriptorSourcegraph, []int{48}
+}
 
 // A BuildUpdate contains updated information to update on an existing build.
 type BuildUpdate struct {
@@ -1668,10 +1704,12 @@ type PendingPasswordReset struct {
 	Login string `protobuf:"bytes,4,opt,name=Login,proto3" json:"Login,omitempty"`
 }
 
-func (m *PendingPasswordReset) Reset()                    { *m = PendingPasswordReset{} }
-func (m *PendingPasswordReset) String() string            { return proto.CompactTextString(m) }
-func (*PendingPasswordReset) ProtoMessage()               {}
-func (*PendingPasswordReset) Descriptor() ([]byte, []int) { return fileDescriptorSourcegraph, []int{79} }
+func (m *PendingPasswordReset) Reset()         { *m = PendingPasswordReset{} }
+func (m *PendingPasswordReset) String() string { return proto.CompactTextString(m) }
+func (*PendingPasswordReset) ProtoMessage()    {}
+func (*PendingPasswordReset) Descriptor() ([]byte, []int) {
+	return fileDescriptorSourcegraph, []int{79}
+}
 
 type NewPassword struct {
 	// password is the new password for the user who requested the password reset
@@ -2402,10 +2440,12 @@ type VCSSearchResultList struct {
 	ListResponse  `protobuf:"bytes,2,opt,name=ListResponse,embedded=ListResponse" json:""`
 }
 
-func (m *VCSSearchResultList) Reset()                    { *m = VCSSearchResultList{} }
-func (m *VCSSearchResultList) String() string            { return proto.CompactTextString(m) }
-func (*VCSSearchResultList) ProtoMessage()               {}
-func (*VCSSearchResultList) Descriptor() ([]byte, []int) { return fileDescriptorSourcegraph, []int{119} }
+func (m *VCSSearchResultList) Reset()         { *m = VCSSearchResultList{} }
+func (m *VCSSearchResultList) String() string { return proto.CompactTextString(m) }
+func (*VCSSearchResultList) ProtoMessage()    {}
+func (*VCSSearchResultList) Descriptor() ([]byte, []int) {
+	return fileDescriptorSourcegraph, []int{119}
+}
 
 // TreeEntry is a file or directory in a repository.
 type TreeEntry struct {
@@ -6173,6 +6213,32 @@ func (m *RepoListOptions) MarshalTo(data []byte) (int, error) {
 		}
 		i++
 	}
+	if len(m.Cursor) > 0 {
+		data[i] = 0x6a
+		i++
+		i = encodeVarintSourcegraph(data, i, uint64(len(m.Cursor)))
+		i += copy(data[i:], m.Cursor)
+	}
+	if m.NoArchived {
+		data[i] = 0x70
+		i++
+		if m.NoArchived {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	if m.NoMirror {
+		data[i] = 0x78
+		i++
+		if m.NoMirror {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -6381,6 +6447,12 @@ func (m *RepoList) MarshalTo(data []byte) (int, error) {
 			i += n
 		}
 	}
+	if len(m.Cursor) > 0 {
+		data[i] = 0x12
+		i++
+		i = encodeVarintSourcegraph(data, i, uint64(len(m.Cursor)))
+		i += copy(data[i:], m.Cursor)
+	}
 	return i, nil
 }
 
@@ -7672,6 +7744,13 @@ func (m *Build) MarshalTo(data []byte) (int, error) {
 		i = encodeVarintSourcegraph(data, i, uint64(len(m.Tag)))
 		i += copy(data[i:], m.Tag)
 	}
+	if m.CreatorUID != 0 {
+		data[i] = 0x80
+		i++
+		data[i] = 0x1
+		i++
+		i = encodeVarintSourcegraph(data, i, uint64(m.CreatorUID))
+	}
 	return i, nil
 }
 
@@ -7899,6 +7978,32 @@ func (m *BuildListOptions) MarshalTo(data []byte) (int, error) {
 		return 0, err
 	}
 	i += n47
+	if len(m.Branch) > 0 {
+		data[i] = 0x62
+		i++
+		i = encodeVarintSourcegraph(data, i, uint64(len(m.Branch)))
+		i += copy(data[i:], m.Branch)
+	}
+	if m.Manual {
+		data[i] = 0x68
+		i++
+		if m.Manual {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
+	if m.Automated {
+		data[i] = 0x70
+		i++
+		if m.Automated {
+			data[i] = 1
+		} else {
+			data[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -12773,6 +12878,16 @@ func (m *RepoListOptions) Size() (n int) {
 	if m.SlowlyIncludePublicGitHubRepos {
 		n += 2
 	}
+	l = len(m.Cursor)
+	if l > 0 {
+		n += 1 + l + sovSourcegraph(uint64(l))
+	}
+	if m.NoArchived {
+		n += 2
+	}
+	if m.NoMirror {
+		n += 2
+	}
 	return n
 }
 
@@ -12855,6 +12970,10 @@ func (m *RepoList) Size() (n int) {
 			n += 1 + l + sovSourcegraph(uint64(l))
 		}
 	}
+	l = len(m.Cursor)
+	if l > 0 {
+		n += 1 + l + sovSourcegraph(uint64(l))
+	}
 	return n
 }
 
@@ -13377,6 +13496,9 @@ func (m *Build) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovSourcegraph(uint64(l))
 	}
+	if m.CreatorUID != 0 {
+		n += 2 + sovSourcegraph(uint64(m.CreatorUID))
+	}
 	return n
 }
 
@@ -13469,6 +13591,16 @@ func (m *BuildListOptions) Size() (n int) {
 	}
 	l = m.ListOptions.Size()
 	n += 1 + l + sovSourcegraph(uint64(l))
+	l = len(m.Branch)
+	if l > 0 {
+		n += 1 + l + sovSourcegraph(uint64(l))
+	}
+	if m.Manual {
+		n += 2
+	}
+	if m.Automated {
+		n += 2
+	}
 	return n
 }
 
@@ -16678,6 +16810,75 @@ func (m *RepoListOptions) Unmarshal(data []byte) error {
 				}
 			}
 			m.SlowlyIncludePublicGitHubRepos = bool(v != 0)
+		case 13:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Cursor", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSourcegraph
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Cursor = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 14:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NoArchived", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.NoArchived = bool(v != 0)
+		case 15:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field NoMirror", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.NoMirror = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSourcegraph(data[iNdEx:])
@@ -17364,6 +17565,35 @@ func (m *RepoList) Unmarshal(data []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Cursor", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSourcegraph
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Cursor = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSourcegraph(data[iNdEx:])
@@ -21452,6 +21682,25 @@ func (m *Build) Unmarshal(data []byte) error {
 			}
 			m.Tag = string(data[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 16:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CreatorUID", wireType)
+			}
+			m.CreatorUID = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				m.CreatorUID |= (int32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSourcegraph(data[iNdEx:])
@@ -22161,6 +22410,75 @@ func (m *BuildListOptions) Unmarshal(data []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Branch", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthSourcegraph
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Branch = string(data[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 13:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Manual", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Manual = bool(v != 0)
+		case 14:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Automated", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSourcegraph
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := data[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Automated = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSourcegraph(data[iNdEx:])
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"bytes"
+	htmpl "html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ansiSeq = regexp.MustCompile(`\x1b\[([0-9;]*)([a-zA-Z])`)
+
+	// ansiIncompleteSeq matches an escape sequence that was cut off at
+	// the end of a chunk of log output.
+	ansiIncompleteSeq = regexp.MustCompile(`\x1b(\[[0-9;]*)?$`)
+)
+
+// ansiColorNames maps SGR color codes (in 30-37/90-97 order) to CSS
+// class name suffixes.
+var ansiColorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ansiToHTML converts text containing ANSI escape sequences (e.g.,
+// build tool output) to HTML. Color and bold SGR sequences become
+// styled <span> elements; all other escape sequences are stripped. The
+// text itself is HTML-escaped, so the result is safe to include in a
+// template. An incomplete escape sequence at the end of the text
+// (which can occur at chunk boundaries when streaming) is dropped.
+func ansiToHTML(s string) htmpl.HTML {
+	s = ansiIncompleteSeq.ReplaceAllString(s, "")
+
+	var buf bytes.Buffer
+	open := 0 // count of <span> elements not yet closed
+	writeSpan := func(class string) {
+		buf.WriteString(`<span class="` + class + `">`)
+		open++
+	}
+	for {
+		m := ansiSeq.FindStringSubmatchIndex(s)
+		if m == nil {
+			buf.WriteString(htmpl.HTMLEscapeString(s))
+			break
+		}
+		buf.WriteString(htmpl.HTMLEscapeString(s[:m[0]]))
+		params, kind := s[m[2]:m[3]], s[m[4]:m[5]]
+		s = s[m[1]:]
+		if kind != "m" {
+			// Strip non-SGR sequences (cursor movement, etc.).
+			continue
+		}
+		for _, p := range strings.Split(params, ";") {
+			n, _ := strconv.Atoi(p)
+			switch {
+			case p == "" || n == 0:
+				for ; open > 0; open-- {
+					buf.WriteString("</span>")
+				}
+			case n == 1:
+				writeSpan("ansi-bold")
+			case n >= 30 && n <= 37:
+				writeSpan("ansi-" + ansiColorNames[n-30])
+			case n >= 90 && n <= 97:
+				writeSpan("ansi-bright-" + ansiColorNames[n-90])
+			}
+		}
+	}
+	for ; open > 0; open-- {
+		buf.WriteString("</span>")
+	}
+	return htmpl.HTML(buf.String())
+}
@@ -0,0 +1,23 @@
+package app
+
+import "testing"
+
+func TestANSIToHTML(t *testing.T) {
+	tests := map[string]string{
+		"plain text":                      "plain text",
+		"a <b> & c":                       "a &lt;b&gt; &amp; c",
+		"\x1b[31mred\x1b[0m plain":        `<span class="ansi-red">red</span> plain`,
+		"\x1b[1;32mbold green\x1b[m":      `<span class="ansi-bold"><span class="ansi-green">bold green</span></span>`,
+		"\x1b[91mbright red\x1b[0m":       `<span class="ansi-bright-red">bright red</span>`,
+		"\x1b[2Kerased\x1b[31mred":        `erased<span class="ansi-red">red</span>`,
+		"unclosed \x1b[34mblue":           `unclosed <span class="ansi-blue">blue</span>`,
+		"chunk boundary \x1b[3":           "chunk boundary ",
+		"chunk boundary \x1b":             "chunk boundary ",
+		"\x1b[31m\x1b[1mnested\x1b[0m ok": `<span class="ansi-red"><span class="ansi-bold">nested</span></span> ok`,
+	}
+	for input, want := range tests {
+		if got := string(ansiToHTML(input)); got != want {
+			t.Errorf("ansiToHTML(%q): got %q, want %q", input, got, want)
+		}
+	}
+}
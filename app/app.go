@@ -78,6 +78,7 @@ func NewHandler(r *router.Router) http.Handler {
 	r.Get(router.Favicon).HandlerFunc(favicon)
 
 	r.Get(router.SitemapIndex).Handler(internal.Handler(serveSitemapIndex))
+	r.Get(router.ReposSitemap).Handler(internal.Handler(serveReposSitemap))
 
 	r.Get(router.RepoSitemap).Handler(internal.Handler(serveRepoSitemap))
 
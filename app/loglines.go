@@ -0,0 +1,76 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	htmpl "html/template"
+	"strconv"
+	"strings"
+)
+
+// logLinesToHTML renders build log lines as HTML, one block per line,
+// with a stable line-number anchor (id="L123") and a self-link on the
+// number, so a shared URL with a #L123 fragment scrolls the browser
+// to the referenced line and the UI can highlight it. firstLine is
+// the 1-based number of lines[0]: the numbering is positional and
+// matches the plain-text log endpoint (one log entry per text line),
+// so a line reference stays valid across both views and across
+// renders. Line content goes through ansiToHTML, which also
+// HTML-escapes it.
+func logLinesToHTML(lines []string, firstLine int) htmpl.HTML {
+	if firstLine < 1 {
+		firstLine = 1
+	}
+	var buf bytes.Buffer
+	for i, line := range lines {
+		n := firstLine + i
+		fmt.Fprintf(&buf, `<div class="log-line" id="L%d"><a class="log-line-number" href="#L%d">%d</a><span class="log-line-content">%s</span></div>`,
+			n, n, n, ansiToHTML(line))
+		buf.WriteByte('\n')
+	}
+	return htmpl.HTML(buf.String())
+}
+
+// parseLogLineRange parses a log line fragment of the form "L123" or
+// "L123-L140" (an optional leading "#" is accepted, so a raw
+// URL.Fragment can be passed in). It returns the 1-based start and
+// end lines of the range to highlight; a single line has start ==
+// end, and a backwards range is normalized. ok is false for anything
+// else, including zero or negative line numbers.
+func parseLogLineRange(frag string) (start, end int, ok bool) {
+	frag = strings.TrimPrefix(frag, "#")
+	first, rest := frag, ""
+	hasRange := false
+	if i := strings.Index(frag, "-"); i != -1 {
+		first, rest = frag[:i], frag[i+1:]
+		hasRange = true
+	}
+	start = parseLogLineNumber(first)
+	if start == 0 {
+		return 0, 0, false
+	}
+	if !hasRange {
+		return start, start, true
+	}
+	end = parseLogLineNumber(rest)
+	if end == 0 {
+		return 0, 0, false
+	}
+	if end < start {
+		start, end = end, start
+	}
+	return start, end, true
+}
+
+// parseLogLineNumber parses one "L123" reference, returning 0 if s is
+// not one.
+func parseLogLineNumber(s string) int {
+	if !strings.HasPrefix(s, "L") {
+		return 0
+	}
+	n, err := strconv.Atoi(s[len("L"):])
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
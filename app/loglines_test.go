@@ -0,0 +1,51 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogLinesToHTML(t *testing.T) {
+	got := string(logLinesToHTML([]string{"building", "a <b> & c"}, 5))
+	for _, want := range []string{
+		`id="L5"`,
+		`href="#L5"`,
+		`id="L6"`,
+		`>building<`,
+		"a &lt;b&gt; &amp; c",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `id="L7"`) {
+		t.Errorf("output numbers a line past the input:\n%s", got)
+	}
+}
+
+func TestParseLogLineRange(t *testing.T) {
+	tests := []struct {
+		frag       string
+		start, end int
+		ok         bool
+	}{
+		{"L123", 123, 123, true},
+		{"#L123", 123, 123, true},
+		{"L123-L140", 123, 140, true},
+		{"#L140-L123", 123, 140, true}, // backwards ranges are normalized
+		{"L1-L1", 1, 1, true},
+		{"", 0, 0, false},
+		{"L", 0, 0, false},
+		{"L0", 0, 0, false},
+		{"L-5", 0, 0, false},
+		{"123", 0, 0, false},
+		{"L12-", 0, 0, false},
+		{"L12-34", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, ok := parseLogLineRange(test.frag)
+		if start != test.start || end != test.end || ok != test.ok {
+			t.Errorf("parseLogLineRange(%q): got (%d, %d, %v), want (%d, %d, %v)", test.frag, start, end, ok, test.start, test.end, test.ok)
+		}
+	}
+}
@@ -16,6 +16,7 @@ const (
 	Favicon   = "favicon"
 
 	SitemapIndex = "sitemap-index"
+	ReposSitemap = "repos.sitemap"
 	RepoSitemap  = "repo.sitemap"
 
 	GitHubOAuth2Initiate = "github-oauth2.initiate"
@@ -48,6 +49,7 @@ func New(base *mux.Router) *Router {
 	base.Path("/favicon.ico").Methods("GET").Name(Favicon)
 
 	base.Path("/sitemap.xml").Methods("GET").Name(SitemapIndex)
+	base.Path(`/sitemap-repos.{Page:[0-9]+}.xml`).Methods("GET").Name(ReposSitemap)
 
 	base.Path("/-/github-oauth/initiate").Methods("GET").Name(GitHubOAuth2Initiate)
 	base.Path("/-/github-oauth/receive").Methods("GET", "POST").Name(GitHubOAuth2Receive)
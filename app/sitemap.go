@@ -3,8 +3,11 @@ package app
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/gorilla/mux"
 	"github.com/sourcegraph/sitemap"
 	"google.golang.org/grpc"
@@ -18,7 +21,81 @@ import (
 	"sourcegraph.com/sourcegraph/srclib/graph"
 )
 
+// reposSitemapPerPage is the number of repos listed in each child repo
+// sitemap. It is well under the sitemap.MaxURLs (and 50MB) limits, so
+// a child sitemap never overflows.
+const reposSitemapPerPage = 10000
+
+// listPublicRepos fetches one page of the public repo list used for
+// sitemap generation. If cursor is non-empty it takes precedence over
+// page, and the cursor for the following page is returned alongside
+// the repos.
+func listPublicRepos(ctx context.Context, cl *sourcegraph.Client, page int, cursor string) ([]*sourcegraph.Repo, string, error) {
+	repos, err := cl.Repos.List(ctx, &sourcegraph.RepoListOptions{
+		SlowlyIncludePublicGitHubRepos: true,
+		Cursor:                         cursor,
+		ListOptions: sourcegraph.ListOptions{
+			Page:    int32(page),
+			PerPage: reposSitemapPerPage,
+		},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return repos.Repos, repos.Cursor, nil
+}
+
+// repoLastMod returns the repo's last modification time for use in
+// sitemap <lastmod> elements, or nil if it is unknown.
+func repoLastMod(repo *sourcegraph.Repo) *time.Time {
+	if repo.UpdatedAt == nil {
+		return nil
+	}
+	t := repo.UpdatedAt.Time()
+	return &t
+}
+
+// sitemapNotModified handles conditional sitemap requests: it sets
+// the Last-Modified header and, if the client's If-Modified-Since
+// date shows its copy is at least as fresh as lastMod, writes a 304
+// Not Modified response and reports true. Crawlers re-fetch sitemaps
+// far more often than the repo list changes, so most of their
+// requests end here. A nil lastMod disables the check.
+func sitemapNotModified(w http.ResponseWriter, r *http.Request, lastMod *time.Time) bool {
+	if lastMod == nil {
+		return false
+	}
+	mod := lastMod.Round(time.Second)
+	w.Header().Set("Last-Modified", mod.UTC().Format(http.TimeFormat))
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !mod.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
 func serveSitemapIndex(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+	return serveCachedSitemap(w, r, "index", func() ([]byte, *time.Time, error) {
+		return generateSitemapIndex(ctx, cl)
+	})
+}
+
+// generateSitemapIndex renders the sitemap index XML and returns it
+// with its last-modified date.
+func generateSitemapIndex(ctx context.Context, cl *sourcegraph.Client) ([]byte, *time.Time, error) {
+	// The repo listing is ordered by updated_at desc NULLS LAST, so
+	// the first repo of the first page carries the most recent change
+	// across all public repos — the whole index's last-modified date.
+	firstPage, cursor, err := listPublicRepos(ctx, cl, 1, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	var lastMod *time.Time
+	if len(firstPage) > 0 {
+		lastMod = repoLastMod(firstPage[0])
+	}
+
 	var si sitemap.Index
 
 	// TODO: remove these static sitemaps once we have proper sitemap generation! These just cover
@@ -37,21 +114,93 @@ func serveSitemapIndex(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
+	// Link to the paginated repo sitemaps. The total number of public
+	// repos isn't known up front, so page through (following the
+	// keyset cursor so deep pages stay fast) until a page comes back
+	// short.
+	for page := 1; len(si.Sitemaps) < sitemap.MaxSitemaps; page++ {
+		repos := firstPage
+		if page > 1 {
+			var err error
+			repos, cursor, err = listPublicRepos(ctx, cl, page, cursor)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(repos) > 0 {
+			si.Sitemaps = append(si.Sitemaps, sitemap.Sitemap{
+				Loc: conf.AppURL(ctx).ResolveReference(router.Rel.URLTo(router.ReposSitemap, "Page", strconv.Itoa(page))).String(),
+				// The repo query orders by updated_at desc, so the
+				// first repo has the page's most recent change.
+				LastMod: repoLastMod(repos[0]),
+			})
+		}
+		if len(repos) < reposSitemapPerPage {
+			break
+		}
+	}
+
 	// Truncate to sitemaps limit.
 	if len(si.Sitemaps) > sitemap.MaxSitemaps {
 		si.Sitemaps = si.Sitemaps[:sitemap.MaxSitemaps]
 	}
 
 	siXML, err := sitemap.MarshalIndex(&si)
+	if err != nil {
+		return nil, nil, err
+	}
+	return siXML, lastMod, nil
+}
+
+// serveReposSitemap serves one page of the public repo sitemap, with a
+// <url> entry per repo.
+func serveReposSitemap(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	page, err := strconv.Atoi(mux.Vars(r)["Page"])
 	if err != nil {
 		return err
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Cache-Control", "private, max-age=900")
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	w.Write(siXML)
-	return nil
+	return serveCachedSitemap(w, r, "repos:"+strconv.Itoa(page), func() ([]byte, *time.Time, error) {
+		return generateReposSitemap(ctx, cl, page)
+	})
+}
+
+// generateReposSitemap renders one page of the public repo sitemap
+// and returns it with the page's last-modified date.
+func generateReposSitemap(ctx context.Context, cl *sourcegraph.Client, page int) ([]byte, *time.Time, error) {
+	repos, _, err := listPublicRepos(ctx, cl, page, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The page is ordered by updated_at desc, so its first repo has
+	// the page's most recent change.
+	var lastMod *time.Time
+	if len(repos) > 0 {
+		lastMod = repoLastMod(repos[0])
+	}
+
+	var sm sitemap.URLSet
+	for _, repo := range repos {
+		sm.URLs = append(sm.URLs, sitemap.URL{
+			Loc:        conf.AppURL(ctx).ResolveReference(router.Rel.URLToRepo(repo.URI)).String(),
+			LastMod:    repoLastMod(repo),
+			ChangeFreq: sitemap.Weekly,
+		})
+	}
+
+	// Truncate to sitemaps limit.
+	if len(sm.URLs) > sitemap.MaxURLs {
+		sm.URLs = sm.URLs[:sitemap.MaxURLs]
+	}
+
+	sitemapXML, err := sitemap.Marshal(&sm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sitemapXML, lastMod, nil
 }
 
 func serveRepoSitemap(w http.ResponseWriter, r *http.Request) error {
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+)
+
+// The sitemaps change slowly but are expensive to render (the index
+// pages through the whole public repo list), and crawlers fetch them
+// frequently. This cache keeps the fully rendered, gzipped response
+// per sitemap (keyed by page for the paginated repo sitemaps) and
+// serves it as a buffer copy. A stale entry is still served while one
+// background goroutine regenerates it, so crawlers never wait on a
+// rebuild and the backend sees at most one regeneration per sitemap
+// per TTL.
+
+// sitemapCacheTTL is how long a rendered sitemap is served before it
+// is regenerated. It matches the max-age the sitemap handlers send.
+var sitemapCacheTTL = conf.GetenvDurationOrDefault("SG_SITEMAP_CACHE_TTL", "15m")
+
+type sitemapCacheEntry struct {
+	gzipped []byte
+	lastMod *time.Time
+	at      time.Time
+}
+
+var (
+	sitemapCacheMu         sync.Mutex
+	sitemapCache           = map[string]*sitemapCacheEntry{}
+	sitemapCacheRefreshing = map[string]bool{}
+)
+
+// sitemapCacheGet returns the cached entry for key (nil if there is
+// none) and whether it is still within the TTL.
+func sitemapCacheGet(key string) (entry *sitemapCacheEntry, fresh bool) {
+	sitemapCacheMu.Lock()
+	defer sitemapCacheMu.Unlock()
+	entry = sitemapCache[key]
+	if entry == nil {
+		return nil, false
+	}
+	return entry, time.Since(entry.at) < sitemapCacheTTL
+}
+
+// sitemapCachePut gzips xml and stores it under key, returning the
+// new entry.
+func sitemapCachePut(key string, xml []byte, lastMod *time.Time) (*sitemapCacheEntry, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(xml); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	entry := &sitemapCacheEntry{gzipped: buf.Bytes(), lastMod: lastMod, at: time.Now()}
+	sitemapCacheMu.Lock()
+	sitemapCache[key] = entry
+	sitemapCacheMu.Unlock()
+	return entry, nil
+}
+
+// sitemapCacheRefresh regenerates key's entry in the background,
+// unless a regeneration for it is already underway. A failed
+// regeneration is logged and the stale entry stays in place, to be
+// retried on a later request.
+func sitemapCacheRefresh(key string, generate func() ([]byte, *time.Time, error)) {
+	sitemapCacheMu.Lock()
+	if sitemapCacheRefreshing[key] {
+		sitemapCacheMu.Unlock()
+		return
+	}
+	sitemapCacheRefreshing[key] = true
+	sitemapCacheMu.Unlock()
+
+	go func() {
+		defer func() {
+			sitemapCacheMu.Lock()
+			delete(sitemapCacheRefreshing, key)
+			sitemapCacheMu.Unlock()
+		}()
+		xml, lastMod, err := generate()
+		if err != nil {
+			log15.Warn("sitemap: background regeneration failed", "sitemap", key, "error", err)
+			return
+		}
+		if _, err := sitemapCachePut(key, xml, lastMod); err != nil {
+			log15.Warn("sitemap: caching regenerated sitemap failed", "sitemap", key, "error", err)
+		}
+	}()
+}
+
+// serveCachedSitemap serves key's sitemap from the cache, generating
+// it synchronously only when there is no cached copy at all. The
+// cached body is gzipped; clients that accept gzip get the buffer
+// as-is, others get it decompressed on the fly (cheap next to
+// rendering). Conditional requests are answered from the entry's
+// last-modified date before any body is written.
+func serveCachedSitemap(w http.ResponseWriter, r *http.Request, key string, generate func() ([]byte, *time.Time, error)) error {
+	entry, fresh := sitemapCacheGet(key)
+	if entry == nil {
+		xml, lastMod, err := generate()
+		if err != nil {
+			return err
+		}
+		entry, err = sitemapCachePut(key, xml, lastMod)
+		if err != nil {
+			return err
+		}
+	} else if !fresh {
+		sitemapCacheRefresh(key, generate)
+	}
+
+	if sitemapNotModified(w, r, entry.lastMod) {
+		return nil
+	}
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, err := w.Write(entry.gzipped)
+		return err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(entry.gzipped))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, gz)
+	return err
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSitemapNotModified(t *testing.T) {
+	lastMod := time.Date(2016, 5, 19, 14, 5, 56, 0, time.UTC)
+
+	tests := []struct {
+		ifModSince string
+		lastMod    *time.Time
+		want       bool
+	}{
+		// No last-modified date known: always serve the full response.
+		{"", nil, false},
+		{lastMod.Format(http.TimeFormat), nil, false},
+		// No (or unparseable) client date: serve the full response.
+		{"", &lastMod, false},
+		{"bogus", &lastMod, false},
+		// Client copy as fresh as or fresher than the sitemap: 304.
+		{lastMod.Format(http.TimeFormat), &lastMod, true},
+		{lastMod.Add(time.Hour).Format(http.TimeFormat), &lastMod, true},
+		// Client copy stale: serve the full response.
+		{lastMod.Add(-time.Hour).Format(http.TimeFormat), &lastMod, false},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/sitemap-index.xml", nil)
+		if test.ifModSince != "" {
+			r.Header.Set("If-Modified-Since", test.ifModSince)
+		}
+		w := httptest.NewRecorder()
+		if got := sitemapNotModified(w, r, test.lastMod); got != test.want {
+			t.Errorf("If-Modified-Since %q, lastMod %v: got %v, want %v", test.ifModSince, test.lastMod, got, test.want)
+		}
+		if test.want && w.Code != http.StatusNotModified {
+			t.Errorf("If-Modified-Since %q: got status %d, want %d", test.ifModSince, w.Code, http.StatusNotModified)
+		}
+		if test.lastMod != nil && w.Header().Get("Last-Modified") == "" {
+			t.Errorf("If-Modified-Since %q: Last-Modified header not set", test.ifModSince)
+		}
+	}
+}
@@ -52,6 +52,9 @@ var tmplFuncs = htmpl.FuncMap{
 
 	"assetURL": assets.URL,
 
+	"ansiToHTML":     ansiToHTML,
+	"logLinesToHTML": logLinesToHTML,
+
 	"googleAnalyticsTrackingID": func() string { return appconf.Flags.GoogleAnalyticsTrackingID },
 
 	"deployedGitCommitID": func() string { return envutil.GitCommitID },
@@ -42,6 +42,15 @@ func init() {
 		log.Fatal(err)
 	}
 
+	_, err = buildsGroup.AddCommand("log",
+		"get build task log",
+		"The log subcommand fetches the log of a build task, resuming from the last seen entry if the connection drops.",
+		&buildsLogCmd{},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	_, err = buildsGroup.AddCommand("stats",
 		"get builds statistics",
 		"The stats subcommand displays statistics about previous and current builds.",
@@ -144,6 +153,78 @@ func ago(t time.Time) string {
 	return d.String()
 }
 
+type buildsLogCmd struct {
+	Follow   bool          `long:"follow" short:"f" description:"poll for new log entries until the build ends"`
+	Interval time.Duration `long:"interval" default:"2s" description:"polling interval in follow mode"`
+	Args     struct {
+		Repo string `name:"repo" description:"repo URI"`
+		ID   uint64 `name:"id" description:"build ID"`
+		Task uint64 `name:"task" description:"task ID"`
+	} `positional-args:"yes" required:"true"`
+}
+
+// logFetchMaxAttempts is how many times a single log fetch is retried
+// before giving up. Each retry resumes from the MaxID cursor of the
+// last successful fetch, so no entries are lost or duplicated across
+// connection drops.
+const logFetchMaxAttempts = 5
+
+func (c *buildsLogCmd) Execute(args []string) error {
+	cl := cliClient
+	task := sourcegraph.TaskSpec{
+		Build: sourcegraph.BuildSpec{
+			Repo: sourcegraph.RepoSpec{URI: c.Args.Repo},
+			ID:   c.Args.ID,
+		},
+		ID: c.Args.Task,
+	}
+
+	var minID string
+	for {
+		entries, err := fetchTaskLog(task, minID)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries.Entries {
+			fmt.Println(e)
+		}
+		if entries.MaxID != "" {
+			minID = entries.MaxID
+		}
+
+		if !c.Follow {
+			return nil
+		}
+		build, err := cl.Builds.Get(cliContext, &task.Build)
+		if err == nil && build.EndedAt != nil {
+			return nil
+		}
+		time.Sleep(c.Interval)
+	}
+}
+
+// fetchTaskLog fetches the log entries of task after minID, retrying
+// transient errors with a linear backoff so interrupted fetches resume
+// from their cursor instead of failing the whole stream.
+func fetchTaskLog(task sourcegraph.TaskSpec, minID string) (*sourcegraph.LogEntries, error) {
+	cl := cliClient
+	var lastErr error
+	for attempt := 0; attempt < logFetchMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		entries, err := cl.Builds.GetTaskLog(cliContext, &sourcegraph.BuildsGetTaskLogOp{
+			Task: task,
+			Opt:  &sourcegraph.BuildGetLogOptions{MinID: minID},
+		})
+		if err == nil {
+			return entries, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
 type buildsStatsCmd struct{}
 
 func (c *buildsStatsCmd) Execute(args []string) error {
@@ -49,6 +49,7 @@ import (
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/snapshotprof"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/statsutil"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/traceutil"
+	"sourcegraph.com/sourcegraph/sourcegraph/services/backend"
 	"sourcegraph.com/sourcegraph/sourcegraph/services/backend/server"
 	"sourcegraph.com/sourcegraph/sourcegraph/services/events"
 	"sourcegraph.com/sourcegraph/sourcegraph/services/httpapi"
@@ -447,6 +448,10 @@ func (c *ServeCmd) Execute(args []string) error {
 	}
 	repoupdater.RepoUpdater.Start(repoUpdaterCtx)
 
+	// Warm the sitemap repo cache (if enabled) so the first sitemap
+	// request after startup doesn't hit a cold cache.
+	backend.StartSitemapCacheWarmer(serverCtxFunc(context.Background()))
+
 	if c.NoWorker {
 		log15.Info("Skip starting worker process.")
 	} else {
@@ -0,0 +1,249 @@
+// Package buildsclient is a small typed Go client for the build
+// endpoints of the Sourcegraph HTTP API, for CLIs and automation that
+// would otherwise hand-craft HTTP requests against the build routes.
+// It wraps listing (with filters and pagination), fetching a build
+// with its computed status and tasks, creating builds (including dry
+// runs and deduplication), restarting from a failed task, and
+// fetching or following task logs.
+//
+// Authentication is cookie-based: anything with a HeaderValue method
+// returning a Cookie header value can be plugged in as the
+// CookieSource, e.g. the session cookies minted by the load-test
+// helpers in test/load, which also keep themselves refreshed.
+package buildsclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/go-querystring/query"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+// CookieSource supplies the Cookie header value used to authenticate
+// requests. *load.RefreshingCookie (test/load) implements it; so does
+// any fixed cookie wrapped in a trivial type.
+type CookieSource interface {
+	HeaderValue() string
+}
+
+// A Client talks to the build endpoints of one Sourcegraph server.
+// The zero value is not usable; use New.
+type Client struct {
+	// BaseURL is the root of the HTTP API, including the API path
+	// prefix (e.g. https://src.example.com/.api).
+	BaseURL *url.URL
+
+	// Cookie, if non-nil, authenticates every request.
+	Cookie CookieSource
+
+	// HTTPClient is the underlying HTTP client; http.DefaultClient if
+	// nil.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the HTTP API rooted at baseURL (including
+// the API path prefix, e.g. https://src.example.com/.api).
+func New(baseURL *url.URL) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// An APIError is returned for any response outside the 2xx range. The
+// body is included because the API puts the human-readable reason
+// there (e.g. which build was missing).
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("builds API: status %d: %s", e.StatusCode, e.Body)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// do performs one API request. A JSON body is sent if body is
+// non-nil, and a 2xx JSON response is decoded into out if out is
+// non-nil. The response is returned for access to headers; its body
+// is already consumed.
+func (c *Client) do(method, path string, q url.Values, body, out interface{}) (*http.Response, error) {
+	u := c.BaseURL.ResolveReference(&url.URL{Path: c.BaseURL.Path + path})
+	if q != nil {
+		u.RawQuery = q.Encode()
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	if c.Cookie != nil {
+		req.Header.Set("Cookie", c.Cookie.HeaderValue())
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp, &APIError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(data))}
+	}
+	switch out := out.(type) {
+	case nil:
+	case *rawBody:
+		*out = data
+	default:
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+func buildPath(repo string, id uint64) string {
+	return fmt.Sprintf("/repos/%s/-/builds/%d", repo, id)
+}
+
+// A BuildsPage is one page of a builds listing. More reports whether
+// another page exists; pass Next to List to fetch it.
+type BuildsPage struct {
+	Builds []*sourcegraph.Build
+	More   bool
+	Next   sourcegraph.ListOptions
+}
+
+// List returns one page of repo's builds matching opt. Pagination is
+// cursor-style from the caller's point of view: start with opt's
+// ListOptions zero, and pass page.Next while page.More is set.
+func (c *Client) List(repo string, opt *sourcegraph.BuildListOptions) (*BuildsPage, error) {
+	if opt == nil {
+		opt = &sourcegraph.BuildListOptions{}
+	}
+	q, err := query.Values(opt)
+	if err != nil {
+		return nil, err
+	}
+	var list sourcegraph.BuildList
+	if _, err := c.do("GET", "/repos/"+repo+"/-/builds", q, nil, &list); err != nil {
+		return nil, err
+	}
+	page := &BuildsPage{Builds: list.Builds, More: list.HasMore}
+	if list.HasMore {
+		page.Next = sourcegraph.ListOptions{
+			Page:    int32(opt.PageOrDefault()) + 1,
+			PerPage: opt.PerPage,
+		}
+	}
+	return page, nil
+}
+
+// Get returns one build.
+func (c *Client) Get(repo string, id uint64) (*sourcegraph.Build, error) {
+	var build sourcegraph.Build
+	if _, err := c.do("GET", buildPath(repo, id), nil, nil, &build); err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// A BuildDetail is the full view of a build: the build itself plus
+// its computed status, commit, queue position, and task tree, as
+// returned by the full=true build endpoint.
+type BuildDetail struct {
+	Build           *sourcegraph.Build
+	Status          string
+	Host            string
+	DurationSeconds float64
+	Commit          *vcs.Commit
+	QueuePosition   int
+	Tasks           []*TaskDetail
+}
+
+// A TaskDetail is one task of a BuildDetail, with its computed status
+// and the URL of its log.
+type TaskDetail struct {
+	Task   *sourcegraph.BuildTask
+	Status string
+	LogURL string
+}
+
+// GetDetail returns the full view of a build: status computed from
+// its tasks, its commit, queue position if queued, and the task list.
+func (c *Client) GetDetail(repo string, id uint64) (*BuildDetail, error) {
+	var detail BuildDetail
+	if _, err := c.do("GET", buildPath(repo, id), url.Values{"full": []string{"true"}}, nil, &detail); err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// CreateOptions modify Create. DryRun validates the op (including
+// resolving the ref) without creating a build. Dedupe returns an
+// existing queued or running build of the same commit and config
+// instead of creating a duplicate.
+type CreateOptions struct {
+	DryRun bool
+	Dedupe bool
+}
+
+// Create creates (and typically enqueues) a build of repo.
+func (c *Client) Create(repo string, op *sourcegraph.BuildsCreateOp, opt CreateOptions) (*sourcegraph.Build, error) {
+	q := url.Values{}
+	if opt.DryRun {
+		q.Set("dryRun", "true")
+	}
+	if opt.Dedupe {
+		q.Set("dedupe", "true")
+	}
+	var build sourcegraph.Build
+	if _, err := c.do("POST", "/repos/"+repo+"/-/builds", q, op, &build); err != nil {
+		return nil, err
+	}
+	return &build, nil
+}
+
+// A RestartResult reports the build created by Restart and which of
+// the original build's tasks were already known good.
+type RestartResult struct {
+	Build         *sourcegraph.Build
+	ReusableTasks []string
+}
+
+// Restart re-runs a build starting from the given task, recording the
+// tasks before it as reusable.
+func (c *Client) Restart(repo string, id, fromTask uint64) (*RestartResult, error) {
+	q := url.Values{"task": []string{strconv.FormatUint(fromTask, 10)}}
+	var res RestartResult
+	if _, err := c.do("POST", buildPath(repo, id)+"/restart", q, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
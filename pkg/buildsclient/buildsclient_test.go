@@ -0,0 +1,178 @@
+package buildsclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sqs/pbtypes"
+)
+
+type fixedCookie string
+
+func (c fixedCookie) HeaderValue() string { return string(c) }
+
+func newTestClient(t *testing.T, handler http.Handler) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	base, err := url.Parse(server.URL + "/.api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(base)
+	c.Cookie = fixedCookie("sess=abc")
+	return c, server
+}
+
+func TestList_pagination(t *testing.T) {
+	c, server := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.api/repos/r/r/-/builds" {
+			t.Errorf("got path %q", r.URL.Path)
+		}
+		if got := r.Header.Get("Cookie"); got != "sess=abc" {
+			t.Errorf("got cookie %q, want sess=abc", got)
+		}
+		page := r.URL.Query().Get("Page")
+		switch page {
+		case "", "1":
+			json.NewEncoder(w).Encode(&sourcegraph.BuildList{Builds: []*sourcegraph.Build{{ID: 1}}, StreamResponse: sourcegraph.StreamResponse{HasMore: true}})
+		case "2":
+			json.NewEncoder(w).Encode(&sourcegraph.BuildList{Builds: []*sourcegraph.Build{{ID: 2}}})
+		default:
+			t.Errorf("unexpected page %q", page)
+		}
+	}))
+	defer server.Close()
+
+	opt := &sourcegraph.BuildListOptions{Failed: true}
+	page, err := c.List("r/r", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Builds) != 1 || page.Builds[0].ID != 1 {
+		t.Errorf("got first page %+v", page.Builds)
+	}
+	if !page.More {
+		t.Fatal("expected more pages")
+	}
+
+	opt.ListOptions = page.Next
+	page, err = c.List("r/r", opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Builds) != 1 || page.Builds[0].ID != 2 {
+		t.Errorf("got second page %+v", page.Builds)
+	}
+	if page.More {
+		t.Error("expected last page")
+	}
+}
+
+func TestGetDetail(t *testing.T) {
+	c, server := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("full") != "true" {
+			t.Error("expected full=true")
+		}
+		json.NewEncoder(w).Encode(&BuildDetail{
+			Build:  &sourcegraph.Build{ID: 123, Repo: "r/r"},
+			Status: "failed",
+			Tasks:  []*TaskDetail{{Task: &sourcegraph.BuildTask{ID: 1}, Status: "failed"}},
+		})
+	}))
+	defer server.Close()
+
+	detail, err := c.GetDetail("r/r", 123)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Status != "failed" || len(detail.Tasks) != 1 {
+		t.Errorf("got detail %+v", detail)
+	}
+}
+
+func TestCreate_error(t *testing.T) {
+	c, server := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("dedupe") != "true" {
+			t.Error("expected dedupe=true")
+		}
+		http.Error(w, "no commit, branch, or tag to build", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := c.Create("r/r", &sourcegraph.BuildsCreateOp{}, CreateOptions{Dedupe: true})
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("got error %v, want *APIError", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want 400", apiErr.StatusCode)
+	}
+}
+
+func TestFollowTaskLog(t *testing.T) {
+	defer func(orig time.Duration) { FollowInterval = orig }(FollowInterval)
+	FollowInterval = time.Millisecond
+
+	end := pbtypes.NewTimestamp(time.Unix(100, 0))
+	fetches := 0
+	c, server := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.api/repos/r/r/-/builds/1" {
+			json.NewEncoder(w).Encode(&sourcegraph.Build{ID: 1, EndedAt: &end})
+			return
+		}
+		fetches++
+		switch fetches {
+		case 1:
+			if r.URL.Query().Get("MinID") != "" {
+				t.Errorf("first fetch got MinID %q, want none", r.URL.Query().Get("MinID"))
+			}
+			w.Header().Set("x-sourcegraph-log-max-id", "2")
+			fmt.Fprint(w, "a\nb")
+		case 2:
+			if got := r.URL.Query().Get("MinID"); got != "2" {
+				t.Errorf("second fetch got MinID %q, want 2", got)
+			}
+			w.Header().Set("x-sourcegraph-log-max-id", "3")
+			fmt.Fprint(w, "c")
+		default:
+			// No new lines; the build has ended, so the follow stops.
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	if err := c.FollowTaskLog("r/r", 1, 7, &out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\nb\nc\n"; out.String() != want {
+		t.Errorf("got output %q, want %q", out.String(), want)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	c, server := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/.api/repos/r/r/-/builds/123/restart" {
+			t.Errorf("got %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.URL.Query().Get("task"); got != "3" {
+			t.Errorf("got task %q, want 3", got)
+		}
+		json.NewEncoder(w).Encode(&RestartResult{Build: &sourcegraph.Build{ID: 124}, ReusableTasks: []string{"deps"}})
+	}))
+	defer server.Close()
+
+	res, err := c.Restart("r/r", 123, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Build.ID != 124 || !reflect.DeepEqual(res.ReusableTasks, []string{"deps"}) {
+		t.Errorf("got result %+v", res)
+	}
+}
@@ -0,0 +1,99 @@
+package buildsclient
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LogOptions filter a task log fetch. MinID is the follow cursor:
+// pass the MaxID of the previous fetch to receive only new lines.
+// Query filters to lines containing the query (with context), and
+// Tail keeps only the last N lines; neither affects MaxID, so a
+// follow can continue from the same cursor.
+type LogOptions struct {
+	MinID string
+	Query string
+	Tail  int
+}
+
+// A Log is one fetch of a task's log. MaxID is the cursor to pass as
+// the next fetch's MinID when following.
+type Log struct {
+	Lines []string
+	MaxID string
+}
+
+// TaskLog fetches the log of one task of a build.
+func (c *Client) TaskLog(repo string, build, task uint64, opt LogOptions) (*Log, error) {
+	q := url.Values{}
+	if opt.MinID != "" {
+		q.Set("MinID", opt.MinID)
+	}
+	if opt.Query != "" {
+		q.Set("q", opt.Query)
+	}
+	if opt.Tail > 0 {
+		q.Set("tail", strconv.Itoa(opt.Tail))
+	}
+
+	path := fmt.Sprintf("%s/tasks/%d/log", buildPath(repo, build), task)
+	var body rawBody
+	resp, err := c.do("GET", path, q, nil, &body)
+	if err != nil {
+		return nil, err
+	}
+	log := &Log{MaxID: resp.Header.Get("x-sourcegraph-log-max-id")}
+	if s := strings.TrimRight(string(body), "\n"); s != "" {
+		log.Lines = strings.Split(s, "\n")
+	}
+	return log, nil
+}
+
+// FollowInterval is how long FollowTaskLog waits between polls for
+// new log lines.
+var FollowInterval = 2 * time.Second
+
+// FollowTaskLog streams a task's log to w, polling for new lines
+// using the MaxID cursor, until the build has ended and no new lines
+// remain. stop, if non-nil, ends the follow early.
+func (c *Client) FollowTaskLog(repo string, build, task uint64, w io.Writer, stop <-chan struct{}) error {
+	var cursor string
+	for {
+		log, err := c.TaskLog(repo, build, task, LogOptions{MinID: cursor})
+		if err != nil {
+			return err
+		}
+		for _, line := range log.Lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		if log.MaxID != "" {
+			cursor = log.MaxID
+		}
+
+		if len(log.Lines) == 0 {
+			b, err := c.Get(repo, build)
+			if err != nil {
+				return err
+			}
+			if b.EndedAt != nil {
+				return nil
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(FollowInterval):
+		}
+	}
+}
+
+// rawBody makes do treat the response body as plain text instead of
+// JSON; the log endpoints return text/plain.
+type rawBody []byte
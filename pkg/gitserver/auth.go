@@ -0,0 +1,46 @@
+package gitserver
+
+import "sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+
+// HTTPSTokenProvider, if set, supplies HTTPS credentials for remote
+// operations whose caller did not provide any. It is consulted once
+// per client call (not once per process), so providers backed by
+// short-lived tokens — e.g. GitHub App installation tokens — can
+// refresh them as needed. The returned token is used as the password
+// via an askpass helper; it never appears in process arguments or
+// logs.
+//
+// Returning empty credentials with a nil error means "no credentials
+// for this repo"; the operation then proceeds unauthenticated, which
+// works for public remotes. A non-nil error aborts the operation.
+var HTTPSTokenProvider func(repo string) (user, token string, err error)
+
+// resolveHTTPSAuth returns the remote opts to send for a call against
+// repo, filling in credentials from HTTPSTokenProvider when the caller
+// supplied none. Explicit credentials in opt always win, so callers
+// acting on behalf of a specific user (e.g. with their OAuth token)
+// are unaffected. opt is never mutated; a copy is returned if
+// anything is added.
+func resolveHTTPSAuth(repo string, opt *vcs.RemoteOpts) (*vcs.RemoteOpts, error) {
+	if HTTPSTokenProvider == nil {
+		return opt, nil
+	}
+	if opt != nil && opt.HTTPS != nil && (opt.HTTPS.Pass != "" || opt.HTTPS.Token != "") {
+		return opt, nil
+	}
+
+	user, token, err := HTTPSTokenProvider(repo)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return opt, nil
+	}
+
+	resolved := vcs.RemoteOpts{}
+	if opt != nil {
+		resolved = *opt
+	}
+	resolved.HTTPS = &vcs.HTTPSConfig{User: user, Token: token}
+	return &resolved, nil
+}
@@ -0,0 +1,122 @@
+package gitserver
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+func TestResolveHTTPSAuth(t *testing.T) {
+	defer func(orig func(string) (string, string, error)) { HTTPSTokenProvider = orig }(HTTPSTokenProvider)
+
+	provider := func(repo string) (string, string, error) {
+		if repo != "my/repo" {
+			t.Errorf("provider called with repo %q, want my/repo", repo)
+		}
+		return "x-access-token", "tok123", nil
+	}
+
+	tests := []struct {
+		label    string
+		provider func(string) (string, string, error)
+		opt      *vcs.RemoteOpts
+		want     *vcs.RemoteOpts
+		wantErr  bool
+	}{
+		{
+			label: "no provider",
+			opt:   nil,
+			want:  nil,
+		},
+		{
+			label:    "provider fills nil opts",
+			provider: provider,
+			opt:      nil,
+			want:     &vcs.RemoteOpts{HTTPS: &vcs.HTTPSConfig{User: "x-access-token", Token: "tok123"}},
+		},
+		{
+			label:    "provider preserves other opts",
+			provider: provider,
+			opt:      &vcs.RemoteOpts{GitProtocolV2: true},
+			want:     &vcs.RemoteOpts{GitProtocolV2: true, HTTPS: &vcs.HTTPSConfig{User: "x-access-token", Token: "tok123"}},
+		},
+		{
+			label:    "explicit password wins",
+			provider: provider,
+			opt:      &vcs.RemoteOpts{HTTPS: &vcs.HTTPSConfig{Pass: "user-oauth"}},
+			want:     &vcs.RemoteOpts{HTTPS: &vcs.HTTPSConfig{Pass: "user-oauth"}},
+		},
+		{
+			label:    "explicit token wins",
+			provider: provider,
+			opt:      &vcs.RemoteOpts{HTTPS: &vcs.HTTPSConfig{Token: "caller-tok"}},
+			want:     &vcs.RemoteOpts{HTTPS: &vcs.HTTPSConfig{Token: "caller-tok"}},
+		},
+		{
+			label:    "provider has no credentials",
+			provider: func(string) (string, string, error) { return "", "", nil },
+			opt:      &vcs.RemoteOpts{GitProtocolV2: true},
+			want:     &vcs.RemoteOpts{GitProtocolV2: true},
+		},
+		{
+			label:    "provider error aborts",
+			provider: func(string) (string, string, error) { return "", "", errors.New("token expired") },
+			opt:      nil,
+			wantErr:  true,
+		},
+	}
+	for _, test := range tests {
+		HTTPSTokenProvider = test.provider
+		got, err := resolveHTTPSAuth("my/repo", test.opt)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: got nil error, want one", test.label)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.label, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.label, got, test.want)
+		}
+		if test.opt != nil && got != test.opt && test.opt.HTTPS != nil && got.HTTPS == test.opt.HTTPS {
+			t.Errorf("%s: caller's opts were mutated", test.label)
+		}
+	}
+}
+
+func TestMakeGitPassHelper_username(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	helper, dir, err := makeGitPassHelper("x-access-token", "tok123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(helper)
+	if dir != "" {
+		defer os.RemoveAll(dir)
+	}
+
+	prompts := map[string]string{
+		"Username for 'https://github.com':":                "x-access-token",
+		"Password for 'https://x-access-token@github.com':": "tok123",
+	}
+	for prompt, want := range prompts {
+		out, err := exec.Command(helper, prompt).Output()
+		if err != nil {
+			t.Fatalf("prompt %q: %s", prompt, err)
+		}
+		if string(out) != want {
+			t.Errorf("prompt %q: got %q, want %q", prompt, out, want)
+		}
+	}
+}
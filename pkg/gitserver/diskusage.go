@@ -0,0 +1,240 @@
+package gitserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+type listReposRequest struct {
+	// Repo, if non-empty, limits the reply to that one repository
+	// (for DiskUsage); otherwise every repository on the server is
+	// listed.
+	Repo string
+	// Fresh skips the server's size cache and recomputes the size,
+	// for callers measuring the effect of an operation (e.g. GC).
+	Fresh     bool
+	ReplyChan chan<- *listReposReply
+}
+
+type listReposReply struct {
+	RepoNotFound bool // only meaningful when the request named a repo
+	Repos        []*RepoInfo
+	Error        string
+}
+
+func (r *listReposReply) repoFound() bool { return !r.RepoNotFound }
+
+// RepoInfo describes one repository on a gitserver's disk.
+type RepoInfo struct {
+	URI       string
+	SizeBytes int64
+}
+
+// repoSizeCacheTTL is how long a computed repo size is reused before
+// the repo's tree is walked again. Sizes move slowly, and walking a
+// huge repo's object store on every call would be wasteful.
+var repoSizeCacheTTL = conf.GetenvDurationOrDefault("SG_REPO_SIZE_CACHE_TTL", "5m")
+
+var (
+	repoSizeMu    sync.Mutex
+	repoSizeCache = map[string]repoSizeEntry{}
+)
+
+type repoSizeEntry struct {
+	size int64
+	at   time.Time
+}
+
+// cachedRepoSize returns the size on disk of the repo at dir,
+// recomputing it when the cached value is older than
+// repoSizeCacheTTL.
+func cachedRepoSize(dir string) (int64, error) {
+	return repoSize(dir, false)
+}
+
+// repoSize is cachedRepoSize with an option to skip the cache; a
+// fresh computation still refreshes the cached entry.
+func repoSize(dir string, fresh bool) (int64, error) {
+	if !fresh {
+		repoSizeMu.Lock()
+		if e, ok := repoSizeCache[dir]; ok && time.Since(e.at) < repoSizeCacheTTL {
+			repoSizeMu.Unlock()
+			return e.size, nil
+		}
+		repoSizeMu.Unlock()
+	}
+
+	size, err := dirSize(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	repoSizeMu.Lock()
+	repoSizeCache[dir] = repoSizeEntry{size: size, at: time.Now()}
+	repoSizeMu.Unlock()
+	return size, nil
+}
+
+// dirSize returns the total size in bytes of the regular files under
+// dir. Files removed mid-walk (e.g. by a concurrent git gc) are
+// skipped rather than failing the walk.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func handleListReposRequest(req *listReposRequest) {
+	defer recoverAndLog()
+	defer close(req.ReplyChan)
+
+	if !beginRequest() {
+		return
+	}
+	defer endRequest()
+
+	if req.Repo != "" {
+		dir, err := safeRepoDir(req.Repo)
+		if err != nil || !repoExists(dir) {
+			req.ReplyChan <- &listReposReply{RepoNotFound: true}
+			return
+		}
+		size, err := repoSize(dir, req.Fresh)
+		if err != nil {
+			req.ReplyChan <- &listReposReply{Error: err.Error()}
+			return
+		}
+		req.ReplyChan <- &listReposReply{Repos: []*RepoInfo{{URI: req.Repo, SizeBytes: size}}}
+		return
+	}
+
+	repos, err := listReposOnDisk()
+	if err != nil {
+		req.ReplyChan <- &listReposReply{Error: err.Error()}
+		return
+	}
+	req.ReplyChan <- &listReposReply{Repos: repos}
+}
+
+// listReposOnDisk walks ReposDir and returns every repository found
+// with its size. Directories inside a repository are not descended
+// into, so nested paths like github.com/user/repo cost one stat per
+// path segment, not a full walk.
+func listReposOnDisk() ([]*RepoInfo, error) {
+	var repos []*RepoInfo
+	err := filepath.Walk(ReposDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || path == ReposDir {
+			return nil
+		}
+		if !repoExists(path) {
+			return nil
+		}
+		uri, err := filepath.Rel(ReposDir, path)
+		if err != nil {
+			return err
+		}
+		size, err := cachedRepoSize(path)
+		if err != nil {
+			return err
+		}
+		repos = append(repos, &RepoInfo{URI: filepath.ToSlash(uri), SizeBytes: size})
+		return filepath.SkipDir
+	})
+	return repos, err
+}
+
+// DiskUsage returns the size on disk, in bytes, of repo on the
+// gitserver that has it. The size is cached briefly on the server
+// (see repoSizeCacheTTL).
+func DiskUsage(repo string) (int64, error) {
+	return diskUsage(repo, false)
+}
+
+func diskUsage(repo string, fresh bool) (int64, error) {
+	genReply, err := broadcastCall(func() (*request, func() (genericReply, bool)) {
+		replyChan := make(chan *listReposReply, 1)
+		return &request{ListRepos: &listReposRequest{Repo: repo, Fresh: fresh, ReplyChan: replyChan}},
+			func() (genericReply, bool) { reply, ok := <-replyChan; return reply, ok }
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	reply := genReply.(*listReposReply)
+	if reply.Error != "" {
+		return 0, errors.New(reply.Error)
+	}
+	if len(reply.Repos) != 1 {
+		return 0, vcs.RepoNotExistError{}
+	}
+	return reply.Repos[0].SizeBytes, nil
+}
+
+// ListRepos enumerates the repositories on every connected gitserver
+// with their sizes on disk, sorted by URI. It is intended for
+// capacity planning, not the hot path.
+func ListRepos() ([]*RepoInfo, error) {
+	allReplies := make(chan *listReposReply, len(servers))
+	delivered := 0
+	for _, server := range servers {
+		replyChan := make(chan *listReposReply, 1)
+		if !deliver(server, &request{ListRepos: &listReposRequest{ReplyChan: replyChan}}) {
+			continue
+		}
+		delivered++
+		go func() {
+			reply, ok := <-replyChan
+			if !ok {
+				allReplies <- nil
+				return
+			}
+			allReplies <- reply
+		}()
+	}
+
+	var repos []*RepoInfo
+	rpcError := false
+	for i := 0; i < delivered; i++ {
+		reply := <-allReplies
+		if reply == nil || reply.Error != "" {
+			rpcError = true
+			continue
+		}
+		repos = append(repos, reply.Repos...)
+	}
+	if rpcError {
+		return nil, errRPCFailed
+	}
+	sort.Sort(repoInfoByURI(repos))
+	return repos, nil
+}
+
+type repoInfoByURI []*RepoInfo
+
+func (v repoInfoByURI) Len() int           { return len(v) }
+func (v repoInfoByURI) Less(i, j int) bool { return v[i].URI < v[j].URI }
+func (v repoInfoByURI) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
@@ -0,0 +1,80 @@
+package gitserver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testHEAD = "ref: refs/heads/master\n"
+
+// mkRepo creates a fake bare repository at dir containing a HEAD file
+// and extra bytes of pack data, and returns its total size on disk.
+func mkRepo(t *testing.T, dir string, extra int) int64 {
+	if err := os.MkdirAll(filepath.Join(dir, "objects", "pack"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "HEAD"), []byte(testHEAD), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "objects", "pack", "pack-1.pack"), bytes.Repeat([]byte("x"), extra), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return int64(len(testHEAD) + extra)
+}
+
+func TestListReposOnDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitserver-diskusage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	origReposDir := ReposDir
+	ReposDir = dir
+	defer func() { ReposDir = origReposDir }()
+
+	wantAB := mkRepo(t, filepath.Join(dir, "a/b"), 4)
+	wantGH := mkRepo(t, filepath.Join(dir, "github.com/u/r"), 10)
+	// A directory without a HEAD is not a repository.
+	if err := os.MkdirAll(filepath.Join(dir, "github.com/notarepo"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, err := listReposOnDisk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 {
+		t.Fatalf("got %d repos, want 2: %v", len(repos), repos)
+	}
+	if repos[0].URI != "a/b" || repos[0].SizeBytes != wantAB {
+		t.Errorf("got %s (%d bytes), want a/b (%d bytes)", repos[0].URI, repos[0].SizeBytes, wantAB)
+	}
+	if repos[1].URI != "github.com/u/r" || repos[1].SizeBytes != wantGH {
+		t.Errorf("got %s (%d bytes), want github.com/u/r (%d bytes)", repos[1].URI, repos[1].SizeBytes, wantGH)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		if req.ListRepos.Repo != "r/r" {
+			t.Errorf("got repo %q, want r/r", req.ListRepos.Repo)
+		}
+		req.ListRepos.ReplyChan <- &listReposReply{Repos: []*RepoInfo{{URI: "r/r", SizeBytes: 42}}}
+		close(req.ListRepos.ReplyChan)
+	}()
+
+	size, err := DiskUsage("r/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 42 {
+		t.Errorf("got size %d, want 42", size)
+	}
+}
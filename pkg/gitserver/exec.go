@@ -1,35 +1,121 @@
 package gitserver
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
-	"path"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/neelance/chanrpc/chanrpcutil"
 	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/inconshreveable/log15.v2"
 
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/repotrackutil"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/statsutil"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
 )
 
 type execRequest struct {
-	Repo      string
-	Args      []string
-	Opt       *vcs.RemoteOpts
+	Repo string
+	Args []string
+	Opt  *vcs.RemoteOpts
+	// Env is a list of "KEY=VALUE" environment variable assignments
+	// to forward to the git command. Only variables named in
+	// execEnvAllowlist are honored; everything else is silently
+	// dropped so clients can't inject arbitrary variables into the
+	// server's git processes.
+	Env []string
+	// Timeout, if nonzero, kills the git command if it runs longer
+	// than this. See Cmd.Timeout.
+	Timeout time.Duration
+	// CombinedOutput, if true, writes stderr to the same stream as
+	// stdout so the two are interleaved in the order git produced
+	// them. The combined stream is returned on the reply's Stdout
+	// (with Combined set) and Stderr is empty.
+	CombinedOutput bool
+	// ExpectLayout, if "bare" or "worktree", asserts the repository's
+	// on-disk layout before running the command; a mismatch fails the
+	// request instead of letting the command silently operate on the
+	// wrong layout. See Cmd.ExpectLayout.
+	ExpectLayout string
+	// TraceSpan is the originating request's appdash span ID in string
+	// form, or empty if the request is untraced. The server records a
+	// child span around the git execution. See Cmd.TraceSpan.
+	TraceSpan string
 	Stdin     <-chan []byte
 	ReplyChan chan<- *execReply
 }
 
+// execEnvAllowlist is the set of client-supplied environment
+// variables that Git.Exec forwards to the git command, so clients can
+// enable proxying or tracing per-call.
+var execEnvAllowlist = map[string]bool{
+	"GIT_LFS_SKIP_SMUDGE": true,
+	"GIT_TRACE":           true,
+	"GIT_TRACE_PACKET":    true,
+	"HTTP_PROXY":          true,
+	"HTTPS_PROXY":         true,
+	"NO_PROXY":            true,
+	"http_proxy":          true,
+	"https_proxy":         true,
+	"no_proxy":            true,
+}
+
+// filterExecEnv returns the entries of env whose variable names are
+// in execEnvAllowlist.
+func filterExecEnv(env []string) []string {
+	var filtered []string
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		if i == -1 {
+			continue
+		}
+		if execEnvAllowlist[kv[:i]] {
+			filtered = append(filtered, kv)
+		}
+	}
+	return filtered
+}
+
 type execReply struct {
 	RepoNotFound    bool // If true, exec returned with noop because repo is not found.
 	CloneInProgress bool // If true, exec returned with noop because clone is in progress.
-	Stdout          <-chan []byte
-	Stderr          <-chan []byte
-	ProcessResult   <-chan *processResult
+	// StdoutGzipped and StderrGzipped indicate that the corresponding
+	// stream was gzip-compressed before crossing the RPC wire and
+	// must be decompressed by the receiver. See maybeCompress.
+	StdoutGzipped bool
+	StderrGzipped bool
+	// StdoutTruncated and StderrTruncated indicate that the
+	// corresponding stream exceeded MaxOutputSize and was cut off at
+	// that limit.
+	StdoutTruncated bool
+	StderrTruncated bool
+	// TimedOut indicates the command exceeded the request's Timeout
+	// and was killed.
+	TimedOut bool
+	// WrongLayout indicates the request asserted a repository layout
+	// (ExpectLayout) that doesn't match the layout on disk, reported
+	// in ActualLayout. The command was not run.
+	WrongLayout  bool
+	ActualLayout string
+	// Combined indicates the request asked for combined output:
+	// Stdout carries stdout and stderr interleaved in chronological
+	// order, and Stderr is empty.
+	Combined      bool
+	Stdout        <-chan []byte
+	Stderr        <-chan []byte
+	ProcessResult <-chan *processResult
 }
 
 func (r *execReply) repoFound() bool { return !r.RepoNotFound }
@@ -43,11 +129,32 @@ func handleExecRequest(req *execRequest) {
 	start := time.Now()
 	status := ""
 
+	// Log each exec under a request ID so concurrent executions for
+	// the same repo can be told apart when tracing an issue.
+	id := newRequestID()
+	log15.Debug("gitserver: exec", "id", id, "repo", req.Repo, "args", strings.Join(req.Args, " "))
+
 	defer recoverAndLog()
 	defer close(req.ReplyChan)
-	defer func() { observeExec(req, start, status) }()
+	defer func() {
+		observeExec(req, start, status)
+		log15.Debug("gitserver: exec done", "id", id, "repo", req.Repo, "duration", time.Since(start), "status", status)
+	}()
 
-	dir := path.Join(ReposDir, req.Repo)
+	if !beginRequest() {
+		chanrpcutil.Drain(req.Stdin)
+		status = "draining"
+		return
+	}
+	defer endRequest()
+
+	dir, err := safeRepoDir(req.Repo)
+	if err != nil {
+		chanrpcutil.Drain(req.Stdin)
+		req.ReplyChan <- &execReply{RepoNotFound: true}
+		status = "invalid-repo-path"
+		return
+	}
 	cloningMu.Lock()
 	_, cloneInProgress := cloning[dir]
 	cloningMu.Unlock()
@@ -63,50 +170,429 @@ func handleExecRequest(req *execRequest) {
 		status = "repo-not-found"
 		return
 	}
+	if req.ExpectLayout != "" {
+		if layout := repoLayout(dir); layout != req.ExpectLayout {
+			chanrpcutil.Drain(req.Stdin)
+			req.ReplyChan <- &execReply{WrongLayout: true, ActualLayout: layout}
+			status = "wrong-layout"
+			return
+		}
+	}
+
+	if key, ok := coalesceKey(req); ok {
+		execFlightMu.Lock()
+		if call, inFlight := execFlight[key]; inFlight {
+			execFlightMu.Unlock()
+			chanrpcutil.Drain(req.Stdin)
+			<-call.done
+			status = sendExecReply(req.ReplyChan, call.res)
+			return
+		}
+		call := &execFlightCall{done: make(chan struct{})}
+		execFlight[key] = call
+		execFlightMu.Unlock()
+
+		call.res = runGitCommand(req, dir)
+		execFlightMu.Lock()
+		delete(execFlight, key)
+		execFlightMu.Unlock()
+		close(call.done)
+
+		status = sendExecReply(req.ReplyChan, call.res)
+		return
+	}
+
+	status = sendExecReply(req.ReplyChan, runGitCommand(req, dir))
+}
+
+// execResult holds the outcome of one git subprocess so it can be
+// replied to one or (when coalesced) several clients.
+type execResult struct {
+	stdout, stderr                   []byte
+	stdoutGzipped, stderrGzipped     bool
+	stdoutTruncated, stderrTruncated bool
+	timedOut                         bool
+	combined                         bool
+	errStr                           string
+	exitStatus                       int
+}
+
+// MaxOutputSize is the most output, in bytes, that a single git
+// command may return on each of stdout and stderr. Output beyond it is
+// dropped and the reply is flagged as truncated, so a runaway command
+// (e.g. `git log` on a pathological repo) can't OOM the gitserver or
+// the frontend. Callers that legitimately need more should page their
+// output.
+var MaxOutputSize int64 = 100 << 20 // 100 MiB
+
+// MaxStdinSize is the most input, in bytes, that a single git command
+// may consume from its RPC stdin stream. Streaming keeps any one
+// chunk small, but without a total cap a client could still push
+// unbounded data through to the git subprocess (and to whatever it
+// does with it). A command that hits the cap fails with an error
+// naming the limit rather than consuming more; operators with
+// legitimately larger inputs can raise it.
+var MaxStdinSize int64 = 1 << 30 // 1 GiB
+
+var errStdinTooLarge = errors.New("gitserver: stdin too large")
+
+// limitedStdinReader passes through at most max bytes of the
+// underlying reader and then fails instead of serving more, so the
+// git command errors out rather than running on silently truncated
+// input.
+type limitedStdinReader struct {
+	r        io.Reader
+	max      int64 // bytes remaining before the cap
+	exceeded bool
+}
+
+func (l *limitedStdinReader) Read(p []byte) (int, error) {
+	if l.max <= 0 {
+		// At the cap. Input that is exactly the cap's size is fine;
+		// only fail if the stream has more data.
+		var b [1]byte
+		if n, err := l.r.Read(b[:]); n == 0 {
+			return 0, err
+		}
+		l.exceeded = true
+		return 0, errStdinTooLarge
+	}
+	if int64(len(p)) > l.max {
+		p = p[:l.max]
+	}
+	n, err := l.r.Read(p)
+	l.max -= int64(n)
+	return n, err
+}
+
+// limitedBuffer buffers writes up to max bytes and silently discards
+// the rest, recording that truncation happened. It never returns a
+// write error, so the subprocess runs to completion (stopping the copy
+// mid-stream would leave it blocked on a full pipe).
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if remaining := b.max - int64(b.Len()); int64(n) > remaining {
+		b.truncated = true
+		if remaining <= 0 {
+			return n, nil
+		}
+		p = p[:remaining]
+	}
+	b.Buffer.Write(p) // writes to a bytes.Buffer never fail
+	return n, nil
+}
 
-	stdoutC, stdoutW := chanrpcutil.NewWriter()
-	stderrC, stderrW := chanrpcutil.NewWriter()
+// runGitCommand runs the requested git command in dir and consumes the
+// request's stdin stream.
+func runGitCommand(req *execRequest, dir string) *execResult {
+	defer traceExec(req, time.Now())
+	defer lockRepoForWrite(dir, req.Args)()
+
+	stdoutBuf := &limitedBuffer{max: MaxOutputSize}
+	stderrBuf := &limitedBuffer{max: MaxOutputSize}
 
 	cmd := exec.Command("git", req.Args...)
 	cmd.Dir = dir
-	cmd.Stdin = chanrpcutil.NewReader(req.Stdin)
-	cmd.Stdout = stdoutW
-	cmd.Stderr = stderrW
-
-	processResultChan := make(chan *processResult, 1)
-	req.ReplyChan <- &execReply{
-		Stdout:        stdoutC,
-		Stderr:        stderrC,
-		ProcessResult: processResultChan,
+	stdin := &limitedStdinReader{r: chanrpcutil.NewReader(req.Stdin), max: MaxStdinSize}
+	cmd.Stdin = stdin
+	cmd.Stdout = stdoutBuf
+	cmd.Stderr = stderrBuf
+	if req.CombinedOutput {
+		// os/exec serializes writes when stdout and stderr are the
+		// same writer, so chunks land in the order git wrote them.
+		cmd.Stderr = stdoutBuf
 	}
+	// Never smudge LFS pointers during ordinary commands: repos that
+	// use LFS would otherwise fail or block on downloads the caller
+	// didn't ask for. Callers that want blob content use LFSFetch (or
+	// override via the env allowlist).
+	cmd.Env = append(os.Environ(), "GIT_LFS_SKIP_SMUDGE=1")
+	cmd.Env = append(cmd.Env, filterExecEnv(req.Env)...)
 
-	var errStr string
-	var exitStatus int
+	res := &execResult{}
+	var timedOut int32
+	if req.Timeout > 0 {
+		timer := time.AfterFunc(req.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			if p := cmd.Process; p != nil {
+				p.Kill()
+			}
+		})
+		defer timer.Stop()
+	}
+	trackCmd(cmd)
 	if err := runWithRemoteOpts(cmd, req.Opt); err != nil {
-		errStr = err.Error()
+		res.errStr = err.Error()
 	}
+	untrackCmd(cmd)
+	if stdin.exceeded {
+		// Git may have exited cleanly on the truncated input, but the
+		// command did not see everything the client sent, so it must
+		// not be reported as having succeeded.
+		res.errStr = fmt.Sprintf("gitserver: stdin exceeded the %d-byte limit (MaxStdinSize)", MaxStdinSize)
+	}
+	res.timedOut = atomic.LoadInt32(&timedOut) == 1
 	if cmd.ProcessState != nil { // is nil if process failed to start
-		exitStatus = cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
+		res.exitStatus = cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
 	}
 
 	chanrpcutil.Drain(req.Stdin)
-	stdoutW.Close()
-	stderrW.Close()
 
+	res.stdout, res.stdoutGzipped = maybeCompress(stdoutBuf.Bytes())
+	res.stderr, res.stderrGzipped = maybeCompress(stderrBuf.Bytes())
+	res.stdoutTruncated = stdoutBuf.truncated
+	res.stderrTruncated = stderrBuf.truncated
+	res.combined = req.CombinedOutput
+	return res
+}
+
+func sendExecReply(replyChan chan<- *execReply, res *execResult) (status string) {
+	processResultChan := make(chan *processResult, 1)
 	processResultChan <- &processResult{
-		Error:      errStr,
-		ExitStatus: exitStatus,
+		Error:      res.errStr,
+		ExitStatus: res.exitStatus,
 	}
 	close(processResultChan)
-	status = strconv.Itoa(exitStatus)
+
+	replyChan <- &execReply{
+		StdoutGzipped:   res.stdoutGzipped,
+		StderrGzipped:   res.stderrGzipped,
+		StdoutTruncated: res.stdoutTruncated,
+		StderrTruncated: res.stderrTruncated,
+		TimedOut:        res.timedOut,
+		Combined:        res.combined,
+		Stdout:          chanrpcutil.ToChunks(res.stdout),
+		Stderr:          chanrpcutil.ToChunks(res.stderr),
+		ProcessResult:   processResultChan,
+	}
+	return strconv.Itoa(res.exitStatus)
+}
+
+// execCoalescable is the set of git commands whose concurrent
+// identical invocations may share one subprocess and result. They must
+// be idempotent reads (or fetches, which converge to the same state);
+// mutating commands must never be listed here.
+var execCoalescable = map[string]bool{
+	"fetch":     true,
+	"ls-remote": true,
+	"rev-parse": true,
+	"show-ref":  true,
+	"log":       true,
+	"show":      true,
+	"diff":      true,
+}
+
+// Coalesced in-flight exec calls, keyed by repo and args. Concurrent
+// identical requests for a coalescable command wait for the first
+// one's subprocess instead of spawning their own.
+var (
+	execFlightMu sync.Mutex
+	execFlight   = make(map[string]*execFlightCall)
+)
+
+type execFlightCall struct {
+	done chan struct{} // closed once res is set
+	res  *execResult
+}
+
+// coalesceKey returns the coalescing key for req, or false if the
+// request must not be coalesced: commands outside execCoalescable,
+// requests with extra environment variables, and requests for
+// combined output (all of which change the result) run their own
+// subprocess. RemoteOpts is deliberately not part of the key; it only
+// carries credentials, which don't change what an identical fetch
+// produces.
+func coalesceKey(req *execRequest) (string, bool) {
+	if len(req.Args) == 0 || !execCoalescable[req.Args[0]] || len(req.Env) > 0 || req.CombinedOutput || req.ExpectLayout != "" {
+		return "", false
+	}
+	return req.Repo + "\x00" + strings.Join(req.Args, "\x00"), true
+}
+
+// execMutating is the set of git commands that write to the repo
+// (refs, objects, or config). Git guards such writes with lock files
+// (index.lock, shallow.lock, packed-refs.lock, ...), so two of them
+// running concurrently in the same repo make one fail spuriously with
+// "Unable to create ... .lock: File exists". Read-only commands are
+// safe to run concurrently and are deliberately absent.
+var execMutating = map[string]bool{
+	"fetch":        true,
+	"remote":       true,
+	"gc":           true,
+	"repack":       true,
+	"pack-refs":    true,
+	"prune":        true,
+	"update-ref":   true,
+	"symbolic-ref": true,
+	"config":       true,
+}
+
+// Per-repo write locks, keyed by the repo dir from safeRepoDir. The
+// map is never pruned; it holds one bare mutex per repo that has ever
+// been written to, which is bounded by the repos on disk.
+var (
+	repoWriteMusMu sync.Mutex
+	repoWriteMus   = make(map[string]*sync.Mutex)
+)
+
+// lockRepoForWrite serializes mutating git commands per repo: if args
+// names a command in execMutating it blocks until no other mutating
+// command is running in dir, then returns the func that releases the
+// lock. For read-only commands it returns a no-op immediately, so
+// reads never wait on each other (or on writers).
+func lockRepoForWrite(dir string, args []string) (unlock func()) {
+	if len(args) == 0 || !execMutating[args[0]] {
+		return func() {}
+	}
+	repoWriteMusMu.Lock()
+	mu, ok := repoWriteMus[dir]
+	if !ok {
+		mu = new(sync.Mutex)
+		repoWriteMus[dir] = mu
+	}
+	repoWriteMusMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// execCompressionThreshold is the minimum output size, in bytes, at
+// which exec output is gzip-compressed before crossing the RPC wire.
+// Compressing tiny outputs costs more than it saves.
+const execCompressionThreshold = 64 * 1024
+
+// maybeCompress gzip-compresses b if it is large enough to be worth
+// it and actually shrinks. The bool reports whether compression was
+// applied.
+func maybeCompress(b []byte) ([]byte, bool) {
+	if len(b) < execCompressionThreshold {
+		return b, false
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(b) // writes to a bytes.Buffer never fail
+	gz.Close()
+	if buf.Len() >= len(b) {
+		// Incompressible payload (e.g. packfile data); send it as is.
+		return b, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompress reverses maybeCompress for a payload it compressed.
+func decompress(b []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return ioutil.ReadAll(gz)
 }
 
 type Cmd struct {
-	Args       []string
-	Repo       string
-	Opt        *vcs.RemoteOpts
-	Input      []byte
+	Args []string
+	Repo string
+	Opt  *vcs.RemoteOpts
+	// Env is a list of "KEY=VALUE" environment variable assignments
+	// to forward to the git command on the server. Only variables in
+	// the server's allowlist (GIT_TRACE, HTTP_PROXY, ...) take
+	// effect.
+	Env   []string
+	Input []byte
+	// Timeout, if nonzero, kills the command on the server if it runs
+	// longer than this, and the Cmd returns ErrTimeout. It is a
+	// per-call ceiling complementing context cancellation; see
+	// TimeoutForRepoSize for choosing a value scaled to the repo.
+	Timeout    time.Duration
 	ExitStatus int
+
+	// stdin, if non-nil, streams the remote git process's stdin; it
+	// is set by StdinPipe and takes precedence over Input.
+	stdin <-chan []byte
+
+	// combineOutput, set by CombinedOutputOrdered, makes the server
+	// write stderr to the same stream as stdout.
+	combineOutput bool
+
+	// ExpectLayout, if "bare" or "worktree", asserts the repository's
+	// on-disk layout before the command runs. ReposDir mixes bare
+	// mirrors with worktree checkouts, and some git commands behave
+	// differently (or destructively) on the wrong kind; a caller that
+	// knows what it expects gets a *WrongLayoutError instead of a
+	// command that silently did the wrong thing.
+	ExpectLayout string
+
+	// TraceSpan, if set, ties the server-side git execution to the
+	// originating request's appdash trace as a child span. Set it to
+	// TraceSpanFromContext(ctx). Coalesced executions are recorded
+	// under the span of the request that actually ran the command.
+	TraceSpan string
+
+	// Idempotent, if set by the caller, marks the command as safe to
+	// re-run from scratch when the call fails at the transport layer
+	// (no server produced a definitive answer, e.g. during a
+	// gitserver restart), so a transient blip doesn't fail the whole
+	// page load. The whole command is re-sent with backoff, on top of
+	// the cheaper per-connection retry in broadcastCallRetry. Only
+	// mark read-only commands; commands in execMutating and commands
+	// with a streamed stdin are never re-sent regardless.
+	Idempotent bool
+}
+
+// WrongLayoutError is returned by Cmd methods when ExpectLayout was
+// set and the repository's actual on-disk layout doesn't match.
+type WrongLayoutError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *WrongLayoutError) Error() string {
+	return fmt.Sprintf("gitserver: repository is %s, expected %s", e.Actual, e.Expected)
+}
+
+// StdinPipe returns a writer that streams data to the remote git
+// process's stdin, so commands that read large input (e.g. `git
+// cat-file --batch`) don't require materializing it all up front. It
+// must be called before the command is started, may be called at most
+// once, and the returned writer must be closed to signal EOF to git.
+// If StdinPipe is used, Input is ignored. Writes may block until the
+// command has been started (e.g. via Run or DividedOutput) and the
+// servers consume the data, so write from a separate goroutine.
+func (c *Cmd) StdinPipe() io.WriteCloser {
+	if c.stdin != nil {
+		panic("gitserver: StdinPipe called twice")
+	}
+	stdinC, stdinW := chanrpcutil.NewWriter()
+	c.stdin = stdinC
+	return stdinW
+}
+
+// teeChunks forwards every chunk received on src to n new channels,
+// closing them when src is closed. Chunks are shared, not copied;
+// receivers must not modify them.
+func teeChunks(src <-chan []byte, n int) []<-chan []byte {
+	out := make([]chan []byte, n)
+	recv := make([]<-chan []byte, n)
+	for i := range out {
+		out[i] = make(chan []byte, 10)
+		recv[i] = out[i]
+	}
+	go func() {
+		for chunk := range src {
+			for _, c := range out {
+				c <- chunk
+			}
+		}
+		for _, c := range out {
+			close(c)
+		}
+	}()
+	return recv
 }
 
 func Command(name string, arg ...string) *Cmd {
@@ -118,12 +604,88 @@ func Command(name string, arg ...string) *Cmd {
 	}
 }
 
+// Retry policy for commands marked Idempotent: how many times the
+// whole command is re-sent after a transport-level failure, and the
+// initial delay between attempts (doubled each time, so a restarting
+// gitserver gets a chance to come back).
+var (
+	execRetryAttempts = conf.GetenvIntOrDefault("SG_GITSERVER_EXEC_RETRIES", 2)
+	execRetryBackoff  = conf.GetenvDurationOrDefault("SG_GITSERVER_EXEC_RETRY_BACKOFF", "1s")
+)
+
+// isTransportError reports whether err means no server produced a
+// definitive answer for the command (as opposed to the command
+// running and failing), so re-sending it can succeed.
+func isTransportError(err error) bool {
+	return err == errRPCFailed || err == ErrServerBusy
+}
+
 func (c *Cmd) DividedOutput() ([]byte, []byte, error) {
-	genReply, err := broadcastCall(func() (*request, func() (genericReply, bool)) {
+	stdout, stderr, err := c.dividedOutput()
+	// Re-sending is only safe for explicitly marked read-only
+	// commands; a streamed stdin was consumed by the first attempt,
+	// and a command in execMutating may already have taken effect
+	// (marking one Idempotent is a caller bug, checked here as a
+	// safety net).
+	if !c.Idempotent || c.stdin != nil || (len(c.Args) > 1 && execMutating[c.Args[1]]) {
+		return stdout, stderr, err
+	}
+	backoff := execRetryBackoff
+	for attempt := 0; attempt < execRetryAttempts && isTransportError(err); attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		stdout, stderr, err = c.dividedOutput()
+	}
+	return stdout, stderr, err
+}
+
+func (c *Cmd) dividedOutput() ([]byte, []byte, error) {
+	// The request is broadcast to every server, but a StdinPipe stream
+	// can only be consumed once, so tee it into one channel per
+	// server. The servers that don't have the repo drain their copy.
+	switch c.ExpectLayout {
+	case "", "bare", "worktree":
+	default:
+		return nil, nil, fmt.Errorf("gitserver: invalid ExpectLayout %q (valid: bare, worktree)", c.ExpectLayout)
+	}
+
+	opt, err := resolveHTTPSAuth(c.Repo, c.Opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := servers
+	usingReplicas := c.useReplicas()
+	if usingReplicas {
+		pool = replicaServers
+	}
+
+	var stdins []<-chan []byte
+	maxRetries := CallMaxRetries
+	if c.stdin != nil {
+		stdins = teeChunks(c.stdin, len(pool))
+		// A streamed stdin is consumed by the first attempt, so the
+		// request cannot be replayed.
+		maxRetries = 0
+	}
+	i := 0
+	newRequest := func() (*request, func() (genericReply, bool)) {
+		stdin := chanrpcutil.ToChunks(c.Input)
+		if stdins != nil {
+			stdin = stdins[i]
+			i++
+		}
 		replyChan := make(chan *execReply, 1)
-		return &request{Exec: &execRequest{Repo: c.Repo, Args: c.Args[1:], Opt: c.Opt, Stdin: chanrpcutil.ToChunks(c.Input), ReplyChan: replyChan}},
+		return &request{Exec: &execRequest{Repo: c.Repo, Args: c.Args[1:], Opt: opt, Env: c.Env, Timeout: c.Timeout, CombinedOutput: c.combineOutput, ExpectLayout: c.ExpectLayout, TraceSpan: c.TraceSpan, Stdin: stdin, ReplyChan: replyChan}},
 			func() (genericReply, bool) { reply, ok := <-replyChan; return reply, ok }
-	})
+	}
+	genReply, err := broadcastCallPool(pool, newRequest, maxRetries)
+	if usingReplicas && replicaFallbackError(err) {
+		// The replicas couldn't answer (down, saturated, or mirror
+		// lag); the primaries are authoritative. useReplicas rules
+		// out streamed stdin, so re-sending is safe.
+		genReply, err = broadcastCallPool(servers, newRequest, maxRetries)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -132,16 +694,113 @@ func (c *Cmd) DividedOutput() ([]byte, []byte, error) {
 	if reply.CloneInProgress {
 		return nil, nil, vcs.RepoNotExistError{CloneInProgress: true}
 	}
-	stdout := chanrpcutil.ReadAll(reply.Stdout)
-	stderr := chanrpcutil.ReadAll(reply.Stderr)
+	if reply.WrongLayout {
+		return nil, nil, &WrongLayoutError{Expected: c.ExpectLayout, Actual: reply.ActualLayout}
+	}
+	stdoutC := chanrpcutil.ReadAll(reply.Stdout)
+	stderrC := chanrpcutil.ReadAll(reply.Stderr)
 
 	processResult := <-reply.ProcessResult
+	c.ExitStatus = processResult.ExitStatus
+
+	stdout, stderr := <-stdoutC, <-stderrC
+	if reply.StdoutGzipped {
+		b, derr := decompress(stdout)
+		if derr != nil {
+			return nil, nil, derr
+		}
+		stdout = b
+	}
+	if reply.StderrGzipped {
+		b, derr := decompress(stderr)
+		if derr != nil {
+			return nil, nil, derr
+		}
+		stderr = b
+	}
+
+	if reply.TimedOut {
+		return stdout, stderr, ErrTimeout
+	}
+	if reply.StdoutTruncated || reply.StderrTruncated {
+		// The (truncated) output is still returned so callers that
+		// can tolerate a partial result may use it.
+		return stdout, stderr, ErrOutputTooLarge
+	}
+
 	if processResult.Error != "" {
-		err = errors.New(processResult.Error)
+		if isDubiousOwnership(stderr) {
+			// git refused to touch the repo because of mismatched
+			// directory ownership, a configuration problem on the
+			// gitserver host — not a property of the repo or command.
+			err = &DubiousOwnershipError{Stderr: stderr}
+		} else if processResult.ExitStatus != 0 {
+			// git itself ran and exited non-zero; that is often an
+			// expected outcome (e.g. grep with no matches), so give
+			// callers a distinct type to detect it instead of
+			// string-matching stderr.
+			err = &ExitError{Msg: processResult.Error, ExitStatus: processResult.ExitStatus, Stderr: stderr}
+		} else {
+			err = errors.New(processResult.Error)
+		}
 	}
-	c.ExitStatus = processResult.ExitStatus
 
-	return <-stdout, <-stderr, err
+	return stdout, stderr, err
+}
+
+// ErrTimeout is returned by Cmd methods when the command exceeded its
+// Timeout and was killed on the server.
+var ErrTimeout = errors.New("gitserver: command timed out")
+
+// TimeoutForRepoSize returns a command timeout scaled to a repo's
+// size on disk: a minute of headroom plus a minute per gigabyte,
+// capped at ten minutes. Most repos get a tight bound while enormous
+// ones legitimately get longer.
+func TimeoutForRepoSize(sizeBytes int64) time.Duration {
+	const maxTimeout = 10 * time.Minute
+	timeout := time.Minute + time.Duration(sizeBytes/(1<<30))*time.Minute
+	if timeout > maxTimeout {
+		return maxTimeout
+	}
+	return timeout
+}
+
+// ErrOutputTooLarge is returned by Cmd methods when the command's
+// output exceeded the server's MaxOutputSize and was truncated. The
+// truncated output is returned alongside the error.
+var ErrOutputTooLarge = errors.New("gitserver: command output exceeded size limit")
+
+// ExitError is returned by Cmd methods when the git command ran but
+// exited with a non-zero status. Errors in reaching a gitserver or
+// starting the process are returned as other error types (e.g.
+// errRPCFailed).
+type ExitError struct {
+	Msg        string // the process error, e.g. "exit status 1"
+	ExitStatus int
+	Stderr     []byte
+}
+
+func (e *ExitError) Error() string { return e.Msg }
+
+// isDubiousOwnership reports whether stderr is git (>= 2.35.2)
+// refusing to operate on a repository whose on-disk owner doesn't
+// match the user running it.
+func isDubiousOwnership(stderr []byte) bool {
+	return bytes.Contains(stderr, []byte("detected dubious ownership"))
+}
+
+// DubiousOwnershipError is returned by Cmd methods when git refused
+// to operate on the repository because its directory is owned by a
+// different user than the gitserver process. It means the repos
+// volume's ownership is wrong — fix the ownership, or rely on the
+// gitserver adding ReposDir to git's safe.directory list at startup
+// (see configureGitSafeDirectory).
+type DubiousOwnershipError struct {
+	Stderr []byte
+}
+
+func (e *DubiousOwnershipError) Error() string {
+	return "gitserver: git refused to operate on a repository with mismatched directory ownership; chown the repos dir to the gitserver user or add it to git's safe.directory"
 }
 
 func (c *Cmd) Run() error {
@@ -159,6 +818,17 @@ func (c *Cmd) CombinedOutput() ([]byte, error) {
 	return append(stdout, stderr...), err
 }
 
+// CombinedOutputOrdered is like CombinedOutput, but stdout and stderr
+// are interleaved in the order git wrote them rather than
+// concatenated, which keeps mixed progress/error output readable. The
+// command runs with both streams writing to one buffer on the server,
+// so the ordering is the subprocess's real write order.
+func (c *Cmd) CombinedOutputOrdered() ([]byte, error) {
+	c.combineOutput = true
+	combined, _, err := c.DividedOutput()
+	return combined, err
+}
+
 var execDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 	Namespace: "src",
 	Subsystem: "gitserver",
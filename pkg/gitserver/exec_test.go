@@ -5,8 +5,12 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"errors"
+	"io/ioutil"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/neelance/chanrpc/chanrpcutil"
 
@@ -74,28 +78,43 @@ func TestExec(t *testing.T) {
 		},
 	}
 
+	origDelay := CallRetryDelay
+	CallRetryDelay = time.Millisecond
+	defer func() { CallRetryDelay = origDelay }()
+
 	for _, test := range tests {
 		server1 := make(chan *request)
 		server2 := make(chan *request)
 		servers = [](chan<- *request){server1, server2}
 
+		// Serve every attempt with the same replies: rpc-failure cases
+		// are retried, so a single round would leave the retry blocked.
+		done := make(chan struct{})
 		go func(test *execTest) {
-			req1 := <-server1
-			chanrpcutil.Drain(req1.Exec.Stdin)
-			if test.reply1 != nil {
-				req1.Exec.ReplyChan <- test.reply1
-			}
-			close(req1.Exec.ReplyChan)
+			for {
+				var req1 *request
+				select {
+				case req1 = <-server1:
+				case <-done:
+					return
+				}
+				chanrpcutil.Drain(req1.Exec.Stdin)
+				if test.reply1 != nil {
+					req1.Exec.ReplyChan <- test.reply1
+				}
+				close(req1.Exec.ReplyChan)
 
-			req2 := <-server2
-			chanrpcutil.Drain(req2.Exec.Stdin)
-			if test.reply2 != nil {
-				req2.Exec.ReplyChan <- test.reply2
+				req2 := <-server2
+				chanrpcutil.Drain(req2.Exec.Stdin)
+				if test.reply2 != nil {
+					req2.Exec.ReplyChan <- test.reply2
+				}
+				close(req2.Exec.ReplyChan)
 			}
-			close(req2.Exec.ReplyChan)
 		}(test)
 
 		stdout, stderr, err := Command("git", "test").DividedOutput()
+		close(done)
 		if err != test.expectedErr {
 			t.Errorf("expected error %#v, got %#v", test.expectedErr, err)
 		}
@@ -108,6 +127,409 @@ func TestExec(t *testing.T) {
 	}
 }
 
+func TestExec_gzip(t *testing.T) {
+	wantStdout := bytes.Repeat([]byte("a"), execCompressionThreshold)
+	compressed, ok := maybeCompress(wantStdout)
+	if !ok {
+		t.Fatal("expected output above threshold to be compressed")
+	}
+	if len(compressed) >= len(wantStdout) {
+		t.Errorf("compressed size %d >= input size %d", len(compressed), len(wantStdout))
+	}
+
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{
+			StdoutGzipped: true,
+			Stdout:        chanrpcutil.ToChunks(compressed),
+			Stderr:        chanrpcutil.ToChunks([]byte("err")),
+			ProcessResult: emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	stdout, stderr, err := Command("git", "test").DividedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stdout, wantStdout) {
+		t.Errorf("stdout was not transparently decompressed (got %d bytes, want %d)", len(stdout), len(wantStdout))
+	}
+	if !bytes.Equal(stderr, []byte("err")) {
+		t.Errorf("expected stderr %q, got %q", "err", stderr)
+	}
+}
+
+func TestExec_streamingStdin(t *testing.T) {
+	server1 := make(chan *request)
+	server2 := make(chan *request)
+	servers = [](chan<- *request){server1, server2}
+
+	got := make(chan []byte, 2)
+	for _, server := range []chan *request{server1, server2} {
+		go func(server chan *request) {
+			req := <-server
+			got <- <-chanrpcutil.ReadAll(req.Exec.Stdin)
+			req.Exec.ReplyChan <- &execReply{Stdout: chanrpcutil.ToChunks(nil), Stderr: chanrpcutil.ToChunks(nil), ProcessResult: emptyProcessResult()}
+			close(req.Exec.ReplyChan)
+		}(server)
+	}
+
+	cmd := Command("git", "cat-file", "--batch")
+	stdin := cmd.StdinPipe()
+	go func() {
+		stdin.Write([]byte("deadbeef\n"))
+		stdin.Write([]byte("cafebabe\n"))
+		stdin.Close()
+	}()
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte("deadbeef\ncafebabe\n")
+	for i := 0; i < 2; i++ {
+		if b := <-got; !bytes.Equal(b, want) {
+			t.Errorf("server received stdin %q, want %q", b, want)
+		}
+	}
+}
+
+func TestExec_exitError(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		processResultChan := make(chan *processResult, 1)
+		processResultChan <- &processResult{Error: "exit status 1", ExitStatus: 1}
+		req.Exec.ReplyChan <- &execReply{
+			Stdout:        chanrpcutil.ToChunks(nil),
+			Stderr:        chanrpcutil.ToChunks([]byte("fatal: bad object")),
+			ProcessResult: processResultChan,
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	_, _, err := Command("git", "test").DividedOutput()
+	exitErr, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("expected *ExitError, got %#v", err)
+	}
+	if exitErr.ExitStatus != 1 {
+		t.Errorf("expected exit status 1, got %d", exitErr.ExitStatus)
+	}
+	if !bytes.Equal(exitErr.Stderr, []byte("fatal: bad object")) {
+		t.Errorf("expected stderr in error, got %q", exitErr.Stderr)
+	}
+	if exitErr.Error() != "exit status 1" {
+		t.Errorf("expected error string %q, got %q", "exit status 1", exitErr.Error())
+	}
+}
+
+func TestFilterExecEnv(t *testing.T) {
+	env := []string{
+		"GIT_TRACE=1",
+		"HTTPS_PROXY=http://proxy:3128",
+		"PATH=/tmp/evil",
+		"GIT_SSH=/tmp/evil.sh",
+		"malformed",
+	}
+	want := []string{"GIT_TRACE=1", "HTTPS_PROXY=http://proxy:3128"}
+	if got := filterExecEnv(env); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLimitedStdinReader(t *testing.T) {
+	// Input exactly at the cap reads fully and ends with a normal
+	// EOF.
+	r := &limitedStdinReader{r: strings.NewReader("12345"), max: 5}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "12345"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+	if r.exceeded {
+		t.Error("exceeded set for input exactly at the cap")
+	}
+
+	// Input over the cap fails with errStdinTooLarge.
+	r = &limitedStdinReader{r: strings.NewReader("123456"), max: 5}
+	if _, err := ioutil.ReadAll(r); err != errStdinTooLarge {
+		t.Errorf("got error %v, want %v", err, errStdinTooLarge)
+	}
+	if !r.exceeded {
+		t.Error("exceeded not set for input over the cap")
+	}
+}
+
+func TestLimitedBuffer(t *testing.T) {
+	b := &limitedBuffer{max: 5}
+	if n, _ := b.Write([]byte("abc")); n != 3 {
+		t.Errorf("got n %d, want 3", n)
+	}
+	if b.truncated {
+		t.Error("truncated before limit reached")
+	}
+	if n, _ := b.Write([]byte("defg")); n != 4 {
+		t.Errorf("got n %d, want 4", n)
+	}
+	if !b.truncated {
+		t.Error("!truncated after exceeding limit")
+	}
+	if got := b.String(); got != "abcde" {
+		t.Errorf("got buffered %q, want %q", got, "abcde")
+	}
+	if n, _ := b.Write([]byte("x")); n != 1 {
+		t.Errorf("got n %d, want 1", n)
+	}
+	if got := b.String(); got != "abcde" {
+		t.Errorf("got buffered %q after overflow write, want %q", got, "abcde")
+	}
+}
+
+func TestExec_outputTooLarge(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{
+			StdoutTruncated: true,
+			Stdout:          chanrpcutil.ToChunks([]byte("partial")),
+			Stderr:          chanrpcutil.ToChunks(nil),
+			ProcessResult:   emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	stdout, _, err := Command("git", "log").DividedOutput()
+	if err != ErrOutputTooLarge {
+		t.Fatalf("expected ErrOutputTooLarge, got %#v", err)
+	}
+	if !bytes.Equal(stdout, []byte("partial")) {
+		t.Errorf("expected truncated stdout to be returned, got %q", stdout)
+	}
+}
+
+func TestExec_timeout(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		if want := time.Minute; req.Exec.Timeout != want {
+			t.Errorf("got Timeout %s, want %s", req.Exec.Timeout, want)
+		}
+		processResultChan := make(chan *processResult, 1)
+		processResultChan <- &processResult{Error: "signal: killed", ExitStatus: -1}
+		req.Exec.ReplyChan <- &execReply{
+			TimedOut:      true,
+			Stdout:        chanrpcutil.ToChunks(nil),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: processResultChan,
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	cmd := Command("git", "log")
+	cmd.Timeout = time.Minute
+	if _, _, err := cmd.DividedOutput(); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %#v", err)
+	}
+}
+
+func TestTimeoutForRepoSize(t *testing.T) {
+	tests := []struct {
+		sizeBytes int64
+		want      time.Duration
+	}{
+		{0, time.Minute},
+		{500 << 20, time.Minute},
+		{3 << 30, 4 * time.Minute},
+		{100 << 30, 10 * time.Minute},
+	}
+	for _, test := range tests {
+		if got := TimeoutForRepoSize(test.sizeBytes); got != test.want {
+			t.Errorf("TimeoutForRepoSize(%d): got %s, want %s", test.sizeBytes, got, test.want)
+		}
+	}
+}
+
+func TestCoalesceKey(t *testing.T) {
+	tests := []struct {
+		req  *execRequest
+		want bool
+	}{
+		{&execRequest{Repo: "r", Args: []string{"fetch"}}, true},
+		{&execRequest{Repo: "r", Args: []string{"rev-parse", "HEAD"}}, true},
+		{&execRequest{Repo: "r", Args: []string{"push", "origin"}}, false},
+		{&execRequest{Repo: "r", Args: []string{"update-ref", "refs/heads/x", "deadbeef"}}, false},
+		{&execRequest{Repo: "r", Args: []string{"fetch"}, Env: []string{"GIT_TRACE=1"}}, false},
+		{&execRequest{Repo: "r", Args: nil}, false},
+	}
+	for _, test := range tests {
+		if _, ok := coalesceKey(test.req); ok != test.want {
+			t.Errorf("coalesceKey(%v %v): got %v, want %v", test.req.Args, test.req.Env, ok, test.want)
+		}
+	}
+
+	k1, _ := coalesceKey(&execRequest{Repo: "r", Args: []string{"fetch", "origin"}})
+	k2, _ := coalesceKey(&execRequest{Repo: "r", Args: []string{"fetch", "origin"}})
+	k3, _ := coalesceKey(&execRequest{Repo: "other", Args: []string{"fetch", "origin"}})
+	if k1 != k2 {
+		t.Errorf("identical requests got different keys: %q vs %q", k1, k2)
+	}
+	if k1 == k3 {
+		t.Errorf("requests for different repos got the same key: %q", k1)
+	}
+}
+
+func TestExec_idempotentRetry(t *testing.T) {
+	origMaxRetries, origDelay := CallMaxRetries, CallRetryDelay
+	origAttempts, origBackoff := execRetryAttempts, execRetryBackoff
+	CallMaxRetries, CallRetryDelay = 0, time.Millisecond
+	execRetryAttempts, execRetryBackoff = 2, time.Millisecond
+	defer func() {
+		CallMaxRetries, CallRetryDelay = origMaxRetries, origDelay
+		execRetryAttempts, execRetryBackoff = origAttempts, origBackoff
+	}()
+
+	// serve answers every request; the first failures requests get
+	// their reply channel closed without a reply (a dropped
+	// connection), the rest succeed. It returns a count of requests
+	// seen.
+	serve := func(failures int) (requests *int32, stop func()) {
+		server := make(chan *request)
+		servers = [](chan<- *request){server}
+		var n int32
+		done := make(chan struct{})
+		go func() {
+			for {
+				var req *request
+				select {
+				case req = <-server:
+				case <-done:
+					return
+				}
+				chanrpcutil.Drain(req.Exec.Stdin)
+				if atomic.AddInt32(&n, 1) > int32(failures) {
+					req.Exec.ReplyChan <- &execReply{Stdout: chanrpcutil.ToChunks([]byte("out")), Stderr: chanrpcutil.ToChunks(nil), ProcessResult: emptyProcessResult()}
+				}
+				close(req.Exec.ReplyChan)
+			}
+		}()
+		return &n, func() { close(done) }
+	}
+
+	// An idempotent read succeeds once the transport recovers.
+	requests, stop := serve(2)
+	cmd := Command("git", "log", "-n", "1")
+	cmd.Idempotent = true
+	stdout, _, err := cmd.DividedOutput()
+	stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("out"); !bytes.Equal(stdout, want) {
+		t.Errorf("got stdout %q, want %q", stdout, want)
+	}
+	if want := int32(3); *requests != want {
+		t.Errorf("got %d requests, want %d", *requests, want)
+	}
+
+	// An unmarked command is not re-sent.
+	requests, stop = serve(1)
+	_, _, err = Command("git", "log", "-n", "1").DividedOutput()
+	stop()
+	if err != errRPCFailed {
+		t.Errorf("got error %v, want %v", err, errRPCFailed)
+	}
+	if want := int32(1); *requests != want {
+		t.Errorf("got %d requests, want %d", *requests, want)
+	}
+
+	// A mutating command is not re-sent even if (wrongly) marked.
+	requests, stop = serve(1)
+	cmd = Command("git", "fetch", "--prune")
+	cmd.Idempotent = true
+	_, _, err = cmd.DividedOutput()
+	stop()
+	if err != errRPCFailed {
+		t.Errorf("got error %v, want %v", err, errRPCFailed)
+	}
+	if want := int32(1); *requests != want {
+		t.Errorf("got %d requests, want %d", *requests, want)
+	}
+}
+
+func TestLockRepoForWrite(t *testing.T) {
+	unlock := lockRepoForWrite("/repos/a", []string{"fetch", "--prune"})
+
+	// Read-only commands never block, even while a writer holds the
+	// lock.
+	done := make(chan struct{})
+	go func() {
+		lockRepoForWrite("/repos/a", []string{"log", "-n", "1"})()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("read-only command blocked on the write lock")
+	}
+
+	// Writers in other repos are independent.
+	done = make(chan struct{})
+	go func() {
+		lockRepoForWrite("/repos/b", []string{"gc", "--quiet"})()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writer in another repo blocked on the write lock")
+	}
+
+	// A second writer in the same repo waits for the first.
+	done = make(chan struct{})
+	go func() {
+		lockRepoForWrite("/repos/a", []string{"gc", "--quiet"})()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("second mutating command ran while the first held the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second mutating command never acquired the released lock")
+	}
+}
+
+func TestMaybeCompress_smallOutput(t *testing.T) {
+	in := []byte("small")
+	out, ok := maybeCompress(in)
+	if ok {
+		t.Error("expected output below threshold not to be compressed")
+	}
+	if !bytes.Equal(out, in) {
+		t.Errorf("expected %q, got %q", in, out)
+	}
+}
+
 func emptyProcessResult() <-chan *processResult {
 	processResultChan := make(chan *processResult, 1)
 	processResultChan <- &processResult{}
@@ -205,3 +627,94 @@ func TestCreate(t *testing.T) {
 		}
 	}
 }
+
+func TestExec_dubiousOwnership(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	stderr := []byte("fatal: detected dubious ownership in repository at '/repos/r'")
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		processResultChan := make(chan *processResult, 1)
+		processResultChan <- &processResult{Error: "exit status 128", ExitStatus: 128}
+		req.Exec.ReplyChan <- &execReply{
+			Stdout:        chanrpcutil.ToChunks(nil),
+			Stderr:        chanrpcutil.ToChunks(stderr),
+			ProcessResult: processResultChan,
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	_, _, err := Command("git", "test").DividedOutput()
+	ownErr, ok := err.(*DubiousOwnershipError)
+	if !ok {
+		t.Fatalf("expected *DubiousOwnershipError, got %#v", err)
+	}
+	if !bytes.Equal(ownErr.Stderr, stderr) {
+		t.Errorf("expected stderr in error, got %q", ownErr.Stderr)
+	}
+}
+
+func TestExec_combinedOutputOrdered(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	interleaved := []byte("out1\nerr1\nout2\n")
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		if !req.Exec.CombinedOutput {
+			t.Error("expected CombinedOutput to be set on the request")
+		}
+		req.Exec.ReplyChan <- &execReply{
+			Combined:      true,
+			Stdout:        chanrpcutil.ToChunks(interleaved),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	out, err := Command("git", "test").CombinedOutputOrdered()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, interleaved) {
+		t.Errorf("expected output %q, got %q", interleaved, out)
+	}
+}
+
+func TestExec_expectLayout(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+	defer func() { servers = nil }()
+
+	go func() {
+		req := <-server
+		if want := "bare"; req.Exec.ExpectLayout != want {
+			t.Errorf("got ExpectLayout %q, want %q", req.Exec.ExpectLayout, want)
+		}
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{WrongLayout: true, ActualLayout: "worktree"}
+		close(req.Exec.ReplyChan)
+	}()
+
+	cmd := Command("git", "test")
+	cmd.ExpectLayout = "bare"
+	_, _, err := cmd.DividedOutput()
+	layoutErr, ok := err.(*WrongLayoutError)
+	if !ok {
+		t.Fatalf("expected *WrongLayoutError, got %#v", err)
+	}
+	if layoutErr.Expected != "bare" || layoutErr.Actual != "worktree" {
+		t.Errorf("got %+v", layoutErr)
+	}
+
+	// An invalid layout value fails before reaching a server.
+	cmd = Command("git", "test")
+	cmd.ExpectLayout = "bogus"
+	if _, _, err := cmd.DividedOutput(); err == nil {
+		t.Error("expected error for invalid ExpectLayout")
+	}
+}
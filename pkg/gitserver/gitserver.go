@@ -3,32 +3,87 @@ package gitserver
 import (
 	"errors"
 	"log"
+	"math/rand"
 	"net"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/neelance/chanrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/inconshreveable/log15.v2"
 
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
 )
 
 type request struct {
-	Exec   *execRequest
-	Search *searchRequest
-	Create *createRequest
-	Remove *removeRequest
+	Exec      *execRequest
+	Search    *searchRequest
+	Create    *createRequest
+	Remove    *removeRequest
+	ListRepos *listReposRequest
 }
 
 var ReposDir string
 var servers [](chan<- *request)
 
+// Tuning for the request channels between this process and the
+// gitservers it is connected to.
+var (
+	// RequestChanBuffer is the buffer size of the per-server request
+	// channel. When a server's channel is full, callers either block
+	// or are rejected, per RejectWhenFull.
+	RequestChanBuffer = conf.GetenvIntOrDefault("SG_GITSERVER_CHAN_BUFFER", 100)
+
+	// RejectWhenFull selects what happens when a server's request
+	// channel is full. By default callers block until there is room,
+	// which keeps requests ordered but lets a slow gitserver stall
+	// its callers. When set to "reject" (SG_GITSERVER_CHAN_FULL),
+	// calls to a saturated server fail fast with ErrServerBusy so
+	// callers can degrade instead of piling up.
+	RejectWhenFull = conf.GetenvOrDefault("SG_GITSERVER_CHAN_FULL", "block") == "reject"
+)
+
+// ErrServerBusy is returned in reject-when-full mode when a request
+// could not be delivered because the gitserver's request channel was
+// full.
+var ErrServerBusy = errors.New("gitserver: server busy (request channel full)")
+
 func Serve(l net.Listener) error {
 	registerMetrics()
-	requests := make(chan *request, 100)
+	configureGitSafeDirectory()
+	requests := make(chan *request, RequestChanBuffer)
 	go processRequests(requests)
 	srv := &chanrpc.Server{RequestChan: requests}
 	return srv.Serve(l)
 }
 
+// configureGitSafeDirectory marks ReposDir and the repositories under
+// it as safe for git to operate on regardless of directory ownership.
+// git >= 2.35.2 otherwise refuses with "detected dubious ownership"
+// when the repos volume is owned by a different UID than the
+// gitserver process, which is common in container deployments. Git
+// versions that predate safe.directory simply ignore the setting.
+func configureGitSafeDirectory() {
+	if ReposDir == "" {
+		return
+	}
+	// The trailing "/*" entry covers the (nested) repo directories
+	// themselves; the bare entry covers ReposDir.
+	for _, dir := range []string{ReposDir, filepath.Join(ReposDir, "*")} {
+		if exec.Command("git", "config", "--global", "--get-all", "safe.directory", "^"+regexp.QuoteMeta(dir)+"$").Run() == nil {
+			continue // already configured; don't accumulate duplicates
+		}
+		if out, err := exec.Command("git", "config", "--global", "--add", "safe.directory", dir).CombinedOutput(); err != nil {
+			log15.Warn("gitserver: configuring git safe.directory failed", "dir", dir, "error", err, "output", string(out))
+			return
+		}
+	}
+}
+
 func processRequests(requests <-chan *request) {
 	for req := range requests {
 		if req.Exec != nil {
@@ -43,20 +98,134 @@ func processRequests(requests <-chan *request) {
 		if req.Remove != nil {
 			go handleRemoveRequest(req.Remove)
 		}
+		if req.ListRepos != nil {
+			go handleListReposRequest(req.ListRepos)
+		}
 	}
 }
 
+// Reconnection behavior for Connect. After each failed
+// DialAndDeliver, the retry delay doubles (with jitter, to avoid a
+// thundering herd when a gitserver restarts) from ReconnectBaseDelay
+// up to ReconnectMaxDelay. If MaxReconnectAttempts is > 0 and that
+// many consecutive attempts fail, the loop gives up and
+// ConnectionDown (if set) is invoked so the caller can alert or shut
+// down instead of having every call block indefinitely.
+var (
+	ReconnectBaseDelay   = time.Second
+	ReconnectMaxDelay    = 30 * time.Second
+	MaxReconnectAttempts = 0 // 0 means retry forever
+
+	// ConnectionDown, if non-nil, is called with the server address
+	// and last error when the reconnect loop for that server gives
+	// up.
+	ConnectionDown func(addr string, err error)
+)
+
 func Connect(addr string) {
-	requestsChan := make(chan *request, 100)
-	servers = append(servers, requestsChan)
+	servers = append(servers, connect(addr))
+}
+
+// connect starts the dial-and-reconnect loop for one gitserver and
+// returns the request channel feeding it.
+func connect(addr string) chan<- *request {
+	registerClientMetrics()
+	requestsChan := make(chan *request, RequestChanBuffer)
 
 	go func() {
+		delay := ReconnectBaseDelay
+		attempts := 0
 		for {
+			start := time.Now()
 			err := chanrpc.DialAndDeliver(addr, requestsChan)
 			log.Printf("gitserver: DialAndDeliver error: %v", err)
-			time.Sleep(time.Second)
+
+			// DialAndDeliver returning long after it was called means
+			// we had a working connection that dropped, not a failure
+			// to dial; start the backoff over.
+			if time.Since(start) > ReconnectMaxDelay {
+				delay = ReconnectBaseDelay
+				attempts = 0
+			}
+
+			attempts++
+			if MaxReconnectAttempts > 0 && attempts >= MaxReconnectAttempts {
+				log.Printf("gitserver: giving up on %s after %d failed connection attempts", addr, attempts)
+				if ConnectionDown != nil {
+					ConnectionDown(addr, err)
+				}
+				return
+			}
+
+			// Sleep delay ± up to half of it, so a fleet of clients
+			// doesn't reconnect in lockstep.
+			time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay))))
+			if delay *= 2; delay > ReconnectMaxDelay {
+				delay = ReconnectMaxDelay
+			}
 		}
 	}()
+
+	return requestsChan
+}
+
+// QueuedRequests returns the number of requests currently buffered in
+// the channels to all connected gitservers (primaries and replicas).
+// A value near len(servers)*RequestChanBuffer means the pipe is
+// saturated.
+func QueuedRequests() int {
+	n := 0
+	for _, server := range servers {
+		n += len(server)
+	}
+	for _, server := range replicaServers {
+		n += len(server)
+	}
+	return n
+}
+
+var (
+	clientMetricsOnce sync.Once
+
+	rejectedRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "src",
+		Subsystem: "gitserver",
+		Name:      "client_rejected_requests",
+		Help:      "Requests rejected because a gitserver request channel was full (reject-when-full mode only).",
+	})
+)
+
+// registerClientMetrics registers the client-side channel saturation
+// metrics. It is separate from registerMetrics, which registers the
+// metrics of the gitserver process itself.
+func registerClientMetrics() {
+	clientMetricsOnce.Do(func() {
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "src",
+			Subsystem: "gitserver",
+			Name:      "client_queued_requests",
+			Help:      "Requests buffered in the channels to all connected gitservers.",
+		}, func() float64 {
+			return float64(QueuedRequests())
+		}))
+		prometheus.MustRegister(rejectedRequests)
+	})
+}
+
+// deliver sends req to server, honoring RejectWhenFull. It reports
+// whether the request was delivered.
+func deliver(server chan<- *request, req *request) bool {
+	if !RejectWhenFull {
+		server <- req
+		return true
+	}
+	select {
+	case server <- req:
+		return true
+	default:
+		rejectedRequests.Inc()
+		return false
+	}
 }
 
 type genericReply interface {
@@ -65,11 +234,53 @@ type genericReply interface {
 
 var errRPCFailed = errors.New("gitserver: rpc failed")
 
+// Retry policy for calls that fail because a connection dropped (the
+// reply channel is closed without a reply). A bounded number of
+// retries with a short pause gives the Connect reconnect loop a chance
+// to re-establish the connection, instead of either tight-looping the
+// call or failing requests that arrive in the brief window of a
+// gitserver restart. After the retries are exhausted the error is
+// returned to the caller.
+var (
+	CallMaxRetries = conf.GetenvIntOrDefault("SG_GITSERVER_CALL_RETRIES", 2)
+	CallRetryDelay = conf.GetenvDurationOrDefault("SG_GITSERVER_CALL_RETRY_DELAY", "500ms")
+)
+
 func broadcastCall(newRequest func() (*request, func() (genericReply, bool))) (interface{}, error) {
-	allReplies := make(chan genericReply, len(servers))
-	for _, server := range servers {
+	return broadcastCallRetry(newRequest, CallMaxRetries)
+}
+
+// broadcastCallRetry is broadcastCall with an explicit retry budget.
+// maxRetries == 0 means a single attempt; callers whose requests
+// cannot be replayed (e.g. a streamed stdin that is consumed by the
+// first attempt) must use 0. Only errRPCFailed is retried: busy
+// servers (ErrServerBusy) and not-found replies are definitive.
+func broadcastCallRetry(newRequest func() (*request, func() (genericReply, bool)), maxRetries int) (interface{}, error) {
+	return broadcastCallPool(servers, newRequest, maxRetries)
+}
+
+// broadcastCallPool is broadcastCallRetry against an explicit server
+// pool (the primaries, or the read-only replicas).
+func broadcastCallPool(pool [](chan<- *request), newRequest func() (*request, func() (genericReply, bool)), maxRetries int) (interface{}, error) {
+	for attempt := 0; ; attempt++ {
+		reply, err := broadcastCallOnce(pool, newRequest)
+		if err != errRPCFailed || attempt >= maxRetries {
+			return reply, err
+		}
+		time.Sleep(CallRetryDelay)
+	}
+}
+
+func broadcastCallOnce(pool [](chan<- *request), newRequest func() (*request, func() (genericReply, bool))) (interface{}, error) {
+	allReplies := make(chan genericReply, len(pool))
+	busy := false
+	for _, server := range pool {
 		req, getReply := newRequest()
-		server <- req
+		if !deliver(server, req) {
+			busy = true
+			allReplies <- nil
+			continue
+		}
 		go func() {
 			reply, ok := getReply()
 			if !ok {
@@ -81,7 +292,7 @@ func broadcastCall(newRequest func() (*request, func() (genericReply, bool))) (i
 	}
 
 	rpcError := false
-	for range servers {
+	for range pool {
 		reply := <-allReplies
 		if reply == nil {
 			rpcError = true
@@ -92,6 +303,11 @@ func broadcastCall(newRequest func() (*request, func() (genericReply, bool))) (i
 		}
 	}
 	if rpcError {
+		if busy {
+			// The repo may live on a server we couldn't reach;
+			// report busy rather than not-found.
+			return nil, ErrServerBusy
+		}
 		return nil, errRPCFailed
 	}
 	return nil, vcs.RepoNotExistError{}
@@ -0,0 +1,106 @@
+package gitserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueuedRequests(t *testing.T) {
+	server1 := make(chan *request, 2)
+	server2 := make(chan *request, 2)
+	servers = [](chan<- *request){server1, server2}
+	defer func() { servers = nil }()
+
+	if got := QueuedRequests(); got != 0 {
+		t.Errorf("got %d queued requests, want 0", got)
+	}
+	server1 <- &request{}
+	server1 <- &request{}
+	server2 <- &request{}
+	if got := QueuedRequests(); got != 3 {
+		t.Errorf("got %d queued requests, want 3", got)
+	}
+}
+
+func TestExec_rejectWhenFull(t *testing.T) {
+	// A full, unserviced request channel: in reject-when-full mode a
+	// call must fail fast with ErrServerBusy instead of blocking.
+	server := make(chan *request, 1)
+	server <- &request{}
+	servers = [](chan<- *request){server}
+	origReject := RejectWhenFull
+	RejectWhenFull = true
+	defer func() {
+		servers = nil
+		RejectWhenFull = origReject
+	}()
+
+	if _, _, err := Command("git", "test").DividedOutput(); err != ErrServerBusy {
+		t.Errorf("expected error %v, got %v", ErrServerBusy, err)
+	}
+}
+
+func TestBroadcastCall_retry(t *testing.T) {
+	// A closed reply channel (dropped connection) is retried; the
+	// second attempt succeeds.
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+	origDelay := CallRetryDelay
+	CallRetryDelay = time.Millisecond
+	defer func() {
+		servers = nil
+		CallRetryDelay = origDelay
+	}()
+
+	attempts := 0
+	go func() {
+		for req := range server {
+			attempts++
+			if attempts == 1 {
+				close(req.Remove.ReplyChan)
+				continue
+			}
+			req.Remove.ReplyChan <- &removeReply{}
+			close(req.Remove.ReplyChan)
+		}
+	}()
+
+	existed, err := Remove("testrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !existed {
+		t.Error("existed=false, want true")
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+}
+
+func TestBroadcastCall_retriesExhausted(t *testing.T) {
+	// When every attempt fails, the error reaches the caller instead
+	// of retrying forever.
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+	origDelay := CallRetryDelay
+	CallRetryDelay = time.Millisecond
+	defer func() {
+		servers = nil
+		CallRetryDelay = origDelay
+	}()
+
+	attempts := 0
+	go func() {
+		for req := range server {
+			attempts++
+			close(req.Remove.ReplyChan)
+		}
+	}()
+
+	if _, err := Remove("testrepo"); err != errRPCFailed {
+		t.Errorf("expected error %v, got %v", errRPCFailed, err)
+	}
+	if want := CallMaxRetries + 1; attempts != want {
+		t.Errorf("got %d attempts, want %d", attempts, want)
+	}
+}
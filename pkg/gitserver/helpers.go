@@ -1,12 +1,20 @@
 package gitserver
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+
+	"gopkg.in/inconshreveable/log15.v2"
 
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs/util"
@@ -24,7 +32,10 @@ func runWithRemoteOpts(cmd *exec.Cmd, opt *vcs.RemoteOpts) error {
 		defer func() {
 			if keyFile != "" {
 				if err := os.Remove(keyFile); err != nil {
-					log.Fatalf("Error removing SSH key file %s: %s.", keyFile, err)
+					// Don't take the server down over a transient FS
+					// issue, but complain loudly: a failed removal
+					// leaves key material on disk.
+					log15.Error("gitserver: error removing SSH key file", "keyFile", keyFile, "error", err)
 				}
 			}
 		}()
@@ -35,11 +46,15 @@ func runWithRemoteOpts(cmd *exec.Cmd, opt *vcs.RemoteOpts) error {
 		if gitSSHWrapperDir != "" {
 			defer os.RemoveAll(gitSSHWrapperDir)
 		}
-		cmd.Env = append(cmd.Env, "GIT_SSH="+gitSSHWrapper)
+		cmd.Env = append(envOrInherit(cmd.Env), "GIT_SSH="+gitSSHWrapper)
 	}
 
 	if opt != nil && opt.HTTPS != nil {
-		gitPassHelper, gitPassHelperDir, err := makeGitPassHelper(opt.HTTPS.Pass)
+		pass := opt.HTTPS.Pass
+		if opt.HTTPS.Token != "" {
+			pass = opt.HTTPS.Token
+		}
+		gitPassHelper, gitPassHelperDir, err := makeGitPassHelper(opt.HTTPS.User, pass)
 		if err != nil {
 			return err
 		}
@@ -47,12 +62,51 @@ func runWithRemoteOpts(cmd *exec.Cmd, opt *vcs.RemoteOpts) error {
 		if gitPassHelperDir != "" {
 			defer os.RemoveAll(gitPassHelperDir)
 		}
-		cmd.Env = append(cmd.Env, "GIT_ASKPASS="+gitPassHelper)
+		cmd.Env = append(envOrInherit(cmd.Env), "GIT_ASKPASS="+gitPassHelper)
+	}
+
+	if opt != nil && opt.GitProtocolV2 {
+		// Setting the config via the environment (rather than a -c
+		// flag) keeps this orthogonal to how the command was built.
+		// runWithRemoteOpts only runs network commands (clone, fetch,
+		// ls-remote), so the setting never leaks into local git
+		// invocations. The single quotes are required by git's
+		// GIT_CONFIG_PARAMETERS syntax.
+		cmd.Env = append(envOrInherit(cmd.Env), "GIT_CONFIG_PARAMETERS='protocol.version=2'")
+	}
+
+	if opt != nil && opt.Proxy != nil {
+		// The proxy only applies to remotes reached over HTTP(S); the
+		// SSH path above connects directly. Both uppercase and
+		// lowercase variants are set because git's curl-based
+		// transport honors either. This composes with the
+		// GIT_ASKPASS helper above: auth happens against the remote
+		// through the proxy's CONNECT tunnel.
+		if p := opt.Proxy.HTTPProxy; p != "" {
+			cmd.Env = append(envOrInherit(cmd.Env), "HTTP_PROXY="+p, "http_proxy="+p)
+		}
+		if p := opt.Proxy.HTTPSProxy; p != "" {
+			cmd.Env = append(envOrInherit(cmd.Env), "HTTPS_PROXY="+p, "https_proxy="+p)
+		}
+		if p := opt.Proxy.NoProxy; p != "" {
+			cmd.Env = append(envOrInherit(cmd.Env), "NO_PROXY="+p, "no_proxy="+p)
+		}
 	}
 
 	return cmd.Run()
 }
 
+// envOrInherit returns env, or a copy of the process's environment if
+// env is nil. Setting a non-nil Env on an exec.Cmd replaces its
+// entire environment, so appending a single variable to a nil Env
+// would otherwise strip PATH, HOME, etc. from the git process.
+func envOrInherit(env []string) []string {
+	if env == nil {
+		return os.Environ()
+	}
+	return env
+}
+
 // makeGitSSHWrapper writes a GIT_SSH wrapper that runs ssh with the
 // private key. You should remove the sshWrapper, sshWrapperDir and
 // the keyFile after using them.
@@ -105,9 +159,16 @@ func gitSSHWrapper(keyFile string, otherOpt string) (sshWrapperFile string, temp
 	return sshWrapperName, tempDir, err
 }
 
-// makeGitPassHelper writes a GIT_ASKPASS helper that supplies password over stdout.
+// makeGitPassHelper writes a GIT_ASKPASS helper that supplies the
+// username and password over stdout. Git invokes the helper once per
+// prompt; the prompt text ("Username for ..." or "Password for ...")
+// is passed as the first argument. If user is empty, the helper
+// answers every prompt with the password, as before (git then infers
+// the username from the URL). The credentials are written to files
+// under the helper's temp dir, never into the helper's command line,
+// so they don't show up in process listings or logs.
 // You should remove the passHelper (and tempDir if any) after using it.
-func makeGitPassHelper(pass string) (passHelper string, tempDir string, err error) {
+func makeGitPassHelper(user, pass string) (passHelper string, tempDir string, err error) {
 	tmpFile, dir, err := util.ScriptFile("go-vcs-gitcmd-ask")
 	if err != nil {
 		return tmpFile, dir, err
@@ -119,27 +180,111 @@ func makeGitPassHelper(pass string) (passHelper string, tempDir string, err erro
 		return tmpFile, dir, err
 	}
 
+	var userPath string
+	if user != "" {
+		userPath = filepath.Join(dir, "username")
+		err = util.WriteFileWithPermissions(userPath, []byte(user), 0600)
+		if err != nil {
+			return tmpFile, dir, err
+		}
+	}
+
 	var script string
 
-	// We assume passPath can be escaped with a simple wrapping of single
-	// quotes. The path is not user controlled so this assumption should
+	// We assume the paths can be escaped with a simple wrapping of single
+	// quotes. The paths are not user controlled so this assumption should
 	// not be violated.
 	if runtime.GOOS == "windows" {
-		script = "@echo off\ntype " + passPath + "\n"
+		if user != "" {
+			script = "@echo off\necho %~1| findstr /B /C:\"Username\" >nul\nif %errorlevel%==0 (type " + userPath + ") else (type " + passPath + ")\n"
+		} else {
+			script = "@echo off\ntype " + passPath + "\n"
+		}
 	} else {
-		script = "#!/bin/sh\ncat '" + passPath + "'\n"
+		if user != "" {
+			script = "#!/bin/sh\ncase \"$1\" in\nUsername*) cat '" + userPath + "' ;;\n*) cat '" + passPath + "' ;;\nesac\n"
+		} else {
+			script = "#!/bin/sh\ncat '" + passPath + "'\n"
+		}
 	}
 
 	err = util.WriteFileWithPermissions(tmpFile, []byte(script), 0500)
 	return tmpFile, dir, err
 }
 
-// repoExists checks if dir is a valid GIT_DIR.
+// safeRepoDir validates the repo path received over the network and
+// returns its directory under ReposDir. It rejects anything that
+// could escape ReposDir: absolute paths, empty or "."/".." path
+// segments (and percent-encoded variants of them, in case a caller
+// upstream decodes them later), and symlinks under ReposDir that
+// resolve to a directory outside of it.
+func safeRepoDir(repo string) (string, error) {
+	if repo == "" {
+		return "", errors.New("gitserver: empty repo path")
+	}
+	if ReposDir == "" {
+		// No repos root is configured (single-process mode, e.g. the
+		// in-process server the vcs tests start): repo is a raw
+		// filesystem path chosen by in-process callers, so there is no
+		// root to contain it in.
+		return repo, nil
+	}
+	if path.IsAbs(repo) || strings.Contains(repo, "\\") {
+		return "", fmt.Errorf("gitserver: invalid repo path %q", repo)
+	}
+	for _, seg := range strings.Split(repo, "/") {
+		if seg == "" || seg == "." || seg == ".." || strings.Contains(strings.ToLower(seg), "%2e") {
+			return "", fmt.Errorf("gitserver: invalid repo path %q", repo)
+		}
+	}
+
+	dir := path.Join(ReposDir, repo)
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		// The path does not exist (yet); that is for the caller to
+		// handle (e.g. repoExists, or create).
+		return dir, nil
+	}
+	root, err := filepath.EvalSymlinks(ReposDir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("gitserver: repo path %q escapes repos dir", repo)
+	}
+	return dir, nil
+}
+
+// repoExists checks if dir is a valid GIT_DIR (bare repository) or a
+// repository with a working tree (dir/.git).
 func repoExists(dir string) bool {
-	_, err := os.Stat(filepath.Join(dir, "HEAD"))
+	if _, err := os.Stat(filepath.Join(dir, "HEAD")); !os.IsNotExist(err) {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(dir, ".git", "HEAD"))
 	return !os.IsNotExist(err)
 }
 
+// repoLayout reports whether the existing repository at dir is "bare"
+// (HEAD at the top level) or a "worktree" checkout (HEAD under .git).
+func repoLayout(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, ".git", "HEAD")); err == nil {
+		return "worktree"
+	}
+	return "bare"
+}
+
+// newRequestID returns a short random ID for correlating log lines
+// belonging to one request.
+func newRequestID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
 func recoverAndLog() {
 	if err := recover(); err != nil {
 		log.Print(err)
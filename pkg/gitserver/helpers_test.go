@@ -0,0 +1,75 @@
+package gitserver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeRepoDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gitserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	origReposDir := ReposDir
+	ReposDir = tmp
+	defer func() { ReposDir = origReposDir }()
+
+	valid := []string{
+		"repo",
+		"github.com/foo/bar",
+		"a/b/c",
+	}
+	for _, repo := range valid {
+		if _, err := safeRepoDir(repo); err != nil {
+			t.Errorf("safeRepoDir(%q): unexpected error: %s", repo, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		".",
+		"..",
+		"../etc",
+		"a/../../etc",
+		"a/..",
+		"/etc/passwd",
+		"a//b",
+		"a/./b",
+		`a\..\b`,
+		"%2e%2e/etc",
+		"a/%2E%2E/b",
+	}
+	for _, repo := range invalid {
+		if _, err := safeRepoDir(repo); err == nil {
+			t.Errorf("safeRepoDir(%q): expected error, got none", repo)
+		}
+	}
+}
+
+func TestSafeRepoDir_symlinkEscape(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gitserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	origReposDir := ReposDir
+	ReposDir = filepath.Join(tmp, "repos")
+	defer func() { ReposDir = origReposDir }()
+
+	outside := filepath.Join(tmp, "outside")
+	for _, dir := range []string{ReposDir, outside} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(ReposDir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := safeRepoDir("escape"); err == nil {
+		t.Error("safeRepoDir: expected error for symlink pointing outside ReposDir, got none")
+	}
+}
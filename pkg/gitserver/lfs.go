@@ -0,0 +1,73 @@
+package gitserver
+
+import (
+	"bytes"
+	"fmt"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+// Git LFS support. Ordinary git commands run on the gitserver with
+// GIT_LFS_SKIP_SMUDGE=1 (see runGitCommand), so repos that use LFS
+// return pointer files instead of failing or blocking on a download.
+// When a caller actually needs the blob content, LFSFetch downloads
+// the LFS objects for the repo. LFS handling is opt-in per repo: it
+// only applies to repos whose .gitattributes routes paths through the
+// lfs filter, so non-LFS repos are unaffected.
+
+// UsesLFS reports whether the repo's .gitattributes at HEAD routes
+// any paths through the Git LFS filter.
+func UsesLFS(repo string) (bool, error) {
+	cmd := Command("git", "show", "HEAD:.gitattributes")
+	cmd.Repo = repo
+	out, err := cmd.Output()
+	if _, ok := err.(*ExitError); ok {
+		// No .gitattributes at HEAD (or no HEAD yet).
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return hasLFSFilter(out), nil
+}
+
+// hasLFSFilter reports whether the .gitattributes content routes any
+// paths through the lfs filter (a "filter=lfs" attribute on a
+// non-comment line).
+func hasLFSFilter(attributes []byte) bool {
+	for _, line := range bytes.Split(attributes, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		// The pattern is the first field; the attributes follow.
+		for _, field := range bytes.Fields(line)[1:] {
+			if bytes.Equal(field, []byte("filter=lfs")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LFSFetch downloads the Git LFS objects referenced by the repo, for
+// callers that need real blob content rather than pointer files. It
+// is a no-op for repos that don't use LFS. opt configures remote
+// authentication like for Fetch.
+func LFSFetch(repo string, opt *vcs.RemoteOpts) error {
+	uses, err := UsesLFS(repo)
+	if err != nil {
+		return err
+	}
+	if !uses {
+		return nil
+	}
+
+	cmd := Command("git", "lfs", "fetch", "origin")
+	cmd.Repo = repo
+	cmd.Opt = opt
+	if out, err := cmd.CombinedOutputOrdered(); err != nil {
+		return fmt.Errorf("gitserver: lfs fetch failed: %s (output: %q)", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package gitserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/neelance/chanrpc/chanrpcutil"
+)
+
+func TestHasLFSFilter(t *testing.T) {
+	tests := []struct {
+		attributes string
+		want       bool
+	}{
+		{"", false},
+		{"*.go text\n*.md text\n", false},
+		{"# *.psd filter=lfs diff=lfs merge=lfs -text\n", false},
+		{"*.psd filter=lfs diff=lfs merge=lfs -text\n", true},
+		{"*.go text\n*.bin filter=lfs -text\n", true},
+		{"*.go filter=lfsish\n", false},
+	}
+	for _, test := range tests {
+		if got := hasLFSFilter([]byte(test.attributes)); got != test.want {
+			t.Errorf("hasLFSFilter(%q): got %v, want %v", test.attributes, got, test.want)
+		}
+	}
+}
+
+func TestUsesLFS(t *testing.T) {
+	tests := []struct {
+		reply *execReply
+		want  bool
+	}{
+		{
+			// No .gitattributes at HEAD.
+			reply: &execReply{
+				Stdout:        chanrpcutil.ToChunks(nil),
+				Stderr:        chanrpcutil.ToChunks([]byte("fatal: path '.gitattributes' does not exist\n")),
+				ProcessResult: failedProcessResult("exit status 128", 128),
+			},
+			want: false,
+		},
+		{
+			reply: &execReply{
+				Stdout:        chanrpcutil.ToChunks([]byte("*.go text\n")),
+				Stderr:        chanrpcutil.ToChunks(nil),
+				ProcessResult: emptyProcessResult(),
+			},
+			want: false,
+		},
+		{
+			reply: &execReply{
+				Stdout:        chanrpcutil.ToChunks([]byte("*.psd filter=lfs diff=lfs merge=lfs -text\n")),
+				Stderr:        chanrpcutil.ToChunks(nil),
+				ProcessResult: emptyProcessResult(),
+			},
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		server := make(chan *request)
+		servers = [](chan<- *request){server}
+
+		go func(reply *execReply) {
+			req := <-server
+			if want := []string{"show", "HEAD:.gitattributes"}; !reflect.DeepEqual(req.Exec.Args, want) {
+				t.Errorf("got args %v, want %v", req.Exec.Args, want)
+			}
+			chanrpcutil.Drain(req.Exec.Stdin)
+			req.Exec.ReplyChan <- reply
+			close(req.Exec.ReplyChan)
+		}(test.reply)
+
+		uses, err := UsesLFS("r/r")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uses != test.want {
+			t.Errorf("got uses %v, want %v", uses, test.want)
+		}
+	}
+	servers = nil
+}
+
+func failedProcessResult(msg string, status int) <-chan *processResult {
+	processResultChan := make(chan *processResult, 1)
+	processResultChan <- &processResult{Error: msg, ExitStatus: status}
+	return processResultChan
+}
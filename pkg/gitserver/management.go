@@ -9,7 +9,8 @@ import (
 	"hash/fnv"
 	"os"
 	"os/exec"
-	"path"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,7 +24,18 @@ type createRequest struct {
 	Repo         string
 	MirrorRemote string
 	Opt          *vcs.RemoteOpts
-	ReplyChan    chan<- *createReply
+	// NonBare initializes the new repository with a working tree. It
+	// only applies when MirrorRemote is empty; mirror clones are
+	// always bare.
+	NonBare bool
+	// Depth and Branch correspond to CloneOpts.Depth and
+	// CloneOpts.Branch. They only apply when MirrorRemote is set.
+	Depth  int
+	Branch string
+	// RemoteName corresponds to CloneOpts.RemoteName. It only
+	// applies when MirrorRemote is set.
+	RemoteName string
+	ReplyChan  chan<- *createReply
 }
 
 type createReply struct {
@@ -47,7 +59,18 @@ func handleCreateRequest(req *createRequest) {
 	defer close(req.ReplyChan)
 	defer func() { defer observeCreate(start, status) }()
 
-	dir := path.Join(ReposDir, req.Repo)
+	if !beginRequest() {
+		status = "draining"
+		return
+	}
+	defer endRequest()
+
+	dir, err := safeRepoDir(req.Repo)
+	if err != nil {
+		req.ReplyChan <- &createReply{Error: err.Error()}
+		status = "invalid-repo-path"
+		return
+	}
 	cloningMu.Lock()
 	if _, ok := cloning[dir]; ok {
 		cloningMu.Unlock()
@@ -74,11 +97,37 @@ func handleCreateRequest(req *createRequest) {
 	}()
 
 	if req.MirrorRemote != "" {
-		cmd := exec.Command("git", "clone", "--mirror", req.MirrorRemote, dir)
+		if err := checkRemoteName(req.RemoteName); req.RemoteName != "" && err != nil {
+			req.ReplyChan <- &createReply{Error: err.Error()}
+			status = "invalid-remote-name"
+			return
+		}
+		// A mirror clone (+refs/*:refs/*) conflicts with shallow and
+		// single-branch fetching, so those use a plain bare clone
+		// instead.
+		args := []string{"clone"}
+		if req.RemoteName != "" {
+			args = append(args, "--origin", req.RemoteName)
+		}
+		if req.Depth > 0 || req.Branch != "" {
+			args = append(args, "--bare")
+			if req.Depth > 0 {
+				args = append(args, "--depth", strconv.Itoa(req.Depth))
+			}
+			if req.Branch != "" {
+				args = append(args, "--single-branch", "--branch", req.Branch)
+			}
+		} else {
+			args = append(args, "--mirror")
+		}
+		args = append(args, req.MirrorRemote, dir)
+		cmd := exec.Command("git", args...)
 
 		var outputBuf bytes.Buffer
 		cmd.Stdout = &outputBuf
 		cmd.Stderr = &outputBuf
+		trackCmd(cmd)
+		defer untrackCmd(cmd)
 		if err := runWithRemoteOpts(cmd, req.Opt); err != nil {
 			req.ReplyChan <- &createReply{Error: fmt.Sprintf("cloning repository %s failed with output:\n%s", req.Repo, outputBuf.String())}
 			status = "clone-fail"
@@ -89,7 +138,11 @@ func handleCreateRequest(req *createRequest) {
 		return
 	}
 
-	cmd := exec.Command("git", "init", "--bare", dir)
+	initArgs := []string{"init", "--bare", dir}
+	if req.NonBare {
+		initArgs = []string{"init", dir}
+	}
+	cmd := exec.Command("git", initArgs...)
 	if out, err := cmd.CombinedOutput(); err != nil {
 		req.ReplyChan <- &createReply{Error: fmt.Sprintf("initializing repository %s failed with output:\n%s", req.Repo, string(out))}
 		status = "init-fail"
@@ -99,15 +152,325 @@ func handleCreateRequest(req *createRequest) {
 	req.ReplyChan <- &createReply{}
 }
 
-func Init(repo string) error {
-	return create(repo, "", nil)
+// Init initializes a new empty repository named repo under ReposDir
+// on one of the gitservers. If bare is false, the repository is
+// created with a working tree. Init is idempotent with respect to
+// existing repositories: it returns vcs.ErrRepoExist (and changes
+// nothing) if any gitserver already has the repo.
+func Init(repo string, bare bool) error {
+	return create(repo, "", nil, !bare)
 }
 
+// CloneOpts configures a gitserver clone beyond authentication.
+type CloneOpts struct {
+	RemoteOpts *vcs.RemoteOpts
+
+	// Depth, if greater than zero, creates a shallow clone with that
+	// many commits of history (git clone --depth). A shallow clone
+	// can later be converted to a full one with Unshallow. This keeps
+	// disk usage bounded for huge repos that are only indexed
+	// shallowly.
+	Depth int
+
+	// Branch, if set, clones only that branch (git clone
+	// --single-branch --branch).
+	Branch string
+
+	// RemoteName, if set, names the clone's remote (git clone
+	// --origin) instead of the default "origin", for mirroring
+	// setups that use a different convention.
+	RemoteName string
+}
+
+// Clone clones the repository at remote into a bare repository named
+// repo under ReposDir on one of the gitservers, using opt for
+// authentication. Like Init, it returns vcs.ErrRepoExist if the repo
+// already exists anywhere in the cluster.
 func Clone(repo string, remote string, opt *vcs.RemoteOpts) error {
+	return CloneWithOpts(repo, remote, &CloneOpts{RemoteOpts: opt})
+}
+
+// CloneWithOpts is like Clone, but also applies the shallow-clone and
+// single-branch options in opt.
+func CloneWithOpts(repo string, remote string, opt *CloneOpts) error {
 	if remote == "" {
 		return errors.New("empty remote")
 	}
-	return create(repo, remote, opt)
+	if opt == nil {
+		opt = &CloneOpts{}
+	}
+	return createWithOpts(repo, remote, opt, false)
+}
+
+// Unshallow converts a repository cloned with CloneOpts.Depth into a
+// full clone by fetching its remaining history, using opt for
+// authentication. It is a no-op error from git if the repository is
+// not shallow.
+func Unshallow(repo string, opt *vcs.RemoteOpts) error {
+	cmd := Command("git", "fetch", "--unshallow")
+	cmd.Repo = repo
+	cmd.Opt = opt
+	return cmd.Run()
+}
+
+// Fetch updates an existing repository from its configured remote,
+// pruning refs that no longer exist upstream, using opt for
+// authentication. It reports whether any refs were created, updated,
+// or pruned, so a sync orchestrator can cheaply tell "already up to
+// date" from "got new data" without re-cloning. If the repository
+// exists but git can no longer operate on it, a *CorruptRepoError is
+// returned so the caller can recover (Remove and clone again) instead
+// of treating the failure as fatal.
+func Fetch(repo string, opt *vcs.RemoteOpts) (updated bool, err error) {
+	return FetchRemote(repo, "", opt)
+}
+
+// FetchRemote is Fetch from a specific named remote, for repos
+// mirrored from more than one upstream. An empty remote preserves
+// Fetch's behavior: git fetches from the clone's default remote
+// (normally origin).
+func FetchRemote(repo, remote string, opt *vcs.RemoteOpts) (updated bool, err error) {
+	if remote != "" {
+		if err := checkRemoteName(remote); err != nil {
+			return false, err
+		}
+	}
+
+	unlock := lockRepoMaintenance(repo)
+	defer unlock()
+
+	args := []string{"git", "fetch", "--prune"}
+	if remote != "" {
+		args = append(args, remote)
+	}
+	cmd := Command(args[0], args[1:]...)
+	cmd.Repo = repo
+	cmd.Opt = opt
+	out, err := cmd.CombinedOutputOrdered()
+	if err != nil {
+		if isCorruptRepo(out) {
+			return false, &CorruptRepoError{Repo: repo, Output: out}
+		}
+		return false, err
+	}
+	return fetchUpdatedRefs(out), nil
+}
+
+// checkRemoteName rejects remote names that git would parse as
+// something other than a remote name. Names come in over the RPC
+// boundary, so one starting with "-" could smuggle an option into the
+// git command line.
+func checkRemoteName(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return fmt.Errorf("gitserver: invalid remote name %q", name)
+	}
+	return nil
+}
+
+// SetRemote points the named remote of repo at url, creating the
+// remote if it doesn't exist yet. Together with FetchRemote it lets a
+// mirroring setup maintain several upstreams per repo instead of just
+// the default origin.
+func SetRemote(repo, name, url string) error {
+	if err := checkRemoteName(name); err != nil {
+		return err
+	}
+	if url == "" || strings.HasPrefix(url, "-") {
+		return fmt.Errorf("gitserver: invalid remote url %q", url)
+	}
+
+	cmd := Command("git", "remote", "set-url", name, url)
+	cmd.Repo = repo
+	out, err := cmd.CombinedOutputOrdered()
+	if err == nil {
+		return nil
+	}
+	if !strings.Contains(string(out), "No such remote") {
+		return fmt.Errorf("gitserver: setting remote %s of %s failed: %s (output: %q)", name, repo, err, out)
+	}
+	cmd = Command("git", "remote", "add", name, url)
+	cmd.Repo = repo
+	if out, err := cmd.CombinedOutputOrdered(); err != nil {
+		return fmt.Errorf("gitserver: adding remote %s of %s failed: %s (output: %q)", name, repo, err, out)
+	}
+	return nil
+}
+
+// fetchUpdatedRefs reports whether git fetch's output indicates any
+// ref was created, updated, or pruned: git prints a "From <url>"
+// header followed by one line per changed ref, and nothing at all
+// when everything was already up to date.
+func fetchUpdatedRefs(out []byte) bool {
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "From ") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// corruptRepoMarkers are fragments of git's output that indicate a
+// repository that exists on disk but is damaged (interrupted fetch,
+// disk issue, ...) rather than a transient command failure.
+var corruptRepoMarkers = []string{
+	"not a git repository",
+	"corrupt",
+	"bad object",
+	"loose object",
+	"missing blob",
+	"missing tree",
+	"missing commit",
+}
+
+// isCorruptRepo reports whether git's output indicates on-disk repo
+// corruption.
+func isCorruptRepo(out []byte) bool {
+	s := strings.ToLower(string(out))
+	for _, marker := range corruptRepoMarkers {
+		if strings.Contains(s, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// CorruptRepoError indicates a repository that exists under ReposDir
+// but that git can no longer operate on. It is recoverable: Remove
+// the repository and clone it again (or call Repair, which does
+// both).
+type CorruptRepoError struct {
+	Repo   string
+	Output []byte // git's output identifying the damage
+}
+
+func (e *CorruptRepoError) Error() string {
+	return fmt.Sprintf("gitserver: repository %s is corrupt", e.Repo)
+}
+
+// FsckResult is the outcome of an integrity check of one repository.
+type FsckResult struct {
+	// Healthy is whether git fsck found the repository intact.
+	Healthy bool
+	// Problems lists the issues git fsck reported, one per line of
+	// its output. Dangling objects are normal in fetched repositories
+	// and are not reported.
+	Problems []string
+}
+
+// Fsck checks the on-disk integrity of repo by running git fsck on
+// the gitserver that has it, so repeated cryptic Exec failures for a
+// repo can be distinguished from transient problems. A corrupt
+// repository is a result, not an error; the error return is for
+// failures to run the check at all.
+func Fsck(repo string) (*FsckResult, error) {
+	cmd := Command("git", "fsck", "--no-progress")
+	cmd.Repo = repo
+	out, err := cmd.CombinedOutputOrdered()
+	if err != nil {
+		if _, ok := err.(*ExitError); !ok {
+			return nil, err
+		}
+	}
+
+	res := &FsckResult{Healthy: err == nil}
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "dangling ") {
+			continue
+		}
+		res.Problems = append(res.Problems, line)
+	}
+	if len(res.Problems) > 0 {
+		res.Healthy = false
+	}
+	return res, nil
+}
+
+// Repair re-clones a corrupt repository from its configured origin
+// remote, using opt for authentication: the origin URL is read before
+// the damaged copy is removed, then the repository is cloned fresh.
+// It fails without removing anything if the repository has no usable
+// origin URL (e.g. a repository created with Init).
+func Repair(repo string, opt *vcs.RemoteOpts) error {
+	cmd := Command("git", "config", "--get", "remote.origin.url")
+	cmd.Repo = repo
+	out, err := cmd.Output()
+	origin := strings.TrimSpace(string(out))
+	if err != nil || origin == "" {
+		return fmt.Errorf("gitserver: cannot repair %s: no origin remote (%v)", repo, err)
+	}
+	if _, err := Remove(repo); err != nil {
+		return err
+	}
+	return Clone(repo, origin, opt)
+}
+
+// Per-repo maintenance lock, so a GC doesn't run concurrently with a
+// Fetch of the same repo started from this process. (git's own
+// gc.lock protects against two gcs; this avoids gc pruning objects a
+// fetch is in the middle of writing.) Reads are unaffected: they
+// don't take the lock.
+var (
+	maintenanceMu    sync.Mutex
+	maintenanceLocks = map[string]*sync.Mutex{}
+)
+
+// lockRepoMaintenance locks the maintenance mutex of repo and returns
+// the unlock func.
+func lockRepoMaintenance(repo string) func() {
+	maintenanceMu.Lock()
+	mu := maintenanceLocks[repo]
+	if mu == nil {
+		mu = &sync.Mutex{}
+		maintenanceLocks[repo] = mu
+	}
+	maintenanceMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// GCResult reports the effect of a GC run on a repository's size on
+// disk.
+type GCResult struct {
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// GC garbage-collects a repository, returning its size on disk before
+// and after so a maintenance orchestrator can see what it reclaimed.
+// aggressive selects `git gc --aggressive`, which repacks more
+// thoroughly at a much higher cost. The gc runs as an ordinary
+// command on the server holding the repo (so it counts against the
+// server's concurrency limit like any other command) and takes the
+// per-repo maintenance lock so it can't collide with a concurrent
+// Fetch; reads proceed normally while it runs.
+func GC(repo string, aggressive bool) (*GCResult, error) {
+	unlock := lockRepoMaintenance(repo)
+	defer unlock()
+
+	before, err := diskUsage(repo, true)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"gc", "--quiet"}
+	if aggressive {
+		args = append(args, "--aggressive")
+	}
+	cmd := Command("git", args...)
+	cmd.Repo = repo
+	cmd.Timeout = TimeoutForRepoSize(before)
+	if out, err := cmd.CombinedOutputOrdered(); err != nil {
+		return nil, fmt.Errorf("gitserver: gc of %s failed: %s (output: %q)", repo, err, out)
+	}
+
+	after, err := diskUsage(repo, true)
+	if err != nil {
+		return nil, err
+	}
+	return &GCResult{SizeBefore: before, SizeAfter: after}, nil
 }
 
 // create creates a new repository in the gitserver cluster by initializing an empty repository
@@ -117,7 +480,11 @@ func Clone(repo string, remote string, opt *vcs.RemoteOpts) error {
 // A nil error is returned if the new repository was created successfully, but vcs.ErrRepoExist
 // is returned if there was already an existing repository in its place, causing create to be noop.
 // If the repository is in process of being cloned, vcs.RepoNotExistError{CloneInProgress: true} is returned.
-func create(repo string, mirrorRemote string, opt *vcs.RemoteOpts) error {
+func create(repo string, mirrorRemote string, opt *vcs.RemoteOpts, nonBare bool) error {
+	return createWithOpts(repo, mirrorRemote, &CloneOpts{RemoteOpts: opt}, nonBare)
+}
+
+func createWithOpts(repo string, mirrorRemote string, opt *CloneOpts, nonBare bool) error {
 	// We check if repo already exists by executing `git remote`. It may seem redundant since the
 	// create request also checks that, but the purpose is to first do a broadcast and check if _any_
 	// server already has the repo available.
@@ -145,13 +512,24 @@ func create(repo string, mirrorRemote string, opt *vcs.RemoteOpts) error {
 	sum := md5.Sum([]byte(repo))
 	serverIndex := binary.BigEndian.Uint64(sum[:]) % uint64(len(servers))
 
+	remoteOpts, err := resolveHTTPSAuth(repo, opt.RemoteOpts)
+	if err != nil {
+		return err
+	}
+
 	replyChan := make(chan *createReply, 1)
-	servers[serverIndex] <- &request{Create: &createRequest{
+	if !deliver(servers[serverIndex], &request{Create: &createRequest{
 		Repo:         repo,
 		MirrorRemote: mirrorRemote,
-		Opt:          opt,
+		Opt:          remoteOpts,
+		NonBare:      nonBare,
+		Depth:        opt.Depth,
+		Branch:       opt.Branch,
+		RemoteName:   opt.RemoteName,
 		ReplyChan:    replyChan,
-	}}
+	}}) {
+		return ErrServerBusy
+	}
 
 	reply, ok := <-replyChan
 	if !ok {
@@ -190,7 +568,18 @@ func handleRemoveRequest(req *removeRequest) {
 	defer close(req.ReplyChan)
 	defer func() { defer observeRemove(status) }()
 
-	dir := path.Join(ReposDir, req.Repo)
+	if !beginRequest() {
+		status = "draining"
+		return
+	}
+	defer endRequest()
+
+	dir, err := safeRepoDir(req.Repo)
+	if err != nil {
+		req.ReplyChan <- &removeReply{Error: err.Error()}
+		status = "invalid-repo-path"
+		return
+	}
 	cloningMu.Lock()
 	_, cloneInProgress := cloning[dir]
 	cloningMu.Unlock()
@@ -222,24 +611,34 @@ func handleRemoveRequest(req *removeRequest) {
 	status = "success"
 }
 
-func Remove(repo string) error {
+// Remove deletes the repository's directory from whichever gitserver
+// holds it, reporting whether it existed. The repo path is validated
+// against ReposDir the same way as every other request, so a removal
+// can never reach outside of it. Removing a repository that does not
+// exist anywhere is a successful no-op (existed=false), making Remove
+// safe to call again after a partial failure; a clone in progress is
+// still an error, since removing it would race the clone.
+func Remove(repo string) (existed bool, err error) {
 	genReply, err := broadcastCall(func() (*request, func() (genericReply, bool)) {
 		replyChan := make(chan *removeReply, 1)
 		return &request{Remove: &removeRequest{Repo: repo, ReplyChan: replyChan}},
 			func() (genericReply, bool) { reply, ok := <-replyChan; return reply, ok }
 	})
+	if vcs.IsRepoNotExist(err) && !err.(vcs.RepoNotExistError).CloneInProgress {
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	reply := genReply.(*removeReply)
 	if reply.CloneInProgress {
-		return vcs.RepoNotExistError{CloneInProgress: true}
+		return false, vcs.RepoNotExistError{CloneInProgress: true}
 	}
 	if reply.Error != "" {
-		return errors.New(reply.Error)
+		return true, errors.New(reply.Error)
 	}
-	return nil
+	return true, nil
 }
 
 // remove should be pretty much instant, so we just track counts
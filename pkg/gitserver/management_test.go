@@ -0,0 +1,260 @@
+package gitserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/neelance/chanrpc/chanrpcutil"
+)
+
+func TestFetchUpdatedRefs(t *testing.T) {
+	tests := []struct {
+		out  string
+		want bool
+	}{
+		{"", false},
+		{"From https://example.com/r/r\n", false},
+		{"From https://example.com/r/r\n   abc123..def456  master -> master\n", true},
+		{"From https://example.com/r/r\n * [new branch]    feature -> feature\n", true},
+		{"From https://example.com/r/r\n x [deleted]       (none) -> old-branch\n", true},
+	}
+	for _, test := range tests {
+		if got := fetchUpdatedRefs([]byte(test.out)); got != test.want {
+			t.Errorf("fetchUpdatedRefs(%q): got %v, want %v", test.out, got, test.want)
+		}
+	}
+}
+
+func TestIsCorruptRepo(t *testing.T) {
+	tests := []struct {
+		out  string
+		want bool
+	}{
+		{"fatal: not a git repository (or any of the parent directories)", true},
+		{"error: object file ./objects/ab/cdef is empty\nfatal: loose object abcdef is corrupt", true},
+		{"fatal: bad object HEAD", true},
+		{"fatal: could not read from remote repository", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isCorruptRepo([]byte(test.out)); got != test.want {
+			t.Errorf("isCorruptRepo(%q): got %v, want %v", test.out, got, test.want)
+		}
+	}
+}
+
+func TestFetch_corruptRepo(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	stderr := []byte("fatal: bad object refs/heads/master")
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		processResultChan := make(chan *processResult, 1)
+		processResultChan <- &processResult{Error: "exit status 128", ExitStatus: 128}
+		req.Exec.ReplyChan <- &execReply{
+			Combined:      true,
+			Stdout:        chanrpcutil.ToChunks(stderr),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: processResultChan,
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	_, err := Fetch("r/r", nil)
+	corruptErr, ok := err.(*CorruptRepoError)
+	if !ok {
+		t.Fatalf("expected *CorruptRepoError, got %#v", err)
+	}
+	if corruptErr.Repo != "r/r" {
+		t.Errorf("expected repo r/r in error, got %q", corruptErr.Repo)
+	}
+}
+
+func TestFetchRemote(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		if want := []string{"fetch", "--prune", "upstream"}; !reflect.DeepEqual(req.Exec.Args, want) {
+			t.Errorf("got args %v, want %v", req.Exec.Args, want)
+		}
+		req.Exec.ReplyChan <- &execReply{
+			Combined:      true,
+			Stdout:        chanrpcutil.ToChunks([]byte("From https://example.com/r/r\n   abc123..def456  master -> master\n")),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	updated, err := FetchRemote("r/r", "upstream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated {
+		t.Error("expected updated refs")
+	}
+
+	// A remote name that git would read as an option is rejected
+	// before anything is sent.
+	if _, err := FetchRemote("r/r", "--upload-pack=evil", nil); err == nil {
+		t.Error("expected error for option-like remote name, got none")
+	}
+}
+
+func TestSetRemote(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	// The first attempt (set-url) fails because the remote doesn't
+	// exist; SetRemote falls back to adding it.
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		if want := []string{"remote", "set-url", "upstream", "https://example.com/r/r"}; !reflect.DeepEqual(req.Exec.Args, want) {
+			t.Errorf("got args %v, want %v", req.Exec.Args, want)
+		}
+		processResultChan := make(chan *processResult, 1)
+		processResultChan <- &processResult{Error: "exit status 2", ExitStatus: 2}
+		req.Exec.ReplyChan <- &execReply{
+			Combined:      true,
+			Stdout:        chanrpcutil.ToChunks([]byte("fatal: No such remote 'upstream'")),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: processResultChan,
+		}
+		close(req.Exec.ReplyChan)
+
+		req = <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		if want := []string{"remote", "add", "upstream", "https://example.com/r/r"}; !reflect.DeepEqual(req.Exec.Args, want) {
+			t.Errorf("got args %v, want %v", req.Exec.Args, want)
+		}
+		req.Exec.ReplyChan <- &execReply{
+			Combined:      true,
+			Stdout:        chanrpcutil.ToChunks(nil),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	if err := SetRemote("r/r", "upstream", "https://example.com/r/r"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Option-like names and URLs are rejected before anything is
+	// sent.
+	if err := SetRemote("r/r", "-upstream", "https://example.com/r/r"); err == nil {
+		t.Error("expected error for option-like remote name, got none")
+	}
+	if err := SetRemote("r/r", "upstream", "--upload-pack=evil"); err == nil {
+		t.Error("expected error for option-like remote url, got none")
+	}
+}
+
+func TestFsck(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		exitStatus   int
+		wantHealthy  bool
+		wantProblems []string
+	}{
+		{
+			name:        "healthy",
+			output:      "",
+			wantHealthy: true,
+		},
+		{
+			name:        "dangling objects are normal",
+			output:      "dangling commit abcdef0123456789\ndangling blob fedcba9876543210\n",
+			wantHealthy: true,
+		},
+		{
+			name:         "corrupt",
+			output:       "error: object file ./objects/ab/cdef is empty\nfatal: loose object abcdef is corrupt\n",
+			exitStatus:   1,
+			wantHealthy:  false,
+			wantProblems: []string{"error: object file ./objects/ab/cdef is empty", "fatal: loose object abcdef is corrupt"},
+		},
+	}
+
+	for _, test := range tests {
+		server := make(chan *request)
+		servers = [](chan<- *request){server}
+
+		go func(output string, exitStatus int) {
+			req := <-server
+			chanrpcutil.Drain(req.Exec.Stdin)
+			processResultChan := make(chan *processResult, 1)
+			pr := &processResult{ExitStatus: exitStatus}
+			if exitStatus != 0 {
+				pr.Error = "exit status 1"
+			}
+			processResultChan <- pr
+			req.Exec.ReplyChan <- &execReply{
+				Combined:      true,
+				Stdout:        chanrpcutil.ToChunks([]byte(output)),
+				Stderr:        chanrpcutil.ToChunks(nil),
+				ProcessResult: processResultChan,
+			}
+			close(req.Exec.ReplyChan)
+		}(test.output, test.exitStatus)
+
+		res, err := Fsck("r/r")
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if res.Healthy != test.wantHealthy {
+			t.Errorf("%s: got Healthy %v, want %v", test.name, res.Healthy, test.wantHealthy)
+		}
+		if !reflect.DeepEqual(res.Problems, test.wantProblems) {
+			t.Errorf("%s: got problems %v, want %v", test.name, res.Problems, test.wantProblems)
+		}
+	}
+}
+
+func TestGC(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+	defer func() { servers = nil }()
+
+	sizes := []int64{100, 60}
+	go func() {
+		for req := range server {
+			switch {
+			case req.ListRepos != nil:
+				if !req.ListRepos.Fresh {
+					t.Error("expected a fresh (uncached) size computation")
+				}
+				var size int64
+				size, sizes = sizes[0], sizes[1:]
+				req.ListRepos.ReplyChan <- &listReposReply{Repos: []*RepoInfo{{URI: "r/r", SizeBytes: size}}}
+				close(req.ListRepos.ReplyChan)
+			case req.Exec != nil:
+				if want := []string{"gc", "--quiet"}; !reflect.DeepEqual(req.Exec.Args, want) {
+					t.Errorf("got args %v, want %v", req.Exec.Args, want)
+				}
+				chanrpcutil.Drain(req.Exec.Stdin)
+				req.Exec.ReplyChan <- &execReply{
+					Stdout:        chanrpcutil.ToChunks(nil),
+					Stderr:        chanrpcutil.ToChunks(nil),
+					ProcessResult: emptyProcessResult(),
+				}
+				close(req.Exec.ReplyChan)
+			}
+		}
+	}()
+
+	res, err := GC("r/r", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&GCResult{SizeBefore: 100, SizeAfter: 60}); !reflect.DeepEqual(res, want) {
+		t.Errorf("got %+v, want %+v", res, want)
+	}
+}
@@ -0,0 +1,225 @@
+package gitserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	pathpkg "path"
+	"strings"
+
+	"github.com/neelance/chanrpc/chanrpcutil"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+// Reading one file at one revision is by far the most common git
+// operation, and callers used to hand-roll it with Command("git",
+// "show", rev+":"+path) and string-match stderr to tell "no such file"
+// from "no such revision". ReadFile and ReadFileStream do that once,
+// correctly: arguments are validated before they are spliced into a
+// git argument, and failures come back as distinct types
+// (*FileNotFoundError, *RevNotFoundError, vcs.RepoNotExistError)
+// instead of raw exit errors.
+
+// FileNotFoundError is returned by ReadFile and ReadFileStream when
+// the revision exists but contains no file at the requested path.
+type FileNotFoundError struct {
+	Path string
+	Rev  string
+}
+
+func (e *FileNotFoundError) Error() string {
+	return fmt.Sprintf("gitserver: file %q not found at revision %q", e.Path, e.Rev)
+}
+
+// IsFileNotFound reports whether err is a *FileNotFoundError.
+func IsFileNotFound(err error) bool {
+	_, ok := err.(*FileNotFoundError)
+	return ok
+}
+
+// RevNotFoundError is returned by ReadFile and ReadFileStream when
+// the requested revision does not resolve in the repository.
+type RevNotFoundError struct {
+	Rev string
+}
+
+func (e *RevNotFoundError) Error() string {
+	return fmt.Sprintf("gitserver: revision %q not found", e.Rev)
+}
+
+// IsRevNotFound reports whether err is a *RevNotFoundError.
+func IsRevNotFound(err error) bool {
+	_, ok := err.(*RevNotFoundError)
+	return ok
+}
+
+// checkReadFileArgs validates rev and path before they are combined
+// into the single "rev:path" git argument. It rejects revisions that
+// would be parsed as flags or change the rev/path split, and paths
+// that are absolute or escape the repository root.
+func checkReadFileArgs(rev, path string) error {
+	if rev == "" {
+		return fmt.Errorf("gitserver: empty revision")
+	}
+	if strings.HasPrefix(rev, "-") {
+		return fmt.Errorf("gitserver: invalid revision %q (begins with '-')", rev)
+	}
+	if strings.Contains(rev, ":") {
+		return fmt.Errorf("gitserver: invalid revision %q (contains ':')", rev)
+	}
+	if path == "" {
+		return fmt.Errorf("gitserver: empty path")
+	}
+	if strings.HasPrefix(path, "/") {
+		return fmt.Errorf("gitserver: invalid path %q (absolute)", path)
+	}
+	if clean := pathpkg.Clean(path); clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("gitserver: invalid path %q (escapes repository root)", path)
+	}
+	return nil
+}
+
+// ReadFile returns the contents of the file at path in repo at rev.
+//
+// A missing file, a missing revision, and a missing repository are
+// distinguishable via IsFileNotFound, IsRevNotFound, and
+// vcs.IsRepoNotExist respectively. Use ReadFileStream for files large
+// enough that materializing them in one []byte is a concern.
+func ReadFile(repo, rev, path string) ([]byte, error) {
+	rc, err := ReadFileStream(repo, rev, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// ReadFileStream is like ReadFile but returns the contents as a
+// stream, so large files are consumed chunk by chunk as they arrive
+// from the gitserver instead of being materialized up front. Errors
+// the server reports after output has started (and the not-found
+// classifications for commands that fail before producing any) are
+// returned by Read in place of io.EOF. The caller must Close the
+// returned reader.
+func ReadFileStream(repo, rev, path string) (io.ReadCloser, error) {
+	if err := checkReadFileArgs(rev, path); err != nil {
+		return nil, err
+	}
+
+	genReply, err := broadcastCall(func() (*request, func() (genericReply, bool)) {
+		replyChan := make(chan *execReply, 1)
+		return &request{Exec: &execRequest{Repo: repo, Args: []string{"show", rev + ":" + path}, Stdin: chanrpcutil.ToChunks(nil), ReplyChan: replyChan}},
+			func() (genericReply, bool) { reply, ok := <-replyChan; return reply, ok }
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reply := genReply.(*execReply)
+	if reply.CloneInProgress {
+		return nil, vcs.RepoNotExistError{CloneInProgress: true}
+	}
+
+	// Collect stderr concurrently; it is small (git's error message, if
+	// any) and is only consulted once stdout is exhausted.
+	fr := &fileReader{reply: reply, stderrC: chanrpcutil.ReadAll(reply.Stderr), rev: rev, path: path}
+	fr.r = chanrpcutil.NewReader(reply.Stdout)
+	if reply.StdoutGzipped {
+		gz, err := gzip.NewReader(fr.r)
+		if err != nil {
+			fr.Close()
+			return nil, err
+		}
+		fr.r = gz
+	}
+	return fr, nil
+}
+
+// fileReader streams one exec reply's stdout and, at end of stream,
+// folds the command's outcome into the final Read result.
+type fileReader struct {
+	reply     *execReply
+	r         io.Reader
+	stderrC   <-chan []byte
+	rev, path string
+	err       error // sticky result after the stream ends
+	finished  bool  // reply channels fully consumed
+}
+
+func (fr *fileReader) Read(p []byte) (int, error) {
+	if fr.err != nil {
+		return 0, fr.err
+	}
+	n, err := fr.r.Read(p)
+	if err == io.EOF {
+		if ferr := fr.finish(); ferr != nil {
+			err = ferr
+		}
+	}
+	if err != nil {
+		fr.err = err
+	}
+	return n, err
+}
+
+// finish consumes the command's result once stdout is exhausted and
+// maps a failure to its typed error.
+func (fr *fileReader) finish() error {
+	fr.finished = true
+	processResult := <-fr.reply.ProcessResult
+	stderr := <-fr.stderrC
+	if fr.reply.StderrGzipped {
+		if b, err := decompress(stderr); err == nil {
+			stderr = b
+		}
+	}
+	if fr.reply.TimedOut {
+		return ErrTimeout
+	}
+	if fr.reply.StdoutTruncated {
+		return ErrOutputTooLarge
+	}
+	if processResult.Error != "" {
+		return classifyReadFileError(stderr, fr.rev, fr.path, processResult)
+	}
+	return nil
+}
+
+// Close drains whatever is left of the reply so the server-side
+// channels are fully consumed even if the caller stopped reading
+// early. It never fails.
+func (fr *fileReader) Close() error {
+	if !fr.finished {
+		fr.finished = true
+		chanrpcutil.Drain(fr.reply.Stdout)
+		<-fr.stderrC
+		<-fr.reply.ProcessResult
+	}
+	return nil
+}
+
+// classifyReadFileError maps git's stderr for a failed `git show
+// rev:path` to a typed error. Unrecognized failures fall back to the
+// same error types DividedOutput produces.
+func classifyReadFileError(stderr []byte, rev, path string, res *processResult) error {
+	if isDubiousOwnership(stderr) {
+		return &DubiousOwnershipError{Stderr: stderr}
+	}
+	switch {
+	case bytes.Contains(stderr, []byte("exists on disk, but not in")),
+		bytes.Contains(stderr, []byte("does not exist")):
+		return &FileNotFoundError{Path: path, Rev: rev}
+	case bytes.Contains(stderr, []byte("invalid object name")),
+		bytes.Contains(stderr, []byte("unknown revision")),
+		bytes.Contains(stderr, []byte("bad revision")),
+		bytes.Contains(stderr, []byte("bad object")):
+		return &RevNotFoundError{Rev: rev}
+	}
+	if res.ExitStatus != 0 {
+		return &ExitError{Msg: res.Error, ExitStatus: res.ExitStatus, Stderr: stderr}
+	}
+	return fmt.Errorf("gitserver: read file failed: %s", res.Error)
+}
@@ -0,0 +1,159 @@
+package gitserver
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/neelance/chanrpc/chanrpcutil"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+func TestCheckReadFileArgs(t *testing.T) {
+	tests := []struct {
+		rev, path string
+		wantErr   bool
+	}{
+		{"master", "README.md", false},
+		{"v1.0", "dir/sub/file.go", false},
+		{"deadbeef", "a/../b", false}, // stays inside the repo
+		{"", "f", true},
+		{"master", "", true},
+		{"--help", "f", true},
+		{"-rf", "f", true},
+		{"master:x", "f", true},
+		{"master", "/etc/passwd", true},
+		{"master", "../../etc/passwd", true},
+		{"master", "a/../../b", true},
+	}
+	for _, test := range tests {
+		err := checkReadFileArgs(test.rev, test.path)
+		if (err != nil) != test.wantErr {
+			t.Errorf("checkReadFileArgs(%q, %q): got err %v, want error: %v", test.rev, test.path, err, test.wantErr)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		if want := []string{"show", "v1.0:dir/file.txt"}; !reflect.DeepEqual(req.Exec.Args, want) {
+			t.Errorf("got args %v, want %v", req.Exec.Args, want)
+		}
+		req.Exec.ReplyChan <- &execReply{
+			Stdout:        chanrpcutil.ToChunks([]byte("contents\n")),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	data, err := ReadFile("my/repo", "v1.0", "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("contents\n"); !bytes.Equal(data, want) {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestReadFile_gzipped(t *testing.T) {
+	want := bytes.Repeat([]byte("a"), execCompressionThreshold)
+	compressed, ok := maybeCompress(want)
+	if !ok {
+		t.Fatal("expected content above threshold to be compressed")
+	}
+
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{
+			StdoutGzipped: true,
+			Stdout:        chanrpcutil.ToChunks(compressed),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	data, err := ReadFile("my/repo", "master", "big.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("content was not transparently decompressed (got %d bytes, want %d)", len(data), len(want))
+	}
+}
+
+func readFileError(t *testing.T, stderr string) error {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		processResultChan := make(chan *processResult, 1)
+		processResultChan <- &processResult{Error: "exit status 128", ExitStatus: 128}
+		req.Exec.ReplyChan <- &execReply{
+			Stdout:        chanrpcutil.ToChunks(nil),
+			Stderr:        chanrpcutil.ToChunks([]byte(stderr)),
+			ProcessResult: processResultChan,
+		}
+		close(req.Exec.ReplyChan)
+	}()
+
+	_, err := ReadFile("my/repo", "master", "missing.txt")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	return err
+}
+
+func TestReadFile_fileNotFound(t *testing.T) {
+	err := readFileError(t, "fatal: path 'missing.txt' does not exist in 'master'")
+	if !IsFileNotFound(err) {
+		t.Fatalf("expected *FileNotFoundError, got %#v", err)
+	}
+	if IsRevNotFound(err) {
+		t.Error("IsRevNotFound reported true for a file-not-found error")
+	}
+}
+
+func TestReadFile_revNotFound(t *testing.T) {
+	err := readFileError(t, "fatal: invalid object name 'master'.")
+	if !IsRevNotFound(err) {
+		t.Fatalf("expected *RevNotFoundError, got %#v", err)
+	}
+}
+
+func TestReadFile_otherGitError(t *testing.T) {
+	err := readFileError(t, "fatal: something else entirely")
+	if _, ok := err.(*ExitError); !ok {
+		t.Fatalf("expected *ExitError, got %#v", err)
+	}
+}
+
+func TestReadFile_repoNotFound(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{RepoNotFound: true}
+		close(req.Exec.ReplyChan)
+	}()
+
+	_, err := ReadFile("no/repo", "master", "f")
+	if !vcs.IsRepoNotExist(err) {
+		t.Fatalf("expected RepoNotExistError, got %#v", err)
+	}
+}
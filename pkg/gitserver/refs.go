@@ -0,0 +1,108 @@
+package gitserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Enumerating a repo's branches or tags is another operation callers
+// used to hand-roll, each running `git for-each-ref` (or `git
+// branch`) via Command and re-parsing git's textual output. ListRefs
+// does the parsing once, with an unambiguous NUL-separated format,
+// and returns structured refs.
+
+// RefKind selects which refs ListRefs returns.
+type RefKind string
+
+const (
+	// RefBranches selects local branches (refs/heads).
+	RefBranches RefKind = "branches"
+	// RefTags selects tags (refs/tags).
+	RefTags RefKind = "tags"
+)
+
+// refKindPrefixes maps a RefKind to the full ref namespace it covers.
+var refKindPrefixes = map[RefKind]string{
+	RefBranches: "refs/heads/",
+	RefTags:     "refs/tags/",
+}
+
+// Ref is one branch or tag of a repository.
+type Ref struct {
+	// Name is the short ref name, e.g. "master" or "v1.0.0".
+	Name string
+	// CommitID is the commit the ref points to. An annotated tag is
+	// peeled, so this is the tagged commit, not the tag object.
+	CommitID string
+	// IsDefault is set on the branch the repository's HEAD points
+	// to. It is never set on tags, and no branch has it when HEAD is
+	// detached or unborn.
+	IsDefault bool
+}
+
+// ListRefs returns repo's branches or tags, sorted by name. A
+// repository with none of the requested kind yields an empty list; a
+// missing repository is reported via vcs.IsRepoNotExist.
+func ListRefs(repo string, kind RefKind) ([]*Ref, error) {
+	prefix, ok := refKindPrefixes[kind]
+	if !ok {
+		return nil, fmt.Errorf("gitserver: invalid ref kind %q", kind)
+	}
+
+	// %(*objectname) is empty except for annotated tags, where it is
+	// the peeled target. for-each-ref sorts by refname by default.
+	cmd := Command("git", "for-each-ref", "--format=%(objectname)%00%(*objectname)%00%(refname)", strings.TrimSuffix(prefix, "/"))
+	cmd.Repo = repo
+	cmd.Idempotent = true
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []*Ref
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\x00", 3)
+		if len(fields) != 3 || !strings.HasPrefix(fields[2], prefix) {
+			return nil, fmt.Errorf("gitserver: unexpected for-each-ref output line %q", line)
+		}
+		commit := fields[0]
+		if fields[1] != "" {
+			commit = fields[1]
+		}
+		refs = append(refs, &Ref{Name: strings.TrimPrefix(fields[2], prefix), CommitID: commit})
+	}
+
+	if kind == RefBranches && len(refs) > 0 {
+		head, err := defaultBranchRef(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			if prefix+ref.Name == head {
+				ref.IsDefault = true
+			}
+		}
+	}
+	return refs, nil
+}
+
+// defaultBranchRef returns the full ref name HEAD points to (e.g.
+// "refs/heads/master"), or "" when HEAD is detached or unborn.
+func defaultBranchRef(repo string) (string, error) {
+	cmd := Command("git", "symbolic-ref", "--quiet", "HEAD")
+	cmd.Repo = repo
+	cmd.Idempotent = true
+	out, err := cmd.Output()
+	if err != nil {
+		// symbolic-ref --quiet exits non-zero for a detached HEAD,
+		// which simply means no branch is the default.
+		if _, ok := err.(*ExitError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,152 @@
+package gitserver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/neelance/chanrpc/chanrpcutil"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+// serveRefsRequests replies to each expected request in order with
+// the given stdout, asserting the command's args.
+func serveRefsRequests(t *testing.T, server chan *request, replies []struct {
+	wantArgs []string
+	stdout   string
+	exit     int
+}) {
+	go func() {
+		for _, reply := range replies {
+			req := <-server
+			if !reflect.DeepEqual(req.Exec.Args, reply.wantArgs) {
+				t.Errorf("got args %q, want %q", req.Exec.Args, reply.wantArgs)
+			}
+			chanrpcutil.Drain(req.Exec.Stdin)
+			resultC := emptyProcessResult()
+			if reply.exit != 0 {
+				c := make(chan *processResult, 1)
+				c <- &processResult{Error: "exit status 1", ExitStatus: reply.exit}
+				resultC = c
+			}
+			req.Exec.ReplyChan <- &execReply{
+				Stdout:        chanrpcutil.ToChunks([]byte(reply.stdout)),
+				Stderr:        chanrpcutil.ToChunks(nil),
+				ProcessResult: resultC,
+			}
+			close(req.Exec.ReplyChan)
+		}
+	}()
+}
+
+func TestListRefs_branches(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	serveRefsRequests(t, server, []struct {
+		wantArgs []string
+		stdout   string
+		exit     int
+	}{
+		{
+			wantArgs: []string{"for-each-ref", "--format=%(objectname)%00%(*objectname)%00%(refname)", "refs/heads"},
+			stdout:   "aaaa\x00\x00refs/heads/feature\nbbbb\x00\x00refs/heads/master\n",
+		},
+		{
+			wantArgs: []string{"symbolic-ref", "--quiet", "HEAD"},
+			stdout:   "refs/heads/master\n",
+		},
+	})
+
+	refs, err := ListRefs("r/r", RefBranches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Ref{
+		{Name: "feature", CommitID: "aaaa"},
+		{Name: "master", CommitID: "bbbb", IsDefault: true},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("got refs %+v, want %+v", refs, want)
+	}
+}
+
+func TestListRefs_tags(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	// The second tag is annotated: %(*objectname) carries the peeled
+	// commit. Tags never trigger the HEAD lookup.
+	serveRefsRequests(t, server, []struct {
+		wantArgs []string
+		stdout   string
+		exit     int
+	}{
+		{
+			wantArgs: []string{"for-each-ref", "--format=%(objectname)%00%(*objectname)%00%(refname)", "refs/tags"},
+			stdout:   "aaaa\x00\x00refs/tags/v1.0.0\ncccc\x00bbbb\x00refs/tags/v1.1.0\n",
+		},
+	})
+
+	refs, err := ListRefs("r/r", RefTags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Ref{
+		{Name: "v1.0.0", CommitID: "aaaa"},
+		{Name: "v1.1.0", CommitID: "bbbb"},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("got refs %+v, want %+v", refs, want)
+	}
+}
+
+func TestListRefs_detachedHEAD(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	serveRefsRequests(t, server, []struct {
+		wantArgs []string
+		stdout   string
+		exit     int
+	}{
+		{
+			wantArgs: []string{"for-each-ref", "--format=%(objectname)%00%(*objectname)%00%(refname)", "refs/heads"},
+			stdout:   "aaaa\x00\x00refs/heads/master\n",
+		},
+		{
+			wantArgs: []string{"symbolic-ref", "--quiet", "HEAD"},
+			exit:     1,
+		},
+	})
+
+	refs, err := ListRefs("r/r", RefBranches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 1 || refs[0].IsDefault {
+		t.Errorf("got refs %+v, want one non-default branch", refs)
+	}
+}
+
+func TestListRefs_repoNotExist(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{RepoNotFound: true}
+		close(req.Exec.ReplyChan)
+	}()
+
+	if _, err := ListRefs("r/r", RefBranches); !vcs.IsRepoNotExist(err) {
+		t.Errorf("got error %v, want RepoNotExistError", err)
+	}
+}
+
+func TestListRefs_invalidKind(t *testing.T) {
+	if _, err := ListRefs("r/r", RefKind("bogus")); err == nil {
+		t.Error("expected error for invalid ref kind")
+	}
+}
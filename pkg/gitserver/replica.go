@@ -0,0 +1,74 @@
+package gitserver
+
+import (
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+// Read-only replica routing. Heavy read workloads (search indexing,
+// blob serving) can be pointed at replica gitservers whose ReposDir
+// mirrors the primaries', keeping the primaries' capacity for writes.
+// Commands whose git subcommand is in execReadOnly are served by the
+// replica pool when one is connected; everything else — and every
+// command when no replica is available or the replica doesn't have
+// the repo yet — goes to the primaries.
+
+// replicaServers is the pool of read-only replica gitservers, fed by
+// ConnectReplica. It is disjoint from servers (the primaries).
+var replicaServers [](chan<- *request)
+
+// ConnectReplica connects to a read-only replica gitserver. Replicas
+// only ever receive commands classified read-only in execReadOnly;
+// they are expected to mirror the primaries' ReposDir (e.g. via
+// periodic fetches), and a repo a replica doesn't have yet falls back
+// to the primaries transparently.
+func ConnectReplica(addr string) {
+	replicaServers = append(replicaServers, connect(addr))
+}
+
+// execReadOnly classifies the git subcommands that never modify the
+// repository and so may be served by a replica. It is deliberately an
+// allowlist (unlike execMutating, which guards locking): an
+// unclassified command is treated as potentially mutating and routed
+// to the primaries, so forgetting to list a new read-only command
+// costs performance, not correctness.
+var execReadOnly = map[string]bool{
+	"archive":       true,
+	"blame":         true,
+	"cat-file":      true,
+	"count-objects": true,
+	"diff":          true,
+	"diff-tree":     true,
+	"for-each-ref":  true,
+	"grep":          true,
+	"log":           true,
+	"ls-files":      true,
+	"ls-tree":       true,
+	"merge-base":    true,
+	"name-rev":      true,
+	"rev-list":      true,
+	"rev-parse":     true,
+	"shortlog":      true,
+	"show":          true,
+	"show-ref":      true,
+	"symbolic-ref":  true,
+}
+
+// useReplicas reports whether c should be routed to the replica pool:
+// a replica is connected, the subcommand is classified read-only, and
+// the command has no streamed stdin (which is consumed by the first
+// attempt, so it could not fall back to the primaries) and no remote
+// opts (network commands authenticate and fetch, which is primary
+// work).
+func (c *Cmd) useReplicas() bool {
+	return len(replicaServers) > 0 &&
+		len(c.Args) > 1 && execReadOnly[c.Args[1]] &&
+		c.stdin == nil && c.Opt == nil
+}
+
+// replicaFallbackError reports whether err from the replica pool
+// means the primaries should be tried instead: every replica was
+// unreachable or busy, or none of them has the repo (a new repo the
+// mirror hasn't picked up yet).
+func replicaFallbackError(err error) bool {
+	return err == errRPCFailed || err == ErrServerBusy || vcs.IsRepoNotExist(err)
+}
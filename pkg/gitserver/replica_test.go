@@ -0,0 +1,108 @@
+package gitserver
+
+import (
+	"testing"
+
+	"github.com/neelance/chanrpc/chanrpcutil"
+)
+
+// answerExec replies to one exec request on server with stdout.
+func answerExec(server chan *request, stdout string) {
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{
+			Stdout:        chanrpcutil.ToChunks([]byte(stdout)),
+			Stderr:        chanrpcutil.ToChunks(nil),
+			ProcessResult: emptyProcessResult(),
+		}
+		close(req.Exec.ReplyChan)
+	}()
+}
+
+// failExec replies to one exec request on server with RepoNotFound.
+func failExec(server chan *request) {
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{RepoNotFound: true}
+		close(req.Exec.ReplyChan)
+	}()
+}
+
+// refuseExec fails the test if server receives a request.
+func refuseExec(t *testing.T, server chan *request, msg string) {
+	go func() {
+		req, ok := <-server
+		if !ok {
+			return
+		}
+		t.Error(msg)
+		chanrpcutil.Drain(req.Exec.Stdin)
+		req.Exec.ReplyChan <- &execReply{RepoNotFound: true}
+		close(req.Exec.ReplyChan)
+	}()
+}
+
+func TestReplicaRouting_readOnly(t *testing.T) {
+	primary := make(chan *request)
+	replica := make(chan *request)
+	servers = [](chan<- *request){primary}
+	replicaServers = [](chan<- *request){replica}
+	defer func() { replicaServers = nil }()
+
+	refuseExec(t, primary, "read-only command sent to the primary")
+	defer close(primary)
+	answerExec(replica, "abc123\n")
+
+	cmd := Command("git", "rev-parse", "HEAD")
+	cmd.Repo = "r/r"
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "abc123\n" {
+		t.Errorf("got output %q, want the replica's reply", out)
+	}
+}
+
+func TestReplicaRouting_mutatingGoesToPrimary(t *testing.T) {
+	primary := make(chan *request)
+	replica := make(chan *request)
+	servers = [](chan<- *request){primary}
+	replicaServers = [](chan<- *request){replica}
+	defer func() { replicaServers = nil }()
+
+	refuseExec(t, replica, "mutating command sent to a replica")
+	defer close(replica)
+	answerExec(primary, "")
+
+	cmd := Command("git", "fetch", "--prune")
+	cmd.Repo = "r/r"
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicaRouting_fallbackToPrimary(t *testing.T) {
+	primary := make(chan *request)
+	replica := make(chan *request)
+	servers = [](chan<- *request){primary}
+	replicaServers = [](chan<- *request){replica}
+	defer func() { replicaServers = nil }()
+
+	// The replica doesn't have the repo (mirror lag); the primary
+	// answers.
+	failExec(replica)
+	answerExec(primary, "abc123\n")
+
+	cmd := Command("git", "rev-parse", "HEAD")
+	cmd.Repo = "r/r"
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "abc123\n" {
+		t.Errorf("got output %q, want the primary's reply", out)
+	}
+}
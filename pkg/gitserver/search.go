@@ -45,6 +45,12 @@ func handleSearchRequest(req *searchRequest) {
 	defer close(req.ReplyChan)
 	defer func() { defer observeSearch(req, start, status) }()
 
+	if !beginRequest() {
+		status = "draining"
+		return
+	}
+	defer endRequest()
+
 	dir := path.Join(ReposDir, req.Repo)
 	cloningMu.Lock()
 	_, cloneInProgress := cloning[dir]
@@ -85,6 +91,8 @@ func handleSearchRequest(req *searchRequest) {
 		status = "error"
 		return
 	}
+	trackCmd(cmd)
+	defer untrackCmd(cmd)
 
 	var results []*vcs.SearchResult
 	errc := make(chan error)
@@ -0,0 +1,88 @@
+package gitserver
+
+import (
+	"os/exec"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	drainingMu sync.Mutex
+	draining   bool
+	inFlight   sync.WaitGroup
+
+	runningCmdsMu sync.Mutex
+	runningCmds   = make(map[*exec.Cmd]struct{})
+)
+
+// Draining reports whether Shutdown has been called on this server.
+// Readiness probes should use this to take the server out of rotation
+// while it drains.
+func Draining() bool {
+	drainingMu.Lock()
+	defer drainingMu.Unlock()
+	return draining
+}
+
+// beginRequest registers an in-flight request so Shutdown can wait for
+// it. It reports false if the server is draining, in which case the
+// handler must refuse the request (by closing its reply channel
+// without replying, which the client treats like a dropped
+// connection).
+func beginRequest() bool {
+	drainingMu.Lock()
+	defer drainingMu.Unlock()
+	if draining {
+		return false
+	}
+	inFlight.Add(1)
+	return true
+}
+
+func endRequest() { inFlight.Done() }
+
+// trackCmd registers a git process so Shutdown can kill it if it
+// outlives the drain deadline. untrackCmd must be called once the
+// process has been waited for.
+func trackCmd(cmd *exec.Cmd) {
+	runningCmdsMu.Lock()
+	runningCmds[cmd] = struct{}{}
+	runningCmdsMu.Unlock()
+}
+
+func untrackCmd(cmd *exec.Cmd) {
+	runningCmdsMu.Lock()
+	delete(runningCmds, cmd)
+	runningCmdsMu.Unlock()
+}
+
+// Shutdown drains the server for a rolling restart: it stops accepting
+// new RPCs and waits for in-flight git processes to finish. If ctx is
+// canceled or its deadline passes before they do, the remaining git
+// processes are killed and Shutdown waits for their handlers to
+// return, so it is always safe to exit once Shutdown returns.
+func Shutdown(ctx context.Context) {
+	drainingMu.Lock()
+	draining = true
+	drainingMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		runningCmdsMu.Lock()
+		for cmd := range runningCmds {
+			if cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+		}
+		runningCmdsMu.Unlock()
+		<-done
+	}
+}
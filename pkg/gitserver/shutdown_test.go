@@ -0,0 +1,66 @@
+package gitserver
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func resetDraining() {
+	drainingMu.Lock()
+	draining = false
+	drainingMu.Unlock()
+}
+
+func TestShutdown_waitsForInFlight(t *testing.T) {
+	defer resetDraining()
+
+	if !beginRequest() {
+		t.Fatal("beginRequest refused before Shutdown")
+	}
+	finished := false
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		finished = true
+		endRequest()
+	}()
+
+	Shutdown(context.Background())
+	if !finished {
+		t.Error("Shutdown returned before in-flight request finished")
+	}
+	if !Draining() {
+		t.Error("Draining() = false after Shutdown")
+	}
+	if beginRequest() {
+		t.Error("beginRequest accepted a request while draining")
+	}
+}
+
+func TestShutdown_killsStragglers(t *testing.T) {
+	defer resetDraining()
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	trackCmd(cmd)
+	if !beginRequest() {
+		t.Fatal("beginRequest refused before Shutdown")
+	}
+	go func() {
+		cmd.Wait()
+		untrackCmd(cmd)
+		endRequest()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	Shutdown(ctx)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Shutdown took %s; straggler was not killed", elapsed)
+	}
+}
@@ -0,0 +1,74 @@
+package gitserver
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/traceutil"
+)
+
+// Tracing for the gitserver RPC. The exec RPC is a bare chanrpc call,
+// so the frontend's appdash trace would normally end at the client:
+// a slow page load shows a long gap where the git work happened.
+// Requests carry the originating span ID (Cmd.TraceSpan) across the
+// wire, and the server records a child span around the actual git
+// execution, so git command latency shows up in the request's trace.
+
+func init() { appdash.RegisterEvent(GitServerEvent{}) }
+
+// GitServerEvent is the appdash event recorded by a gitserver around
+// one git execution performed for a traced request.
+type GitServerEvent struct {
+	Cmd       string // the git subcommand, e.g. "log"
+	Args      string
+	Repo      string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+func (GitServerEvent) Schema() string { return "GitServer" }
+
+func (e GitServerEvent) Start() time.Time { return e.StartTime }
+func (e GitServerEvent) End() time.Time   { return e.EndTime }
+
+// TraceSpanFromContext returns the value to set as a Cmd's TraceSpan
+// to tie its server-side execution to the current request's trace, or
+// "" if the context carries no span.
+func TraceSpanFromContext(ctx context.Context) string {
+	span := traceutil.SpanIDFromContext(ctx)
+	if span.Trace == 0 {
+		return ""
+	}
+	return span.String()
+}
+
+// traceExec records a child span of the request's originating span
+// covering one git execution. It is a no-op for untraced requests and
+// when no collector is configured.
+func traceExec(req *execRequest, start time.Time) {
+	if req.TraceSpan == "" || traceutil.DefaultCollector == nil {
+		return
+	}
+	parent, err := appdash.ParseSpanID(req.TraceSpan)
+	if err != nil {
+		return
+	}
+
+	cmd := ""
+	if len(req.Args) > 0 {
+		cmd = req.Args[0]
+	}
+	rec := traceutil.NewRecorder(appdash.NewSpanID(*parent), traceutil.DefaultCollector)
+	rec.Name("gitserver." + cmd)
+	rec.Event(GitServerEvent{
+		Cmd:       cmd,
+		Args:      strings.Join(req.Args, " "),
+		Repo:      req.Repo,
+		StartTime: start,
+		EndTime:   time.Now(),
+	})
+	rec.Finish()
+}
@@ -0,0 +1,101 @@
+package gitserver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neelance/chanrpc/chanrpcutil"
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/appdash"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/traceutil"
+)
+
+// captureCollector records the span IDs of every collected annotation
+// set.
+type captureCollector struct {
+	mu    sync.Mutex
+	spans []appdash.SpanID
+}
+
+func (c *captureCollector) Collect(id appdash.SpanID, as ...appdash.Annotation) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, id)
+	return nil
+}
+
+func TestTraceExec(t *testing.T) {
+	collector := &captureCollector{}
+	origCollector := traceutil.DefaultCollector
+	traceutil.DefaultCollector = collector
+	defer func() { traceutil.DefaultCollector = origCollector }()
+
+	parent := appdash.NewRootSpanID()
+	req := &execRequest{Repo: "my/repo", Args: []string{"log", "--oneline"}, TraceSpan: parent.String()}
+	traceExec(req, time.Now())
+
+	if len(collector.spans) == 0 {
+		t.Fatal("no spans collected")
+	}
+	for _, span := range collector.spans {
+		if span.Trace != parent.Trace {
+			t.Errorf("got trace %v, want %v", span.Trace, parent.Trace)
+		}
+		if span.Parent != parent.Span {
+			t.Errorf("got parent %v, want %v", span.Parent, parent.Span)
+		}
+		if span.Span == parent.Span {
+			t.Error("child span reused the parent's span ID")
+		}
+	}
+}
+
+func TestTraceExec_untraced(t *testing.T) {
+	collector := &captureCollector{}
+	origCollector := traceutil.DefaultCollector
+	traceutil.DefaultCollector = collector
+	defer func() { traceutil.DefaultCollector = origCollector }()
+
+	traceExec(&execRequest{Repo: "my/repo", Args: []string{"log"}}, time.Now())
+	traceExec(&execRequest{Repo: "my/repo", Args: []string{"log"}, TraceSpan: "not a span"}, time.Now())
+	if len(collector.spans) != 0 {
+		t.Errorf("collected %d spans for untraced requests, want 0", len(collector.spans))
+	}
+}
+
+func TestTraceSpanFromContext(t *testing.T) {
+	if got := TraceSpanFromContext(context.Background()); got != "" {
+		t.Errorf("got %q for a context without a span, want empty", got)
+	}
+
+	span := appdash.NewRootSpanID()
+	ctx := traceutil.NewContext(context.Background(), span)
+	if got := TraceSpanFromContext(ctx); got != span.String() {
+		t.Errorf("got %q, want %q", got, span.String())
+	}
+}
+
+func TestExec_traceSpanForwarded(t *testing.T) {
+	server := make(chan *request)
+	servers = [](chan<- *request){server}
+
+	span := appdash.NewRootSpanID()
+	go func() {
+		req := <-server
+		chanrpcutil.Drain(req.Exec.Stdin)
+		if req.Exec.TraceSpan != span.String() {
+			t.Errorf("got TraceSpan %q, want %q", req.Exec.TraceSpan, span.String())
+		}
+		req.Exec.ReplyChan <- &execReply{Stdout: chanrpcutil.ToChunks(nil), Stderr: chanrpcutil.ToChunks(nil), ProcessResult: emptyProcessResult()}
+		close(req.Exec.ReplyChan)
+	}()
+
+	cmd := Command("git", "log")
+	cmd.Repo = "my/repo"
+	cmd.TraceSpan = span.String()
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
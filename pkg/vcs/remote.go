@@ -5,6 +5,15 @@ type RemoteOpts struct {
 	SSH *SSHConfig // ssh configuration for communication with the remote
 
 	HTTPS *HTTPSConfig // Optional HTTPS configuration for communication with the remote.
+
+	Proxy *ProxyConfig // Optional egress proxy for HTTP(S) communication with the remote.
+
+	// GitProtocolV2, if true, requests git wire protocol version 2
+	// for communication with the remote, which significantly speeds
+	// up fetches of repos with many refs. If false, git's default
+	// protocol version is used. Servers that don't support v2 fall
+	// back to the original protocol, so enabling it is safe.
+	GitProtocolV2 bool `json:",omitempty"`
 }
 
 type SSHConfig struct {
@@ -15,7 +24,28 @@ type SSHConfig struct {
 
 // HTTPSConfig configures HTTPS for communication with remotes.
 type HTTPSConfig struct {
+	// User is the username supplied when the remote prompts for one.
+	// Token-based hosts use a fixed placeholder (e.g. "x-access-token"
+	// for GitHub installation tokens, "oauth2" for GitLab). If empty,
+	// git infers the username from the remote URL.
+	User string `json:",omitempty"`
+
 	Pass string // Pass is the password provided to the vcs.
+
+	// Token is an access token used in place of a password. If set, it
+	// takes precedence over Pass. It is supplied to git through an
+	// askpass helper, never on the command line or in log output.
+	Token string `json:",omitempty"`
+}
+
+// ProxyConfig configures an egress proxy for HTTP(S) communication
+// with remotes. It only affects remotes reached over HTTP(S); SSH
+// remotes connect directly, since git's SSH transport does not go
+// through an HTTP proxy.
+type ProxyConfig struct {
+	HTTPProxy  string `json:",omitempty"` // proxy URL for HTTP remotes (HTTP_PROXY)
+	HTTPSProxy string `json:",omitempty"` // proxy URL for HTTPS remotes (HTTPS_PROXY)
+	NoProxy    string `json:",omitempty"` // comma-separated hosts to exclude from proxying (NO_PROXY)
 }
 
 // UpdateResult is the result of parsing output of the remote update operation.
@@ -12,6 +12,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	authpkg "sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/eventsutil"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/repotrackutil"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/statsutil"
@@ -89,10 +90,14 @@ func (s *builds) Create(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*s
 	}
 
 	b := &sourcegraph.Build{
-		Repo:        repo.URI,
-		CommitID:    op.CommitID,
-		Branch:      op.Branch,
-		Tag:         op.Tag,
+		Repo:     repo.URI,
+		CommitID: op.CommitID,
+		Branch:   op.Branch,
+		Tag:      op.Tag,
+		// Stamp the authenticated actor so manually triggered builds
+		// can be told apart from automated ones (hooks and workers run
+		// with no user; their CreatorUID stays 0).
+		CreatorUID:  int32(authpkg.ActorFromContext(ctx).UID),
 		CreatedAt:   pbtypes.NewTimestamp(time.Now()),
 		BuildConfig: op.Config,
 	}
@@ -163,6 +168,7 @@ func (s *builds) Update(ctx context.Context, op *sourcegraph.BuildsUpdateOp) (*s
 
 	if finished {
 		observeFinishedBuild(b)
+		notifyBuildCompleted(b)
 	}
 
 	var Result string
@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+)
+
+// Outbound build webhooks. When a build transitions to a terminal
+// state (Succeeded/Failed/Killed), a JSON payload describing the
+// build is POSTed to each URL in SG_BUILD_WEBHOOK_URLS
+// (space-separated). If SG_BUILD_WEBHOOK_SECRET is set, the payload is
+// HMAC-SHA256-signed and the hex signature sent in the
+// X-Sourcegraph-Signature header so receivers can authenticate it.
+var (
+	buildWebhookURLs   = strings.Fields(os.Getenv("SG_BUILD_WEBHOOK_URLS"))
+	buildWebhookSecret = os.Getenv("SG_BUILD_WEBHOOK_SECRET")
+)
+
+const (
+	buildWebhookRetries    = 3
+	buildWebhookRetryDelay = 2 * time.Second
+	buildWebhookTimeout    = 10 * time.Second
+)
+
+// buildWebhookPayload is the JSON body POSTed to build webhook URLs.
+type buildWebhookPayload struct {
+	Repo     string
+	ID       uint64
+	CommitID string
+	Branch   string `json:",omitempty"`
+	Tag      string `json:",omitempty"`
+
+	// Status is the build's final state: "success", "failed", or
+	// "killed".
+	Status string
+
+	// DurationSec is how long the build ran (EndedAt - StartedAt), in
+	// seconds. It is 0 if the build never started.
+	DurationSec float64
+}
+
+// notifyBuildCompleted posts b to the configured build webhook URLs.
+// Callers must only invoke it on the transition to a terminal state
+// (see the `finished` flag in Builds.Update), so a webhook never fires
+// twice for the same build.
+func notifyBuildCompleted(b *sourcegraph.Build) {
+	if len(buildWebhookURLs) == 0 {
+		return
+	}
+
+	payload := buildWebhookPayload{
+		Repo:     b.Repo,
+		ID:       b.ID,
+		CommitID: b.CommitID,
+		Branch:   b.Branch,
+		Tag:      b.Tag,
+	}
+	switch {
+	case b.Killed:
+		payload.Status = "killed"
+	case b.Failure:
+		payload.Status = "failed"
+	case b.Success:
+		payload.Status = "success"
+	default:
+		return
+	}
+	if b.StartedAt != nil && b.EndedAt != nil {
+		payload.DurationSec = b.EndedAt.Time().Sub(b.StartedAt.Time()).Seconds()
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log15.Error("Marshaling build webhook payload failed", "build", b.Spec().IDString(), "error", err)
+		return
+	}
+
+	for _, url := range buildWebhookURLs {
+		go postBuildWebhook(url, body)
+	}
+}
+
+func postBuildWebhook(url string, body []byte) {
+	client := &http.Client{Timeout: buildWebhookTimeout}
+	delay := buildWebhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := postBuildWebhookOnce(client, url, body)
+		if err == nil {
+			return
+		}
+		if attempt == buildWebhookRetries {
+			log15.Error("Posting build webhook failed", "url", url, "error", err)
+			return
+		}
+		log15.Warn("Posting build webhook failed (retrying)", "url", url, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func postBuildWebhookOnce(client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if buildWebhookSecret != "" {
+		req.Header.Set("X-Sourcegraph-Signature", buildWebhookSignature([]byte(buildWebhookSecret), body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Only 5xx responses are worth retrying; a 4xx means the receiver
+	// rejected the payload and will again.
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("webhook receiver returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// buildWebhookSignature returns the hex-encoded HMAC-SHA256 of body
+// under key.
+func buildWebhookSignature(key, body []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
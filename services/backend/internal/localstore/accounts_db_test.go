@@ -1,3 +1,4 @@
+//go:build pgsqltest
 // +build pgsqltest
 
 package localstore
@@ -84,6 +84,7 @@ type dbBuild struct {
 	Queue         bool
 	Priority      int
 	BuilderConfig string `db:"builder_config"`
+	CreatorUID    int32  `db:"creator_uid"`
 }
 
 func (b *dbBuild) toBuild() *sourcegraph.Build {
@@ -102,6 +103,7 @@ func (b *dbBuild) toBuild() *sourcegraph.Build {
 		Killed:      b.Killed,
 		Host:        b.Host,
 		Purged:      b.Purged,
+		CreatorUID:  b.CreatorUID,
 		BuildConfig: sourcegraph.BuildConfig{
 			Queue:         b.Queue,
 			Priority:      int32(b.Priority),
@@ -125,6 +127,7 @@ func (b *dbBuild) fromBuild(b2 *sourcegraph.Build) {
 	b.Killed = b2.Killed
 	b.Host = b2.Host
 	b.Purged = b2.Purged
+	b.CreatorUID = b2.CreatorUID
 	b.Queue = b2.Queue
 	b.Priority = int(b2.Priority)
 	b.BuilderConfig = b2.BuilderConfig
@@ -265,6 +268,15 @@ func (s *builds) List(ctx context.Context, opt *sourcegraph.BuildListOptions) ([
 			conds = append(conds, "b.commit_id LIKE "+arg(opt.CommitID+"%"))
 		}
 	}
+	if opt.Branch != "" {
+		conds = append(conds, "b.branch="+arg(opt.Branch))
+	}
+	if opt.Manual {
+		conds = append(conds, "b.creator_uid != 0")
+	}
+	if opt.Automated {
+		conds = append(conds, "b.creator_uid = 0")
+	}
 	condsSQL := strings.Join(conds, " AND ")
 	if condsSQL != "" {
 		condsSQL = "WHERE " + condsSQL
@@ -333,10 +345,10 @@ func (s *builds) Create(ctx context.Context, newBuild *sourcegraph.Build) (*sour
 	// Construct SQL manually so we can retrieve the id # from
 	// the DB trigger.
 	sql := `INSERT INTO repo_build(id, repo, commit_id, branch, tag, created_at, started_at, ended_at, heartbeat_at,
-                                   success, failure, killed, host, purged, queue, priority, builder_config)
+                                   success, failure, killed, host, purged, queue, priority, builder_config, creator_uid)
             VALUES(` + arg(b.ID) + `, ` + arg(b.Repo) + `, ` + arg(b.CommitID) + `, ` + arg(b.Branch) + `, ` + arg(b.Tag) + `, ` + arg(b.CreatedAt) + `, ` + arg(b.StartedAt) + `,` +
 		arg(b.EndedAt) + `,` + arg(b.HeartbeatAt) + `, ` + arg(b.Success) + `, ` + arg(b.Failure) + `, ` + arg(b.Killed) + `, ` +
-		arg(b.Host) + `, ` + arg(b.Purged) + `, ` + arg(b.Queue) + `, ` + arg(b.Priority) + `, ` + arg(b.BuilderConfig) + `)
+		arg(b.Host) + `, ` + arg(b.Purged) + `, ` + arg(b.Queue) + `, ` + arg(b.Priority) + `, ` + arg(b.BuilderConfig) + `, ` + arg(b.CreatorUID) + `)
             RETURNING id;`
 	id, err := appDBH(ctx).SelectInt(sql, args...)
 	if err != nil {
@@ -36,6 +36,7 @@ func init() {
 		`ALTER TABLE repo ALTER COLUMN vcs SET NOT NULL;`,
 		`ALTER TABLE repo ALTER COLUMN updated_at TYPE timestamp with time zone USING updated_at::timestamp with time zone;`,
 		`ALTER TABLE repo ALTER COLUMN pushed_at TYPE timestamp with time zone USING pushed_at::timestamp with time zone;`,
+		`ALTER TABLE repo ALTER COLUMN archived SET DEFAULT false;`,
 		"CREATE INDEX repo_name ON repo(name text_pattern_ops);",
 
 		// fast for repo searching by URI and name
@@ -59,6 +60,7 @@ type dbRepo struct {
 	Deprecated    bool
 	Fork          bool
 	Mirror        bool
+	Archived      bool
 	Private       bool
 	CreatedAt     time.Time  `db:"created_at"`
 	UpdatedAt     *time.Time `db:"updated_at"`
@@ -372,7 +374,7 @@ func (s *repos) Create(ctx context.Context, newRepo *sourcegraph.Repo) error {
 	// live in PostgreSQL.)
 	// A mirrored repo is automatically cloned by the repo updater instead of here.
 	if !newRepo.Mirror && !skipFS {
-		if err := gitserver.Init(newRepo.URI); err != nil && err != vcs.ErrRepoExist {
+		if err := gitserver.Init(newRepo.URI, true); err != nil && err != vcs.ErrRepoExist {
 			return err
 		}
 	}
@@ -436,7 +438,7 @@ func (s *repos) Delete(ctx context.Context, repo string) error {
 		return err
 	}
 	if !skipFS {
-		if err := gitserver.Remove(repo); err != nil {
+		if _, err := gitserver.Remove(repo); err != nil {
 			log15.Warn("Deleting repo on filesystem failed", "repo", repo, "err", err)
 		}
 	}
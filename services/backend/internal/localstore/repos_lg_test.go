@@ -1,3 +1,4 @@
+//go:build exectest && pgsqltest
 // +build exectest,pgsqltest
 
 package localstore_test
@@ -1,29 +1,122 @@
 package localstore
 
 import (
+	"fmt"
 	"strings"
 
 	"gopkg.in/inconshreveable/log15.v2"
 
+	"github.com/rogpeppe/rog-go/parallel"
+
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/rcache"
 	"sourcegraph.com/sourcegraph/sourcegraph/services/ext/github"
 )
 
 const gitHubPublicRepoQuery = `SELECT repo.* FROM repo
-				WHERE ((NOT blocked)) AND ((NOT fork)) AND (NOT private)
-				ORDER BY repo.updated_at desc NULLS LAST
+				WHERE ((NOT blocked)) AND ((NOT fork)) AND (NOT private)%s
+				ORDER BY repo.updated_at desc NULLS LAST, repo.uri desc
 				LIMIT $1 OFFSET $2`
 
+// The keyset queries resume listing from a (updated_at, uri) cursor
+// position instead of using OFFSET, which degrades linearly with page
+// depth and can skip/duplicate rows when repos are updated between
+// pages. The region of repos with a NULL updated_at sorts last and is
+// paged separately, keyed by uri alone.
+const (
+	gitHubPublicRepoKeysetQuery = `SELECT repo.* FROM repo
+				WHERE ((NOT blocked)) AND ((NOT fork)) AND (NOT private)%s
+				AND (repo.updated_at, repo.uri) < ($1, $2)
+				ORDER BY repo.updated_at desc, repo.uri desc
+				LIMIT $3`
+
+	gitHubPublicRepoKeysetNullStartQuery = `SELECT repo.* FROM repo
+				WHERE ((NOT blocked)) AND ((NOT fork)) AND (NOT private)%s
+				AND repo.updated_at IS NULL
+				ORDER BY repo.uri desc
+				LIMIT $1`
+
+	gitHubPublicRepoKeysetNullQuery = `SELECT repo.* FROM repo
+				WHERE ((NOT blocked)) AND ((NOT fork)) AND (NOT private)%s
+				AND repo.updated_at IS NULL AND repo.uri < $1
+				ORDER BY repo.uri desc
+				LIMIT $2`
+)
+
+const gitHubPublicRepoCountQuery = `SELECT COUNT(*) FROM repo
+				WHERE ((NOT blocked)) AND ((NOT fork)) AND (NOT private)%s`
+
+// gitHubPublicRepoConds returns the extra WHERE conditions (starting
+// with " AND ...", or empty) selected by opt for the public repo
+// queries above.
+func gitHubPublicRepoConds(opt *sourcegraph.RepoListOptions) string {
+	var conds string
+	if opt.NoArchived {
+		conds += " AND (NOT archived)"
+	}
+	if opt.NoMirror {
+		conds += " AND (NOT mirror)"
+	}
+	return conds
+}
+
 // GitHubRepoGetter is useful for mocking the GitHub API functionality.
 type GitHubRepoGetter interface {
 	Get(context.Context, string) (*sourcegraph.RemoteRepo, error)
 }
 
+// GitHubBulkRepoGetter is optionally implemented by GitHubRepoGetters
+// that can resolve the metadata of many repos in bulk, keyed by
+// lowercased URI. Repos absent from the result must be resolved with
+// individual Get calls.
+type GitHubBulkRepoGetter interface {
+	GetBulk(context.Context, []string) (map[string]*sourcegraph.RemoteRepo, error)
+}
+
 var repoGetter GitHubRepoGetter = &github.Repos{}
 
+// The sitemap is requested by crawlers much more often than the
+// underlying repo list changes, and each uncached request re-verifies
+// every GitHub repo's visibility against the GitHub API. Cache each
+// page of results so crawler traffic doesn't hammer the GitHub API.
+var (
+	sitemapPublicReposCacheTTL = conf.GetenvIntOrDefault("SG_SITEMAP_PUBLIC_REPOS_CACHE_TTL_SECONDS", 3600)
+	sitemapPublicReposCache    = rcache.New("sitemap_gh_pub")
+
+	// The count changes slowly, so a short TTL keeps it cheap to ask
+	// for repeatedly (once per sitemap index request) while staying
+	// reasonably fresh.
+	sitemapPublicReposCountTTL   = conf.GetenvIntOrDefault("SG_SITEMAP_PUBLIC_REPOS_COUNT_TTL_SECONDS", 600)
+	sitemapPublicReposCountCache = rcache.New("sitemap_gh_pub_count")
+)
+
+// countGitHubPublic returns the total number of repos matched by the
+// listAllGitHubPublic queries, so callers can compute the number of
+// sitemap pages up front instead of paging until an empty page. The
+// count is taken before per-repo GitHub visibility verification, so
+// it is an upper bound.
+func (r *repos) countGitHubPublic(ctx context.Context, opt *sourcegraph.RepoListOptions) (int64, error) {
+	conds := gitHubPublicRepoConds(opt)
+	cacheKey := "count-" + conds
+	var cached int64
+	if err := sitemapPublicReposCountCache.Get(cacheKey, &cached); err == nil {
+		return cached, nil
+	} else if err != rcache.ErrNotFound {
+		log15.Error("sitemap count cache-get error", "err", err)
+	}
+
+	count, err := appDBH(ctx).SelectInt(fmt.Sprintf(gitHubPublicRepoCountQuery, conds))
+	if err != nil {
+		return 0, err
+	}
+	sitemapPublicReposCountCache.Add(cacheKey, count, sitemapPublicReposCountTTL)
+	return count, nil
+}
+
 // listAllPublicGitHubPublic is a special case repos.List specifically for use by the sitemap.
 //
 // KLUDGE: Normally, we would not want to return any repos with a URI that starts with github.com/
@@ -32,37 +125,246 @@ var repoGetter GitHubRepoGetter = &github.Repos{}
 // safe because we explicitly call out to the github API to check for each repo's public/private
 // status, but should be regarded as a hack and a better solution must be sought.
 func (r *repos) listAllGitHubPublic(ctx context.Context, opt *sourcegraph.RepoListOptions) ([]*sourcegraph.Repo, error) {
+	conds := gitHubPublicRepoConds(opt)
+	cacheKey := fmt.Sprintf("%d-%d-%s-%s", opt.PerPageOrDefault(), opt.Offset(), opt.Cursor, conds)
+	var cached []*sourcegraph.Repo
+	if err := sitemapPublicReposCache.Get(cacheKey, &cached); err == nil {
+		return cached, nil
+	} else if err != rcache.ErrNotFound {
+		log15.Error("sitemap cache-get error", "err", err)
+	}
+
 	var dbRepos []*dbRepo
-	_, err := appDBH(ctx).Select(&dbRepos, gitHubPublicRepoQuery, opt.PerPageOrDefault(), opt.Offset())
+	if opt.Cursor != "" {
+		updatedAt, uri, err := sourcegraph.ParseRepoListCursor(opt.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		limit := opt.PerPageOrDefault()
+		if updatedAt != nil {
+			if _, err := appDBH(ctx).Select(&dbRepos, fmt.Sprintf(gitHubPublicRepoKeysetQuery, conds), *updatedAt, uri, limit); err != nil {
+				return nil, err
+			}
+		}
+		if remaining := limit - len(dbRepos); remaining > 0 {
+			// Continue into (or resume within) the NULL updated_at
+			// region, which sorts after all other repos.
+			var nullRepos []*dbRepo
+			var err error
+			if updatedAt == nil {
+				_, err = appDBH(ctx).Select(&nullRepos, fmt.Sprintf(gitHubPublicRepoKeysetNullQuery, conds), uri, remaining)
+			} else {
+				_, err = appDBH(ctx).Select(&nullRepos, fmt.Sprintf(gitHubPublicRepoKeysetNullStartQuery, conds), remaining)
+			}
+			if err != nil {
+				return nil, err
+			}
+			dbRepos = append(dbRepos, nullRepos...)
+		}
+	} else {
+		if _, err := appDBH(ctx).Select(&dbRepos, fmt.Sprintf(gitHubPublicRepoQuery, conds), opt.PerPageOrDefault(), opt.Offset()); err != nil {
+			return nil, err
+		}
+	}
+
+	repos := toRepos(dbRepos)
+
+	publicRepos, err := removePrivateRepos(ctx, repos)
 	if err != nil {
 		return nil, err
 	}
+	sitemapPublicReposCache.Add(cacheKey, publicRepos, sitemapPublicReposCacheTTL)
+	return publicRepos, nil
+}
 
-	repos := toRepos(dbRepos)
+// hostRepoVerifier verifies the public/private visibility of repos
+// against their code host, returning the subset confirmed public in
+// their input order. A repo must only be returned if a record fetched
+// from the host says it is public, since the DB's metadata can be
+// stale.
+type hostRepoVerifier interface {
+	VerifyPublic(ctx context.Context, repos []*sourcegraph.Repo) ([]*sourcegraph.Repo, error)
+}
 
-	return removePrivateGitHubRepos(ctx, repos)
+// hostRepoVerifiers maps a repo URI's host to the verifier for that
+// code host. Hosts without a verifier fall back to trusting the DB's
+// Private flag. To cover another host (e.g. a GitLab instance),
+// implement hostRepoVerifier for it and register it here.
+var hostRepoVerifiers = map[string]hostRepoVerifier{
+	"github.com": githubRepoVerifier{},
+}
+
+// repoURIHost returns the lowercased host component of a repo URI
+// (e.g. "github.com" for "github.com/foo/bar").
+func repoURIHost(uri string) string {
+	if i := strings.Index(uri, "/"); i != -1 {
+		uri = uri[:i]
+	}
+	return strings.ToLower(uri)
 }
 
-func removePrivateGitHubRepos(ctx context.Context, repos []*sourcegraph.Repo) ([]*sourcegraph.Repo, error) {
-	var publicRepos []*sourcegraph.Repo
+// removePrivateRepos filters repos down to those that are safe to
+// list publicly: repos from a host with a registered verifier are
+// kept only if the host confirms they are public, all others are kept
+// if the DB says they are not private.
+func removePrivateRepos(ctx context.Context, repos []*sourcegraph.Repo) ([]*sourcegraph.Repo, error) {
+	verified := map[string][]*sourcegraph.Repo{}
 	for _, repo := range repos {
-		if strings.HasPrefix(strings.ToLower(repo.URI), "github.com/") {
-			r, err := repoGetter.Get(ctx, repo.URI)
+		if host := repoURIHost(repo.URI); hostRepoVerifiers[host] != nil {
+			verified[host] = append(verified[host], repo)
+		}
+	}
+
+	kept := map[*sourcegraph.Repo]bool{}
+	for host, hostRepos := range verified {
+		public, err := hostRepoVerifiers[host].VerifyPublic(ctx, hostRepos)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range public {
+			kept[repo] = true
+		}
+	}
+
+	publicRepos := make([]*sourcegraph.Repo, 0, len(repos))
+	for _, repo := range repos {
+		if hostRepoVerifiers[repoURIHost(repo.URI)] != nil {
+			if kept[repo] {
+				publicRepos = append(publicRepos, repo)
+			}
+		} else if !repo.Private {
+			publicRepos = append(publicRepos, repo)
+		}
+	}
+	return publicRepos, nil
+}
+
+// githubRepoVerifierParallelism bounds the number of concurrent
+// GitHub API calls made while verifying repo visibility.
+const githubRepoVerifierParallelism = 10
+
+// sitemapGitHubOutageMode controls what happens when verifying a
+// repo's visibility fails with an unexpected (likely transient)
+// GitHub error:
+//
+//	cache: fall back to the repo's last verified visibility; repos
+//	       with no cached visibility are skipped (the default).
+//	skip:  skip the repo for this page.
+//	fail:  fail the whole listing.
+//
+// Without a degraded mode, a GitHub outage takes the entire sitemap
+// offline. Skipped repos are logged so they can be reconciled later.
+var sitemapGitHubOutageMode = conf.GetenvOrDefault("SG_SITEMAP_GITHUB_OUTAGE_MODE", "cache")
+
+// sitemapVisibilityCache remembers each repo's last verified
+// visibility, for the "cache" outage mode. Its TTL is long because it
+// is only consulted when GitHub can't be reached.
+var (
+	sitemapVisibilityCacheTTL = conf.GetenvIntOrDefault("SG_SITEMAP_VISIBILITY_CACHE_TTL_SECONDS", 7*24*3600)
+	sitemapVisibilityCache    = rcache.New("sitemap_gh_vis")
+)
+
+// sitemapRepoGetTimeout bounds each per-repo GitHub metadata fetch.
+// Without it, one hung GitHub call stalls its worker — and so the
+// whole sitemap page — indefinitely. A fetch that times out is
+// handled like any other transient GitHub error under
+// sitemapGitHubOutageMode. Zero disables the bound.
+var sitemapRepoGetTimeout = conf.GetenvDurationOrDefault("SG_SITEMAP_GITHUB_TIMEOUT", "5s")
+
+// githubRepoVerifier verifies repo visibility against the GitHub API
+// via repoGetter.
+type githubRepoVerifier struct{}
+
+func (githubRepoVerifier) VerifyPublic(ctx context.Context, repos []*sourcegraph.Repo) ([]*sourcegraph.Repo, error) {
+	// Resolve as many repos as possible in bulk first; the
+	// correctness requirement is unchanged (a repo is only kept if a
+	// record fetched from GitHub says it is public), bulk lookup just
+	// needs far fewer API calls.
+	var bulk map[string]*sourcegraph.RemoteRepo
+	if bulkGetter, ok := repoGetter.(GitHubBulkRepoGetter); ok {
+		uris := make([]string, len(repos))
+		for i, repo := range repos {
+			uris[i] = repo.URI
+		}
+		if len(uris) > 0 {
+			var err error
+			bulk, err = bulkGetter.GetBulk(ctx, uris)
 			if err != nil {
-				if grpc.Code(err) != codes.Unauthenticated &&
-					grpc.Code(err) != codes.PermissionDenied &&
-					grpc.Code(err) != codes.NotFound {
-					// Unexpected error, log it.
-					log15.Error("Fetching GitHub repo failed (indicates that the GitHub repo in our DB has been renamed, deleted, or made private on GitHub)", "error", err)
+				log15.Warn("Bulk GitHub repo fetch failed; falling back to per-repo fetch", "error", err)
+				bulk = nil
+			}
+		}
+	}
+
+	// Each remaining repo requires a network round-trip to verify its
+	// visibility, so fan those checks out across a bounded number of
+	// workers. keep is indexed by the repo's original position so the
+	// input ordering is preserved.
+	keep := make([]*sourcegraph.Repo, len(repos))
+	par := parallel.NewRun(githubRepoVerifierParallelism)
+	for i_, repo_ := range repos {
+		i, repo := i_, repo_
+		if r, ok := bulk[strings.ToLower(repo.URI)]; ok {
+			sitemapVisibilityCache.Add(strings.ToLower(repo.URI), !r.Private, sitemapVisibilityCacheTTL)
+			if !r.Private {
+				keep[i] = repo
+			}
+			continue
+		}
+		par.Do(func() error {
+			getCtx := ctx
+			if sitemapRepoGetTimeout > 0 {
+				var cancel context.CancelFunc
+				getCtx, cancel = context.WithTimeout(ctx, sitemapRepoGetTimeout)
+				defer cancel()
+			}
+			// A timed-out fetch surfaces here as an error and is
+			// handled by the outage-mode switch below, like any other
+			// transient GitHub failure.
+			r, err := repoGetter.Get(getCtx, repo.URI)
+			if err != nil {
+				switch grpc.Code(err) {
+				case codes.Unauthenticated, codes.PermissionDenied, codes.NotFound:
+					// The GitHub repo in our DB has been renamed,
+					// deleted, or made private on GitHub; drop it.
+					return nil
 				}
 
-				continue
+				// Unexpected error — likely a transient GitHub
+				// problem rather than anything about this repo.
+				switch sitemapGitHubOutageMode {
+				case "fail":
+					log15.Error("Fetching GitHub repo failed (indicates that the GitHub repo in our DB has been renamed, deleted, or made private on GitHub)", "error", err)
+					return err
+				case "skip":
+					log15.Warn("Fetching GitHub repo failed; skipping repo for this listing", "repo", repo.URI, "error", err)
+					return nil
+				default: // "cache"
+					var public bool
+					if cacheErr := sitemapVisibilityCache.Get(strings.ToLower(repo.URI), &public); cacheErr == nil && public {
+						log15.Warn("Fetching GitHub repo failed; using last verified visibility", "repo", repo.URI, "error", err)
+						keep[i] = repo
+					} else {
+						log15.Warn("Fetching GitHub repo failed and no cached visibility; skipping repo for this listing", "repo", repo.URI, "error", err)
+					}
+					return nil
+				}
 			}
 
+			sitemapVisibilityCache.Add(strings.ToLower(repo.URI), !r.Private, sitemapVisibilityCacheTTL)
 			if !r.Private {
-				publicRepos = append(publicRepos, repo)
+				keep[i] = repo
 			}
-		} else {
+			return nil
+		})
+	}
+	if err := par.Wait(); err != nil {
+		return nil, err
+	}
+
+	publicRepos := make([]*sourcegraph.Repo, 0, len(repos))
+	for _, repo := range keep {
+		if repo != nil {
 			publicRepos = append(publicRepos, repo)
 		}
 	}
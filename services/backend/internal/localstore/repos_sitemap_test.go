@@ -0,0 +1,136 @@
+package localstore
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+)
+
+func TestRepoURIHost(t *testing.T) {
+	tests := map[string]string{
+		"github.com/foo/bar": "github.com",
+		"GitHub.com/foo/bar": "github.com",
+		"gitlab.com/foo/bar": "gitlab.com",
+		"a/b":                "a",
+		"norepopath":         "norepopath",
+	}
+	for uri, want := range tests {
+		if got := repoURIHost(uri); got != want {
+			t.Errorf("repoURIHost(%q): got %q, want %q", uri, got, want)
+		}
+	}
+}
+
+type repoGetterMock struct {
+	private map[string]bool // URI -> private; absent URIs are public
+}
+
+func (g *repoGetterMock) Get(ctx context.Context, uri string) (*sourcegraph.RemoteRepo, error) {
+	return &sourcegraph.RemoteRepo{Private: g.private[uri]}, nil
+}
+
+func TestRemovePrivateRepos(t *testing.T) {
+	origGetter := repoGetter
+	repoGetter = &repoGetterMock{private: map[string]bool{"github.com/p/rivate": true}}
+	defer func() { repoGetter = origGetter }()
+
+	repos := []*sourcegraph.Repo{
+		{URI: "a/b"},
+		{URI: "github.com/p/ublic"},
+		{URI: "github.com/p/rivate"},
+		// No verifier for this host, so the DB flag is trusted.
+		{URI: "gitlab.example.com/x/y", Private: true},
+		{URI: "gitlab.example.com/x/z"},
+	}
+	got, err := removePrivateRepos(context.Background(), repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a/b", "github.com/p/ublic", "gitlab.example.com/x/z"}
+	var gotURIs []string
+	for _, repo := range got {
+		gotURIs = append(gotURIs, repo.URI)
+	}
+	if !reflect.DeepEqual(gotURIs, want) {
+		t.Errorf("got %v, want %v", gotURIs, want)
+	}
+}
+
+type repoGetterUnavailable struct{}
+
+func (repoGetterUnavailable) Get(ctx context.Context, uri string) (*sourcegraph.RemoteRepo, error) {
+	return nil, grpc.Errorf(codes.Unavailable, "github is down")
+}
+
+func TestRemovePrivateRepos_gitHubOutageSkips(t *testing.T) {
+	origGetter, origMode := repoGetter, sitemapGitHubOutageMode
+	repoGetter = repoGetterUnavailable{}
+	sitemapGitHubOutageMode = "skip"
+	defer func() { repoGetter, sitemapGitHubOutageMode = origGetter, origMode }()
+
+	got, err := removePrivateRepos(context.Background(), []*sourcegraph.Repo{
+		{URI: "a/b"},
+		{URI: "github.com/u/ncertain"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The unverifiable GitHub repo is skipped instead of failing the
+	// page; the non-GitHub repo is unaffected.
+	if len(got) != 1 || got[0].URI != "a/b" {
+		t.Errorf("got %v, want just a/b", got)
+	}
+}
+
+// repoGetterHung blocks until its context is canceled, like a GitHub
+// call that never returns.
+type repoGetterHung struct{}
+
+func (repoGetterHung) Get(ctx context.Context, uri string) (*sourcegraph.RemoteRepo, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRemovePrivateRepos_gitHubTimeout(t *testing.T) {
+	origGetter, origMode, origTimeout := repoGetter, sitemapGitHubOutageMode, sitemapRepoGetTimeout
+	repoGetter = repoGetterHung{}
+	sitemapGitHubOutageMode = "skip"
+	sitemapRepoGetTimeout = 10 * time.Millisecond
+	defer func() {
+		repoGetter, sitemapGitHubOutageMode, sitemapRepoGetTimeout = origGetter, origMode, origTimeout
+	}()
+
+	start := time.Now()
+	got, err := removePrivateRepos(context.Background(), []*sourcegraph.Repo{
+		{URI: "a/b"},
+		{URI: "github.com/h/ung"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The hung fetch times out and is treated as a transient error
+	// (skipped here) instead of stalling the listing.
+	if len(got) != 1 || got[0].URI != "a/b" {
+		t.Errorf("got %v, want just a/b", got)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("listing took %s; the per-repo timeout did not apply", elapsed)
+	}
+}
+
+func TestRemovePrivateRepos_gitHubOutageFails(t *testing.T) {
+	origGetter, origMode := repoGetter, sitemapGitHubOutageMode
+	repoGetter = repoGetterUnavailable{}
+	sitemapGitHubOutageMode = "fail"
+	defer func() { repoGetter, sitemapGitHubOutageMode = origGetter, origMode }()
+
+	if _, err := removePrivateRepos(context.Background(), []*sourcegraph.Repo{{URI: "github.com/u/ncertain"}}); err == nil {
+		t.Error("expected error in fail mode")
+	}
+}
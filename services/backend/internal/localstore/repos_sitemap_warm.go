@@ -0,0 +1,120 @@
+package localstore
+
+import (
+	"time"
+
+	"gopkg.in/inconshreveable/log15.v2"
+
+	"golang.org/x/net/context"
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/services/backend/serverctx"
+)
+
+// Warming configuration for the sitemap repo cache. After a restart
+// the cache is empty and the first sitemap request re-verifies every
+// GitHub repo against the GitHub API, which is extremely slow. The
+// warmer pre-populates the cache for the most-recently-updated repos
+// at startup and refreshes it periodically so crawler requests hit
+// warm data.
+var (
+	// sitemapWarmRepos is how many repos to warm; 0 disables the
+	// warmer.
+	sitemapWarmRepos = conf.GetenvIntOrDefault("SG_SITEMAP_WARM_REPOS", 0)
+
+	// sitemapWarmInterval is how often the warmer refreshes. It should
+	// be shorter than the cache TTL so entries are refreshed before
+	// they expire.
+	sitemapWarmInterval = conf.GetenvDurationOrDefault("SG_SITEMAP_WARM_INTERVAL", "45m")
+
+	// sitemapWarmPerPage must match the page size the sitemap handler
+	// requests, since the cache is keyed by page size.
+	sitemapWarmPerPage = conf.GetenvIntOrDefault("SG_SITEMAP_WARM_PER_PAGE", 10000)
+
+	// sitemapWarmPagePause spaces out page fetches so warming doesn't
+	// burn through the GitHub API rate limit in one burst; the
+	// per-repo verification inside each page is already bounded by
+	// githubRepoVerifierParallelism.
+	sitemapWarmPagePause = conf.GetenvDurationOrDefault("SG_SITEMAP_WARM_PAGE_PAUSE", "10s")
+)
+
+// StartSitemapCacheWarmer starts the background sitemap cache warmer
+// if SG_SITEMAP_WARM_REPOS is set to a positive number. It returns
+// immediately; warming happens in a goroutine that stops when ctx is
+// done.
+func StartSitemapCacheWarmer(ctx context.Context) {
+	if sitemapWarmRepos <= 0 {
+		return
+	}
+	go func() {
+		wctx, err := warmContext(ctx)
+		if err != nil {
+			log15.Warn("sitemap cache warmer: context setup failed", "err", err)
+			return
+		}
+		for {
+			warmSitemapCache(wctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(sitemapWarmInterval):
+			}
+		}
+	}()
+}
+
+// warmContext applies the server context initializers to ctx so it
+// carries the DB handles and GitHub client that listAllGitHubPublic
+// needs, the same way a gRPC request's context does.
+func warmContext(ctx context.Context) (context.Context, error) {
+	var err error
+	for _, f := range serverctx.Funcs {
+		if ctx, err = f(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range serverctx.LastFuncs {
+		if ctx, err = f(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return ctx, nil
+}
+
+// warmSitemapCache pages through the most-recently-updated public
+// repos the same way the sitemap handler does, so the cache entries it
+// populates are the ones sitemap requests will hit.
+func warmSitemapCache(ctx context.Context) {
+	start := time.Now()
+	s := &repos{}
+	opt := &sourcegraph.RepoListOptions{
+		SlowlyIncludePublicGitHubRepos: true,
+		ListOptions:                    sourcegraph.ListOptions{Page: 1, PerPage: int32(sitemapWarmPerPage)},
+	}
+
+	target := sitemapWarmRepos
+	if total, err := s.countGitHubPublic(ctx, opt); err == nil && total < int64(target) {
+		target = int(total)
+	}
+
+	warmed := 0
+	for warmed < target {
+		repos, err := s.listAllGitHubPublic(ctx, opt)
+		if err != nil {
+			log15.Warn("sitemap cache warmer: listing repos failed", "err", err, "warmed", warmed)
+			return
+		}
+		warmed += len(repos)
+		if len(repos) < sitemapWarmPerPage {
+			break
+		}
+		opt.Cursor = sourcegraph.RepoListCursor(repos[len(repos)-1])
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sitemapWarmPagePause):
+		}
+	}
+	log15.Info("sitemap cache warmer: done", "repos", warmed, "duration", time.Since(start))
+}
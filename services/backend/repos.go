@@ -101,7 +101,15 @@ func (s *repos) List(ctx context.Context, opt *sourcegraph.RepoListOptions) (*so
 	if err := par.Wait(); err != nil {
 		return nil, err
 	}
-	return &sourcegraph.RepoList{Repos: repos}, nil
+
+	list := &sourcegraph.RepoList{Repos: repos}
+	if opt != nil && opt.SlowlyIncludePublicGitHubRepos && len(repos) > 0 {
+		// Hand back a keyset cursor so the sitemap crawl can resume
+		// where this page left off instead of using an increasingly
+		// deep (and slow) OFFSET.
+		list.Cursor = sourcegraph.RepoListCursor(repos[len(repos)-1])
+	}
+	return list, nil
 }
 
 func (s *repos) setRepoFieldsFromRemote(ctx context.Context, repo *sourcegraph.Repo) error {
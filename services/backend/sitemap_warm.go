@@ -0,0 +1,15 @@
+package backend
+
+import (
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/services/backend/internal/localstore"
+)
+
+// StartSitemapCacheWarmer starts the background warmer for the
+// sitemap repo cache, if enabled via SG_SITEMAP_WARM_REPOS. It exists
+// so callers outside this package (e.g. the serve command) can start
+// the warmer, which lives in the internal localstore package.
+func StartSitemapCacheWarmer(ctx context.Context) {
+	localstore.StartSitemapCacheWarmer(ctx)
+}
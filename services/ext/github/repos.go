@@ -2,6 +2,7 @@ package github
 
 import (
 	"fmt"
+	"strings"
 
 	"gopkg.in/inconshreveable/log15.v2"
 
@@ -69,6 +70,55 @@ func (s *Repos) Get(ctx context.Context, repo string) (*sourcegraph.RemoteRepo,
 	return remoteRepo, nil
 }
 
+// GetBulk resolves the metadata of several repos (given as
+// "github.com/owner/repo" URIs) in as few GitHub API calls as
+// possible, by listing each owner's repos instead of fetching one
+// repo at a time. The returned map is keyed by lowercased URI. Repos
+// that could not be resolved in bulk (e.g., the owner has too many
+// repos for this to be a win, or the URI is malformed) are simply
+// absent from the map; callers that need them must fall back to Get.
+func (s *Repos) GetBulk(ctx context.Context, repoURIs []string) (map[string]*sourcegraph.RemoteRepo, error) {
+	// Group the requested repos by owner. One list call resolves up
+	// to 100 of an owner's repos at once.
+	byOwner := map[string][]string{}
+	for _, uri := range repoURIs {
+		owner, _, err := githubutil.SplitRepoURI(uri)
+		if err != nil {
+			continue
+		}
+		byOwner[strings.ToLower(owner)] = append(byOwner[strings.ToLower(owner)], uri)
+	}
+
+	remoteRepos := map[string]*sourcegraph.RemoteRepo{}
+	for owner, uris := range byOwner {
+		// maxPages bounds how deep we page through an owner's repos;
+		// beyond that, the per-repo fallback is cheaper.
+		maxPages := len(uris)/100 + 2
+
+		opt := &github.RepositoryListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+		for page := 0; page < maxPages; page++ {
+			ghrepos, resp, err := client(ctx).repos.List(owner, opt)
+			if err != nil {
+				return nil, checkResponse(ctx, resp, err, fmt.Sprintf("github.Repos.List %q", owner))
+			}
+			for i := range ghrepos {
+				ghrepo := &ghrepos[i]
+				if ghrepo.Owner == nil || ghrepo.Name == nil {
+					continue
+				}
+				uri := strings.ToLower("github.com/" + *ghrepo.Owner.Login + "/" + *ghrepo.Name)
+				remoteRepos[uri] = toRemoteRepo(ghrepo)
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+
+	return remoteRepos, nil
+}
+
 func (s *Repos) GetByID(ctx context.Context, id int) (*sourcegraph.RemoteRepo, error) {
 	ghrepo, resp, err := client(ctx).repos.GetByID(id)
 	if err != nil {
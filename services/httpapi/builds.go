@@ -1,15 +1,24 @@
 package httpapi
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
 	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+	apirouter "sourcegraph.com/sourcegraph/sourcegraph/services/httpapi/router"
 )
 
 func serveBuildTasks(w http.ResponseWriter, r *http.Request) error {
@@ -33,9 +42,67 @@ func serveBuildTasks(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// The flat BuildTaskList remains the default response; full=true
+	// returns the tasks as a tree with each task's computed status, so
+	// a build page can render nested tasks without reimplementing the
+	// status logic.
+	if r.URL.Query().Get("full") == "true" {
+		return writeJSON(w, buildTaskTree(tasks.BuildTasks))
+	}
 	return writeJSON(w, tasks)
 }
 
+// buildTaskNode is one node of the full=true response of the build
+// tasks endpoint: a task with its computed status and subtasks.
+type buildTaskNode struct {
+	Task     *sourcegraph.BuildTask
+	Status   string
+	Children []*buildTaskNode `json:",omitempty"`
+}
+
+// buildTaskTree arranges tasks into a tree using their ParentID
+// links, preserving the input order among siblings. A task whose
+// parent is not in the list (e.g. filtered out by pagination) is
+// treated as top-level rather than dropped.
+func buildTaskTree(tasks []*sourcegraph.BuildTask) []*buildTaskNode {
+	nodes := make(map[uint64]*buildTaskNode, len(tasks))
+	for _, task := range tasks {
+		nodes[task.ID] = &buildTaskNode{Task: task, Status: buildTaskStatus(task)}
+	}
+
+	var roots []*buildTaskNode
+	for _, task := range tasks {
+		node := nodes[task.ID]
+		if parent, ok := nodes[task.ParentID]; ok && task.ParentID != task.ID {
+			parent.Children = append(parent.Children, node)
+			continue
+		}
+		roots = append(roots, node)
+	}
+	return roots
+}
+
+// Page sizes for the builds list endpoints. The default applies when
+// the request doesn't specify PerPage; the max caps what a request
+// may ask for, so nobody can request (say) 100000 builds in one page
+// and exhaust the server. Both are configurable without recompiling.
+var (
+	buildListDefaultPerPage = int32(conf.GetenvIntOrDefault("SG_BUILD_LIST_DEFAULT_PER_PAGE", sourcegraph.DefaultPerPage))
+	buildListMaxPerPage     = int32(conf.GetenvIntOrDefault("SG_BUILD_LIST_MAX_PER_PAGE", 1000))
+)
+
+// clampBuildListPageSize applies the configured default and maximum
+// page size to opt. Every handler that lists builds goes through it
+// so the limits hold uniformly.
+func clampBuildListPageSize(opt *sourcegraph.ListOptions) {
+	if opt.PerPage <= 0 {
+		opt.PerPage = buildListDefaultPerPage
+	}
+	if opt.PerPage > buildListMaxPerPage {
+		opt.PerPage = buildListMaxPerPage
+	}
+}
+
 func serveBuilds(w http.ResponseWriter, r *http.Request) error {
 	ctx, cl := handlerutil.Client(r)
 
@@ -44,6 +111,7 @@ func serveBuilds(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	clampBuildListPageSize(&opt.ListOptions)
 
 	builds, err := cl.Builds.List(ctx, &opt)
 	if err != nil {
@@ -54,6 +122,7 @@ func serveBuilds(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	writePaginationHeaderStream(w, r.URL, opt.ListOptions, builds.HasMore)
 	return writeJSON(w, builds)
 }
 
@@ -70,12 +139,194 @@ func serveBuildTaskLog(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// Validate the build — including that it actually belongs to the
+	// repo in the URL — before fetching anything on its behalf.
+	build, err := checkBuildInRepo(ctx, cl, &taskSpec.Build)
+	if err != nil {
+		return err
+	}
+
+	// share=true returns a signed, expiring URL for this log instead
+	// of the log itself, for handing to someone without a session. It
+	// is generated here (rather than on the shared endpoint) so only
+	// someone already authorized to read the log can mint a link.
+	if r.URL.Query().Get("share") == "true" {
+		u, expiresAt, err := sharedBuildTaskLogURL(taskSpec)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, &sharedLogInfo{URL: u.String(), ExpiresAt: expiresAt})
+	}
+
 	entries, err := cl.Builds.GetTaskLog(ctx, &sourcegraph.BuildsGetTaskLogOp{Task: taskSpec, Opt: &opt})
 	if err != nil {
 		return err
 	}
 
-	return writePlainLogEntries(w, entries)
+	// Logs of a task in a finished build never change, so let clients
+	// revalidate them with an ETag instead of re-downloading. Logs of
+	// in-progress builds must stay uncacheable.
+	if build.EndedAt != nil {
+		etag := fmt.Sprintf(`"task-%d-%s"`, taskSpec.ID, entries.MaxID)
+		w.Header().Set("etag", etag)
+		w.Header().Set("cache-control", "private, max-age=3600")
+		if etagMatch(r.Header.Get("if-none-match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	} else {
+		w.Header().Set("cache-control", "no-cache, max-age=0")
+	}
+
+	// Filter server-side if a search query was given, so multi-megabyte
+	// logs needn't be shipped to the browser just to find one error
+	// line. MaxID is unaffected, so follow-mode can keep searching
+	// incrementally.
+	if q := r.URL.Query().Get("q"); q != "" {
+		entries.Entries = filterLogEntries(entries.Entries, q)
+	}
+
+	// tail=N returns only the last N lines, computed here so a quick
+	// "why did it fail" check doesn't ship the whole log. MaxID is
+	// unaffected, so a client can still follow from the cursor.
+	tail, err := tailOption(r)
+	if err != nil {
+		return err
+	}
+	entries.Entries = tailLogEntries(entries.Entries, tail)
+
+	if r.URL.Query().Get("download") == "true" {
+		filename := fmt.Sprintf("build-%d-task-%d.log", taskSpec.Build.ID, taskSpec.ID)
+		w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+
+	return writePlainLogEntries(w, r, entries)
+}
+
+// serveBuildLog returns the combined logs of every task in a build,
+// in task order, each section preceded by a task header line. It
+// supports the same options as the per-task log endpoint; the MaxID
+// cursor spans all tasks (it encodes one cursor per task), so
+// follow-mode works the same way as for a single task.
+func serveBuildLog(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	var opt sourcegraph.BuildGetLogOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return err
+	}
+
+	buildSpec, err := getBuildSpec(r)
+	if err != nil {
+		return err
+	}
+	if _, err := checkBuildInRepo(ctx, cl, buildSpec); err != nil {
+		return err
+	}
+
+	tasks, err := cl.Builds.ListBuildTasks(ctx, &sourcegraph.BuildsListBuildTasksOp{Build: *buildSpec})
+	if err != nil {
+		return err
+	}
+
+	cursors := parseBuildLogCursor(opt.MinID)
+	next := map[uint64]string{}
+	q := r.URL.Query().Get("q")
+
+	combined := &sourcegraph.LogEntries{}
+	for _, task := range tasks.BuildTasks {
+		taskOpt := sourcegraph.BuildGetLogOptions{MinID: cursors[task.ID]}
+		entries, err := cl.Builds.GetTaskLog(ctx, &sourcegraph.BuildsGetTaskLogOp{
+			Task: sourcegraph.TaskSpec{Build: *buildSpec, ID: task.ID},
+			Opt:  &taskOpt,
+		})
+		if err != nil {
+			return err
+		}
+		if entries.MaxID != "" {
+			next[task.ID] = entries.MaxID
+		} else if c := cursors[task.ID]; c != "" {
+			// No new entries; carry the previous cursor forward.
+			next[task.ID] = c
+		}
+		lines := entries.Entries
+		if q != "" {
+			lines = filterLogEntries(lines, q)
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		combined.Entries = append(combined.Entries, fmt.Sprintf("==> task %d: %s <==", task.ID, task.Label))
+		combined.Entries = append(combined.Entries, lines...)
+	}
+	combined.MaxID = formatBuildLogCursor(next)
+
+	// As for the per-task log, tail=N keeps only the last N lines
+	// (task header lines included) without touching the cursor.
+	tail, err := tailOption(r)
+	if err != nil {
+		return err
+	}
+	combined.Entries = tailLogEntries(combined.Entries, tail)
+
+	if r.URL.Query().Get("download") == "true" {
+		filename := fmt.Sprintf("build-%d.log", buildSpec.ID)
+		w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+
+	return writePlainLogEntries(w, r, combined)
+}
+
+// parseBuildLogCursor parses a combined build log cursor of the form
+// "taskID:maxID,taskID:maxID". Malformed segments are ignored rather
+// than erroring, which at worst re-sends some log lines.
+func parseBuildLogCursor(s string) map[uint64]string {
+	cursors := map[uint64]string{}
+	if s == "" {
+		return cursors
+	}
+	for _, seg := range strings.Split(s, ",") {
+		kv := strings.SplitN(seg, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		taskID, err := strconv.ParseUint(kv[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		cursors[taskID] = kv[1]
+	}
+	return cursors
+}
+
+func formatBuildLogCursor(cursors map[uint64]string) string {
+	taskIDs := make([]uint64, 0, len(cursors))
+	for taskID := range cursors {
+		taskIDs = append(taskIDs, taskID)
+	}
+	sort.Sort(uint64Slice(taskIDs))
+	segs := make([]string, len(taskIDs))
+	for i, taskID := range taskIDs {
+		segs[i] = fmt.Sprintf("%d:%s", taskID, cursors[taskID])
+	}
+	return strings.Join(segs, ",")
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// etagMatch reports whether the If-None-Match header value matches
+// etag.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
 }
 
 func getRepoSpec(r *http.Request) (*sourcegraph.RepoSpec, error) {
@@ -118,12 +369,117 @@ func getBuildTaskSpec(r *http.Request) (sourcegraph.TaskSpec, error) {
 	return sourcegraph.TaskSpec{Build: *buildSpec, ID: taskID}, nil
 }
 
-func writePlainLogEntries(w http.ResponseWriter, entries *sourcegraph.LogEntries) error {
+// getBuild fetches spec's build via Builds.Get, mapping lookup
+// failures to distinct, friendly responses instead of the generic
+// error path: a missing build becomes a 404 that names the build and
+// points at the repo's builds list (so it can't be confused with a
+// missing repo or a permission problem), and permission errors become
+// a 401/403 that names the repo. Build handlers should fetch their
+// build through this so the responses stay consistent across
+// endpoints.
+func getBuild(ctx context.Context, cl *sourcegraph.Client, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+	build, err := cl.Builds.Get(ctx, spec)
+	if err != nil {
+		return nil, buildLookupError(spec, err)
+	}
+	return build, nil
+}
+
+// checkBuildInRepo fetches spec's build via getBuild and additionally
+// verifies that the build belongs to the repo named in the URL. The
+// repo and build URL vars arrive independently, so a crafted request
+// could otherwise pair a build with a repo it isn't part of and have
+// the resource fetched diverge from the one the lookup (and its
+// access checks) ran against; a mismatch gets the same 404 as a
+// missing build.
+func checkBuildInRepo(ctx context.Context, cl *sourcegraph.Client, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+	build, err := getBuild(ctx, cl, spec)
+	if err != nil {
+		return nil, err
+	}
+	if build.Repo != spec.Repo.URI {
+		return nil, &errcode.HTTPErr{Status: http.StatusNotFound, Err: fmt.Errorf("no build #%d for repository %q", spec.ID, spec.Repo.URI)}
+	}
+	return build, nil
+}
+
+func buildLookupError(spec *sourcegraph.BuildSpec, err error) error {
+	switch status := errcode.HTTP(err); status {
+	case http.StatusNotFound:
+		msg := fmt.Sprintf("no build #%d for repository %q", spec.ID, spec.Repo.URI)
+		if u, uerr := apirouter.URL(apirouter.RepoBuilds, map[string]string{"Repo": spec.Repo.URI}); uerr == nil {
+			msg += fmt.Sprintf("; its builds are listed at %s", u)
+		}
+		return &errcode.HTTPErr{Status: http.StatusNotFound, Err: errors.New(msg)}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &errcode.HTTPErr{Status: status, Err: fmt.Errorf("not authorized to view builds of repository %q", spec.Repo.URI)}
+	}
+	return err
+}
+
+// logSearchContextLines is how many lines of surrounding context are
+// returned with each line matched by a log search.
+const logSearchContextLines = 2
+
+// filterLogEntries returns the lines of entries that contain q as a
+// substring, each with up to logSearchContextLines lines of
+// surrounding context. Overlapping context regions are merged and
+// lines are returned at most once, in their original order.
+func filterLogEntries(entries []string, q string) []string {
+	include := make([]bool, len(entries))
+	for i, e := range entries {
+		if !strings.Contains(e, q) {
+			continue
+		}
+		for j := i - logSearchContextLines; j <= i+logSearchContextLines; j++ {
+			if j >= 0 && j < len(entries) {
+				include[j] = true
+			}
+		}
+	}
+	var matched []string
+	for i, ok := range include {
+		if ok {
+			matched = append(matched, entries[i])
+		}
+	}
+	return matched
+}
+
+// tailOption parses the tail=N query parameter. 0 (the default) means
+// no tailing.
+func tailOption(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("tail")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, &errcode.HTTPErr{Status: http.StatusBadRequest, Err: fmt.Errorf("invalid tail %q", s)}
+	}
+	return n, nil
+}
+
+// tailLogEntries returns the last n entries; n <= 0 means all of them.
+func tailLogEntries(entries []string, n int) []string {
+	if n <= 0 || len(entries) <= n {
+		return entries
+	}
+	return entries[len(entries)-n:]
+}
+
+func writePlainLogEntries(w http.ResponseWriter, r *http.Request, entries *sourcegraph.LogEntries) error {
 	w.Header().Add("content-type", "text/plain; charset=utf-8")
 	if entries.MaxID != "" {
 		w.Header().Add("x-sourcegraph-log-max-id", entries.MaxID)
 	}
 
+	// Flushing after each entry makes follow-mode output appear
+	// immediately instead of sitting in the response buffer.
+	flusher, _ := w.(http.Flusher)
+
+	// Compression is handled by the gzipHandler wrapping the log
+	// routes, so entries are written uncompressed here.
 	printFunc := fmt.Fprintln
 	for i, e := range entries.Entries {
 		// Don't print an artificial trailing newline.
@@ -132,8 +488,33 @@ func writePlainLogEntries(w http.ResponseWriter, entries *sourcegraph.LogEntries
 		}
 
 		if _, err := printFunc(w, e); err != nil {
+			// A client that navigated away mid-download is normal
+			// operation, not a handler failure; stop quietly instead
+			// of producing a 500 and an error log.
+			if isClientDisconnect(err) {
+				return nil
+			}
 			return err
 		}
+		if flusher != nil {
+			flusher.Flush()
+		}
 	}
 	return nil
 }
+
+// isClientDisconnect reports whether a response write error just
+// means the client went away (closed the tab, navigated off) rather
+// than something being wrong server-side.
+func isClientDisconnect(err error) bool {
+	if err == context.Canceled {
+		return true
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EPIPE || err == syscall.ECONNRESET
+}
@@ -0,0 +1,83 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// adminBuild is one row of the cross-repo builds view: the build
+// itself (whose Host field names the worker that handled it, when
+// set), joined with its repo. The commit ID is on the build.
+type adminBuild struct {
+	Build *sourcegraph.Build
+	Repo  *sourcegraph.Repo `json:",omitempty"`
+}
+
+// serveBuildsAdmin lists recent builds across all repos, for
+// operators diagnosing build-system-wide problems.
+func serveBuildsAdmin(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	if !auth.ActorFromContext(ctx).HasAdminAccess() {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("admin access required")}
+	}
+
+	var opt sourcegraph.BuildListOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return err
+	}
+	opt.Repo = "" // this view is deliberately cross-repo
+	clampBuildListPageSize(&opt.ListOptions)
+
+	// Friendlier status= alias for the BuildListOptions booleans.
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case "queued":
+		opt.Queued = true
+	case "active":
+		opt.Active = true
+	case "ended":
+		opt.Ended = true
+	case "succeeded":
+		opt.Succeeded = true
+	case "failed":
+		opt.Failed = true
+	default:
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("invalid status: " + status)}
+	}
+
+	// Most recent first unless the caller asked for something else.
+	if opt.Sort == "" {
+		opt.Sort = "created_at"
+		opt.Direction = "desc"
+	}
+
+	builds, err := cl.Builds.List(ctx, &opt)
+	if err != nil {
+		return err
+	}
+
+	// Join each build to its repo, fetching each distinct repo once
+	// per page.
+	repos := map[string]*sourcegraph.Repo{}
+	rows := make([]*adminBuild, len(builds.Builds))
+	for i, b := range builds.Builds {
+		repo, ok := repos[b.Repo]
+		if !ok {
+			// A repo that can't be fetched (e.g. deleted since the
+			// build ran) shouldn't break the whole view; its builds
+			// are listed without repo details.
+			repo, _ = cl.Repos.Get(ctx, &sourcegraph.RepoSpec{URI: b.Repo})
+			repos[b.Repo] = repo
+		}
+		rows[i] = &adminBuild{Build: b, Repo: repo}
+	}
+
+	writePaginationHeaderStream(w, r.URL, opt.ListOptions, builds.HasMore)
+	return writeJSON(w, rows)
+}
@@ -0,0 +1,78 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+)
+
+func TestBuildsAdmin(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	var calledList bool
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		calledList = true
+		if opt.Repo != "" {
+			t.Errorf("got Repo filter %q, want none", opt.Repo)
+		}
+		if !opt.Failed {
+			t.Error("expected status=failed to set opt.Failed")
+		}
+		if opt.Sort != "created_at" || opt.Direction != "desc" {
+			t.Errorf("got sort %q %q, want created_at desc", opt.Sort, opt.Direction)
+		}
+		return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+			{ID: 1, Repo: "r/a", CommitID: "a", Host: "worker-1"},
+			{ID: 2, Repo: "r/a", CommitID: "b", Host: "worker-2"},
+			{ID: 3, Repo: "r/b", CommitID: "c"},
+		}}, nil
+	}
+	var repoGets int
+	mock.Repos.Get_ = func(ctx context.Context, repo *sourcegraph.RepoSpec) (*sourcegraph.Repo, error) {
+		repoGets++
+		return &sourcegraph.Repo{URI: repo.URI}, nil
+	}
+
+	var rows []*adminBuild
+	if err := c.GetJSON("/builds/admin?status=failed", &rows); err != nil {
+		t.Fatal(err)
+	}
+	if !calledList {
+		t.Error("!calledList")
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	if rows[0].Repo == nil || rows[0].Repo.URI != "r/a" {
+		t.Errorf("got row repo %+v, want r/a", rows[0].Repo)
+	}
+	if rows[0].Build.Host != "worker-1" {
+		t.Errorf("got worker %q, want worker-1", rows[0].Build.Host)
+	}
+	if repoGets != 2 {
+		t.Errorf("got %d repo fetches, want 2 (one per distinct repo)", repoGets)
+	}
+}
+
+func TestBuildsAdmin_requiresAdmin(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1})
+
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		t.Error("Builds.List called for non-admin")
+		return &sourcegraph.BuildList{}, nil
+	}
+
+	resp, err := c.Get("/builds/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,133 @@
+package httpapi
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// buildArtifactsDir is the root directory where build workers deposit
+// artifacts, laid out as <dir>/<repo URI>/<build ID>/<name>. If empty,
+// the artifact endpoints report no artifacts.
+var buildArtifactsDir = os.Getenv("SG_BUILD_ARTIFACTS_DIR")
+
+type buildArtifact struct {
+	Name        string
+	Size        int64
+	ContentType string
+}
+
+func buildArtifactsPath(spec *sourcegraph.BuildSpec) string {
+	return filepath.Join(buildArtifactsDir, filepath.FromSlash(spec.Repo.URI), strconv.FormatUint(spec.ID, 10))
+}
+
+func artifactContentType(name string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// detectArtifactContentType is like artifactContentType, but when the
+// extension is unknown it sniffs the first bytes of content
+// (http.DetectContentType), so an extensionless text report renders
+// as text and a binary isn't corrupted by being served with a text
+// type. content is rewound after sniffing.
+func detectArtifactContentType(name string, content io.ReadSeeker) string {
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		return ct
+	}
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(content, buf)
+	if _, err := content.Seek(0, 0); err != nil || n == 0 {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+func serveBuildArtifacts(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	buildSpec, err := getBuildSpec(r)
+	if err != nil {
+		return err
+	}
+
+	// Fetch the build first so the Builds service enforces access
+	// control and existence.
+	if _, err := getBuild(ctx, cl, buildSpec); err != nil {
+		return err
+	}
+
+	artifacts := []*buildArtifact{}
+	if buildArtifactsDir != "" {
+		fis, err := ioutil.ReadDir(buildArtifactsPath(buildSpec))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, fi := range fis {
+			if fi.IsDir() {
+				continue
+			}
+			artifacts = append(artifacts, &buildArtifact{
+				Name:        fi.Name(),
+				Size:        fi.Size(),
+				ContentType: artifactContentType(fi.Name()),
+			})
+		}
+	}
+	return writeJSON(w, artifacts)
+}
+
+func serveBuildArtifactDownload(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	buildSpec, err := getBuildSpec(r)
+	if err != nil {
+		return err
+	}
+
+	name := mux.Vars(r)["Artifact"]
+	if name == "" || name != filepath.Base(name) || name[0] == '.' {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest}
+	}
+
+	if _, err := getBuild(ctx, cl, buildSpec); err != nil {
+		return err
+	}
+
+	if buildArtifactsDir == "" {
+		return &errcode.HTTPErr{Status: http.StatusNotFound}
+	}
+	f, err := os.Open(filepath.Join(buildArtifactsPath(buildSpec), name))
+	if os.IsNotExist(err) {
+		return &errcode.HTTPErr{Status: http.StatusNotFound}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &errcode.HTTPErr{Status: http.StatusNotFound}
+	}
+
+	w.Header().Set("content-type", detectArtifactContentType(name, f))
+	w.Header().Set("content-length", strconv.FormatInt(fi.Size(), 10))
+	w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", name))
+	// Stream the file; artifacts (e.g. compiled binaries) can be large.
+	_, err = io.Copy(w, f)
+	return err
+}
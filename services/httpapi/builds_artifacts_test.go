@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+)
+
+func TestBuildArtifacts(t *testing.T) {
+	c, mock := newTest()
+
+	tmp, err := ioutil.TempDir("", "artifacts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	origDir := buildArtifactsDir
+	buildArtifactsDir = tmp
+	defer func() { buildArtifactsDir = origDir }()
+
+	dir := filepath.Join(tmp, "r", "r", "123")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.tar.gz"), []byte("binary contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var calledGet bool
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		calledGet = true
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	var artifacts []*buildArtifact
+	if err := c.GetJSON("/repos/r/r/-/builds/123/artifacts", &artifacts); err != nil {
+		t.Fatal(err)
+	}
+	if !calledGet {
+		t.Error("!calledGet")
+	}
+	if len(artifacts) != 1 {
+		t.Fatalf("got %d artifacts, want 1", len(artifacts))
+	}
+	if artifacts[0].Name != "app.tar.gz" || artifacts[0].Size != int64(len("binary contents")) {
+		t.Errorf("got artifact %+v", artifacts[0])
+	}
+
+	resp, err := c.GetOK("/repos/r/r/-/builds/123/artifacts/app.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "binary contents" {
+		t.Errorf("got body %q", body)
+	}
+	if want := `attachment; filename="app.tar.gz"`; resp.Header.Get("content-disposition") != want {
+		t.Errorf("got content-disposition %q, want %q", resp.Header.Get("content-disposition"), want)
+	}
+
+	if resp, _ := c.Get("/repos/r/r/-/builds/123/artifacts/missing"); resp.StatusCode != 404 {
+		t.Errorf("got status %d for missing artifact, want 404", resp.StatusCode)
+	}
+}
+
+func TestBuildArtifactDownload_sniffedContentType(t *testing.T) {
+	c, mock := newTest()
+
+	tmp, err := ioutil.TempDir("", "artifacts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	origDir := buildArtifactsDir
+	buildArtifactsDir = tmp
+	defer func() { buildArtifactsDir = origDir }()
+
+	dir := filepath.Join(tmp, "r", "r", "123")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// Extensionless files get their type from content sniffing: one
+	// plain-text report and one binary blob.
+	if err := ioutil.WriteFile(filepath.Join(dir, "report"), []byte("all tests passed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "blob"), []byte{0x7f, 'E', 'L', 'F', 0x02, 0x01, 0x00, 0x00}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	tests := map[string]string{
+		"report": "text/plain; charset=utf-8",
+		"blob":   "application/octet-stream",
+	}
+	for name, wantType := range tests {
+		resp, err := c.GetOK("/repos/r/r/-/builds/123/artifacts/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("content-type"); got != wantType {
+			t.Errorf("%s: got content-type %q, want %q", name, got, wantType)
+		}
+	}
+}
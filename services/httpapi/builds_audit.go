@@ -0,0 +1,101 @@
+package httpapi
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+
+	"golang.org/x/net/context"
+)
+
+// Build audit trail: every handler that creates, restarts, or purges
+// a build records who did it and when, and the trail for a build is
+// readable at /builds/{Build}/audit. Entries are append-only and
+// returned as copies, so a recorded event can never be altered. The
+// trail is held in this process's memory (the Builds service has no
+// audit storage), so it covers the current server's lifetime; moving
+// it into the Builds service would be the durable follow-up.
+
+// buildAuditEvent is one immutable, timestamped entry in a build's
+// audit trail.
+type buildAuditEvent struct {
+	// Time is when the action happened.
+	Time time.Time
+	// Action is what happened: "created", "restarted", "requeued",
+	// "cancelled", or "purged".
+	Action string
+	// ActorUID and ActorLogin identify the authenticated actor who
+	// performed the action. Login may be empty when the request path
+	// didn't populate it (e.g. internal callers like the build hook).
+	ActorUID   int    `json:",omitempty"`
+	ActorLogin string `json:",omitempty"`
+	// FromBuild, for restarts and requeues, is the ID string of the
+	// build this one was re-created from.
+	FromBuild string `json:",omitempty"`
+}
+
+// buildAuditMaxEvents bounds the trail kept per build; a build sees a
+// handful of lifecycle events, so hitting this indicates abuse.
+const buildAuditMaxEvents = 100
+
+var buildAudit = &buildAuditLog{trails: map[string][]*buildAuditEvent{}}
+
+// buildAuditLog is an append-only in-memory event log, keyed by the
+// build's ID string ("repo#ID").
+type buildAuditLog struct {
+	mu     sync.Mutex
+	trails map[string][]*buildAuditEvent
+}
+
+// record appends one event for build, stamping the actor from ctx and
+// the current time.
+func (l *buildAuditLog) record(ctx context.Context, build sourcegraph.BuildSpec, action, fromBuild string) {
+	actor := auth.ActorFromContext(ctx)
+	event := &buildAuditEvent{
+		Time:       time.Now(),
+		Action:     action,
+		ActorUID:   actor.UID,
+		ActorLogin: actor.Login,
+		FromBuild:  fromBuild,
+	}
+	key := build.IDString()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.trails[key]) >= buildAuditMaxEvents {
+		return
+	}
+	l.trails[key] = append(l.trails[key], event)
+}
+
+// trail returns a copy of build's events, oldest first.
+func (l *buildAuditLog) trail(build sourcegraph.BuildSpec) []*buildAuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := make([]*buildAuditEvent, len(l.trails[build.IDString()]))
+	copy(events, l.trails[build.IDString()])
+	return events
+}
+
+// serveRepoBuildAudit returns the audit trail of one build, oldest
+// event first. The build must belong to the repo in the URL.
+func serveRepoBuildAudit(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	buildSpec, err := getBuildSpec(r)
+	if err != nil {
+		return err
+	}
+	if _, err := checkBuildInRepo(ctx, cl, buildSpec); err != nil {
+		return err
+	}
+
+	events := buildAudit.trail(*buildSpec)
+	if events == nil {
+		events = []*buildAuditEvent{}
+	}
+	return writeJSON(w, events)
+}
@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+func resetBuildAudit() {
+	buildAudit.mu.Lock()
+	buildAudit.trails = map[string][]*buildAuditEvent{}
+	buildAudit.mu.Unlock()
+}
+
+func TestRepoBuildAudit(t *testing.T) {
+	c, mock := newTest()
+	resetBuildAudit()
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	spec := sourcegraph.BuildSpec{Repo: sourcegraph.RepoSpec{URI: "r/r"}, ID: 1}
+	actorCtx := auth.WithActor(context.Background(), auth.Actor{UID: 7, Login: "alice"})
+	buildAudit.record(actorCtx, spec, "created", "")
+	buildAudit.record(actorCtx, spec, "restarted", "r/r#0")
+
+	var events []*buildAuditEvent
+	if err := c.GetJSON("/repos/r/r/-/builds/1/audit", &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if events[0].Action != "created" || events[1].Action != "restarted" {
+		t.Errorf("got actions %q, %q, want created, restarted (oldest first)", events[0].Action, events[1].Action)
+	}
+	if events[0].ActorUID != 7 || events[0].ActorLogin != "alice" {
+		t.Errorf("got actor %d/%q, want 7/alice", events[0].ActorUID, events[0].ActorLogin)
+	}
+	if events[1].FromBuild != "r/r#0" {
+		t.Errorf("got FromBuild %q, want r/r#0", events[1].FromBuild)
+	}
+	if events[0].Time.IsZero() {
+		t.Error("event has no timestamp")
+	}
+}
+
+func TestRepoBuildAudit_empty(t *testing.T) {
+	c, mock := newTest()
+	resetBuildAudit()
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	var events []*buildAuditEvent
+	if err := c.GetJSON("/repos/r/r/-/builds/1/audit", &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("got %d events for an unrecorded build, want 0", len(events))
+	}
+}
+
+func TestRepoBuildAudit_repoMismatch(t *testing.T) {
+	c, mock := newTest()
+	resetBuildAudit()
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: "other/repo"}, nil
+	}
+
+	resp, err := c.Get("/repos/r/r/-/builds/1/audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestBuildAudit_recordedOnCreate(t *testing.T) {
+	c, mock := newTest()
+	resetBuildAudit()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 7, Login: "alice"})
+
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+	mock.Repos.MockGet(t, "r/r")
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		return &sourcegraph.ResolvedRev{CommitID: commitID}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(commitID)}, nil
+	}
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: 5, Repo: "r/r", CommitID: op.CommitID}, nil
+	}
+
+	var build *sourcegraph.Build
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds", &sourcegraph.BuildsCreateOp{CommitID: commitID}, &build); err != nil {
+		t.Fatal(err)
+	}
+
+	events := buildAudit.trail(build.Spec())
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(events))
+	}
+	if events[0].Action != "created" || events[0].ActorUID != 7 {
+		t.Errorf("got event %+v, want created by UID 7", events[0])
+	}
+}
@@ -0,0 +1,176 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// The builds event stream is the live companion to the JSON builds
+// list: it emits a server-sent event whenever a build's status
+// changes, so dashboards can update without polling the list
+// themselves. The Builds service has no subscription mechanism, so
+// the stream is driven by periodically listing recent builds and
+// diffing their statuses against the previous poll.
+
+// buildEventsPollInterval is how often the event stream re-lists
+// builds to look for status changes.
+var buildEventsPollInterval = conf.GetenvDurationOrDefault("SG_BUILD_EVENTS_POLL_INTERVAL", "2s")
+
+// buildEventsMaxBuilds is how many recent builds each poll covers. A
+// build older than the newest buildEventsMaxBuilds builds that
+// changes status (which effectively doesn't happen — workers only
+// touch recent builds) is not reported.
+const buildEventsMaxBuilds = 100
+
+// buildEvent is the JSON payload of one server-sent event.
+type buildEvent struct {
+	Repo   string
+	ID     uint64
+	Status string
+	// Duration is how long the build has been (or was) running, in
+	// seconds. It is 0 until the build starts.
+	Duration float64 `json:",omitempty"`
+}
+
+// buildDuration returns how long b has been (or was) running, in
+// seconds.
+func buildDuration(b *sourcegraph.Build) float64 {
+	if b.StartedAt == nil {
+		return 0
+	}
+	end := time.Now()
+	if b.EndedAt != nil {
+		end = b.EndedAt.Time()
+	}
+	return end.Sub(b.StartedAt.Time()).Seconds()
+}
+
+// diffBuildEvents returns an event for each build whose status is
+// missing from or different in prev, plus the snapshot to diff the
+// next poll against. A nil prev (the first poll) reports every build,
+// giving a newly connected client the current state before the
+// deltas.
+func diffBuildEvents(builds []*sourcegraph.Build, prev map[string]string) ([]*buildEvent, map[string]string) {
+	var events []*buildEvent
+	next := make(map[string]string, len(builds))
+	for _, b := range builds {
+		key := b.Spec().IDString()
+		status := buildStatus(b)
+		next[key] = status
+		if prevStatus, ok := prev[key]; !ok || prevStatus != status {
+			events = append(events, &buildEvent{
+				Repo:     b.Repo,
+				ID:       b.ID,
+				Status:   status,
+				Duration: buildDuration(b),
+			})
+		}
+	}
+	return events, next
+}
+
+// serveRepoBuildsEvents streams build status changes for one repo.
+func serveRepoBuildsEvents(w http.ResponseWriter, r *http.Request) error {
+	repoSpec, err := getRepoSpec(r)
+	if err != nil {
+		return err
+	}
+	return streamBuildEvents(w, r, repoSpec.URI)
+}
+
+// serveBuildsEvents streams build status changes across all repos.
+// Like the cross-repo builds list, it is admin-only.
+func serveBuildsEvents(w http.ResponseWriter, r *http.Request) error {
+	ctx, _ := handlerutil.Client(r)
+	if !auth.ActorFromContext(ctx).HasAdminAccess() {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("admin access required")}
+	}
+	return streamBuildEvents(w, r, "")
+}
+
+func streamBuildEvents(w http.ResponseWriter, r *http.Request, repo string) error {
+	ctx, cl := handlerutil.Client(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("httpapi: response writer does not support flushing (required for event streams)")
+	}
+	var closed <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+
+	opt := sourcegraph.BuildListOptions{
+		Repo:        repo,
+		Sort:        "created_at",
+		Direction:   "desc",
+		ListOptions: sourcegraph.ListOptions{PerPage: buildEventsMaxBuilds},
+	}
+
+	// The first list runs before any of the response is written, so
+	// its errors (repo not found, access denied) still produce a
+	// regular error response.
+	builds, err := cl.Builds.List(ctx, &opt)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(buildEventsPollInterval)
+	defer ticker.Stop()
+
+	var prev map[string]string
+	for {
+		events, next := diffBuildEvents(builds.Builds, prev)
+		prev = next
+		if len(events) == 0 {
+			// An SSE comment keeps intermediaries from timing out
+			// the idle connection and surfaces dead clients as write
+			// errors.
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+		}
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: build\ndata: %s\n\n", data); err != nil {
+				// The client going away mid-stream is normal
+				// operation, not a handler failure.
+				return nil
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-closed:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		builds, err = cl.Builds.List(ctx, &opt)
+		if err != nil {
+			// The stream is already under way; end it quietly and
+			// let the client reconnect (the EventSource API does so
+			// automatically) rather than corrupt it with an error
+			// body.
+			return nil
+		}
+	}
+}
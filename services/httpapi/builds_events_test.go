@@ -0,0 +1,105 @@
+package httpapi
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sqs/pbtypes"
+)
+
+func TestDiffBuildEvents(t *testing.T) {
+	queued := &sourcegraph.Build{ID: 1, Repo: "r/r", BuildConfig: sourcegraph.BuildConfig{Queue: true}}
+	succeeded := &sourcegraph.Build{ID: 2, Repo: "r/r", Success: true}
+
+	// The first poll (nil prev) reports every build.
+	events, snapshot := diffBuildEvents([]*sourcegraph.Build{queued, succeeded}, nil)
+	if len(events) != 2 {
+		t.Fatalf("first poll: got %d events, want 2", len(events))
+	}
+	if events[0].Status != "queued" || events[1].Status != "succeeded" {
+		t.Errorf("first poll: got statuses %q, %q; want queued, succeeded", events[0].Status, events[1].Status)
+	}
+
+	// An unchanged poll reports nothing.
+	events, snapshot = diffBuildEvents([]*sourcegraph.Build{queued, succeeded}, snapshot)
+	if len(events) != 0 {
+		t.Fatalf("unchanged poll: got %d events, want 0", len(events))
+	}
+
+	// Only the changed build is reported.
+	failed := &sourcegraph.Build{ID: 1, Repo: "r/r", Failure: true}
+	events, _ = diffBuildEvents([]*sourcegraph.Build{failed, succeeded}, snapshot)
+	if len(events) != 1 {
+		t.Fatalf("changed poll: got %d events, want 1", len(events))
+	}
+	if events[0].ID != 1 || events[0].Status != "failed" {
+		t.Errorf("changed poll: got event %+v, want ID 1 status failed", events[0])
+	}
+}
+
+func TestRepoBuildsEvents(t *testing.T) {
+	c, mock := newTest()
+	origInterval := buildEventsPollInterval
+	buildEventsPollInterval = time.Millisecond
+	defer func() { buildEventsPollInterval = origInterval }()
+
+	// Serve a queued build, then the same build active, then fail the
+	// list, which ends the stream (the client would reconnect).
+	var polls int32
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		if want := "r/r"; opt.Repo != want {
+			t.Errorf("got Repo %q, want %q", opt.Repo, want)
+		}
+		switch atomic.AddInt32(&polls, 1) {
+		case 1:
+			return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{{ID: 1, Repo: "r/r", BuildConfig: sourcegraph.BuildConfig{Queue: true}}}}, nil
+		case 2:
+			started := pbtypes.NewTimestamp(time.Now())
+			return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{{ID: 1, Repo: "r/r", StartedAt: &started}}}, nil
+		default:
+			return nil, errors.New("stop")
+		}
+	}
+
+	resp, err := c.Get("/repos/r/r/-/builds/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+		t.Errorf("got content-type %q, want text/event-stream", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Status":"queued"`, `"Status":"active"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("body does not contain %q:\n%s", want, body)
+		}
+	}
+	if got, want := strings.Count(string(body), "event: build"), 2; got != want {
+		t.Errorf("got %d events, want %d:\n%s", got, want, body)
+	}
+}
+
+func TestBuildsEvents_adminOnly(t *testing.T) {
+	c, _ := newTest()
+	resp, err := c.Get("/builds/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,103 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+	apirouter "sourcegraph.com/sourcegraph/sourcegraph/services/httpapi/router"
+)
+
+// Signed, expiring URLs for sharing a single build task log with
+// someone who has no session (e.g. a contractor debugging a failing
+// build), without exposing any other builds.
+var (
+	// logShareSecret signs shared log URLs. If it is unset, log
+	// sharing is disabled entirely.
+	logShareSecret = conf.GetenvOrDefault("SG_LOG_SHARE_SECRET", "")
+
+	// logShareTTL is how long a shared log link stays valid.
+	logShareTTL = conf.GetenvDurationOrDefault("SG_LOG_SHARE_TTL", "168h")
+)
+
+// sharedLogSignature returns the hex-encoded HMAC-SHA256 binding a
+// task log to an expiry time, so neither the task coordinates nor the
+// expiry can be tampered with.
+func sharedLogSignature(task sourcegraph.TaskSpec, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(logShareSecret))
+	fmt.Fprintf(mac, "%s\x00%d\x00%d\x00%d", task.Build.Repo.URI, task.Build.ID, task.ID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sharedBuildTaskLogURL returns a signed URL for the task's log that
+// is valid for logShareTTL and requires no session to fetch.
+func sharedBuildTaskLogURL(task sourcegraph.TaskSpec) (*url.URL, time.Time, error) {
+	if logShareSecret == "" {
+		return nil, time.Time{}, &errcode.HTTPErr{Status: http.StatusNotFound, Err: errors.New("log sharing is disabled (SG_LOG_SHARE_SECRET is not set)")}
+	}
+	u, err := apirouter.URL(apirouter.BuildTaskLogShared, map[string]string{
+		"Repo":  task.Build.Repo.URI,
+		"Build": strconv.FormatUint(task.Build.ID, 10),
+		"Task":  strconv.FormatUint(task.ID, 10),
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	expiresAt := time.Now().Add(logShareTTL)
+	q := u.Query()
+	q.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	q.Set("sig", sharedLogSignature(task, expiresAt.Unix()))
+	u.RawQuery = q.Encode()
+	return u, expiresAt, nil
+}
+
+// serveSharedBuildTaskLog serves a task log to the bearer of a valid
+// shared URL. The signature — not the caller's session — is the
+// authorization, so expired or tampered links are rejected with 403
+// and nothing about the build is revealed.
+func serveSharedBuildTaskLog(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	taskSpec, err := getBuildTaskSpec(r)
+	if err != nil {
+		return err
+	}
+
+	if logShareSecret == "" {
+		return &errcode.HTTPErr{Status: http.StatusNotFound, Err: errors.New("log sharing is disabled")}
+	}
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("invalid shared log URL")}
+	}
+	want := sharedLogSignature(taskSpec, expires)
+	if !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(want)) {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("invalid shared log URL")}
+	}
+	if time.Now().After(time.Unix(expires, 0)) {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("shared log URL has expired")}
+	}
+
+	entries, err := cl.Builds.GetTaskLog(ctx, &sourcegraph.BuildsGetTaskLogOp{Task: taskSpec, Opt: &sourcegraph.BuildGetLogOptions{}})
+	if err != nil {
+		return err
+	}
+	return writePlainLogEntries(w, r, entries)
+}
+
+// sharedLogInfo is the response of the share=true mode of the task
+// log endpoint.
+type sharedLogInfo struct {
+	URL       string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,125 @@
+package httpapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+)
+
+func TestSharedBuildTaskLog(t *testing.T) {
+	origSecret := logShareSecret
+	logShareSecret = "test-secret"
+	defer func() { logShareSecret = origSecret }()
+
+	c, mock := newTest()
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		return &sourcegraph.LogEntries{Entries: []string{"line1", "line2"}}, nil
+	}
+
+	// Mint a share link as an authorized viewer of the log.
+	var info *sharedLogInfo
+	if err := c.GetJSON("/repos/r/r/-/builds/123/tasks/456/log?share=true", &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.URL == "" {
+		t.Fatal("missing shared URL")
+	}
+	if info.ExpiresAt.Before(time.Now()) {
+		t.Errorf("got ExpiresAt %v in the past", info.ExpiresAt)
+	}
+
+	// The minted link serves the log.
+	resp, err := c.GetOK(info.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if want := "line1\nline2"; string(body) != want {
+		t.Errorf("got body %q, want %q", string(body), want)
+	}
+}
+
+func TestSharedBuildTaskLog_invalid(t *testing.T) {
+	origSecret := logShareSecret
+	logShareSecret = "test-secret"
+	defer func() { logShareSecret = origSecret }()
+
+	c, mock := newTest()
+
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		t.Error("log served despite invalid signature")
+		return &sourcegraph.LogEntries{}, nil
+	}
+
+	future := time.Now().Add(time.Hour).Unix()
+	urls := map[string]string{
+		"tampered signature": "/repos/r/r/-/builds/123/tasks/456/log/shared?expires=" + strconv.FormatInt(future, 10) + "&sig=deadbeef",
+		"missing expiry":     "/repos/r/r/-/builds/123/tasks/456/log/shared?sig=deadbeef",
+	}
+	for label, u := range urls {
+		req, _ := http.NewRequest("GET", u, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: got status %d, want %d", label, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+}
+
+func TestSharedBuildTaskLog_expired(t *testing.T) {
+	origSecret := logShareSecret
+	logShareSecret = "test-secret"
+	defer func() { logShareSecret = origSecret }()
+
+	c, mock := newTest()
+
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		t.Error("log served despite expired link")
+		return &sourcegraph.LogEntries{}, nil
+	}
+
+	// A correctly signed but expired link must be rejected.
+	task := sourcegraph.TaskSpec{Build: sourcegraph.BuildSpec{Repo: sourcegraph.RepoSpec{URI: "r/r"}, ID: 123}, ID: 456}
+	expired := time.Now().Add(-time.Hour).Unix()
+	u := "/repos/r/r/-/builds/123/tasks/456/log/shared?expires=" + strconv.FormatInt(expired, 10) + "&sig=" + sharedLogSignature(task, expired)
+	req, _ := http.NewRequest("GET", u, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestSharedBuildTaskLog_disabled(t *testing.T) {
+	origSecret := logShareSecret
+	logShareSecret = ""
+	defer func() { logShareSecret = origSecret }()
+
+	c, mock := newTest()
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	req, _ := http.NewRequest("GET", "/repos/r/r/-/builds/123/tasks/456/log?share=true", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,110 @@
+package httpapi
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// buildsStatsMaxPages bounds how many pages of builds are fetched per
+// status bucket when computing stats. Beyond it the counts are
+// reported as lower bounds, which is good enough for a scaling
+// decision.
+const buildsStatsMaxPages = 10
+
+// buildsStats is the JSON payload served by serveBuildsStats.
+type buildsStats struct {
+	// Queued and Active are the number of builds in each state.
+	Queued int
+	Active int
+
+	// OldestQueuedSeconds is the age of the oldest queued build, in
+	// seconds. It is 0 if no builds are queued.
+	OldestQueuedSeconds float64
+
+	// QueueLatencySeconds holds percentiles (keyed "p50", "p90",
+	// "p95", "p99") of the time that currently active builds spent
+	// waiting in the queue before starting, in seconds.
+	QueueLatencySeconds map[string]float64
+}
+
+// serveBuildsStats reports aggregate statistics about the build queue
+// (counts by state, oldest queued build age, and queue latency
+// percentiles) so dashboards can decide when to scale build workers.
+func serveBuildsStats(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	queued, err := listBuildsForStats(ctx, cl, &sourcegraph.BuildListOptions{Queued: true})
+	if err != nil {
+		return err
+	}
+	active, err := listBuildsForStats(ctx, cl, &sourcegraph.BuildListOptions{Active: true})
+	if err != nil {
+		return err
+	}
+
+	stats := buildsStats{
+		Queued: len(queued),
+		Active: len(active),
+	}
+
+	now := time.Now()
+	for _, b := range queued {
+		if age := now.Sub(b.CreatedAt.Time()).Seconds(); age > stats.OldestQueuedSeconds {
+			stats.OldestQueuedSeconds = age
+		}
+	}
+
+	var latencies []float64
+	for _, b := range active {
+		if b.StartedAt != nil {
+			latencies = append(latencies, b.StartedAt.Time().Sub(b.CreatedAt.Time()).Seconds())
+		}
+	}
+	sort.Float64s(latencies)
+	stats.QueueLatencySeconds = map[string]float64{
+		"p50": percentile(latencies, 0.50),
+		"p90": percentile(latencies, 0.90),
+		"p95": percentile(latencies, 0.95),
+		"p99": percentile(latencies, 0.99),
+	}
+
+	return writeJSON(w, &stats)
+}
+
+func listBuildsForStats(ctx context.Context, cl *sourcegraph.Client, opt *sourcegraph.BuildListOptions) ([]*sourcegraph.Build, error) {
+	var all []*sourcegraph.Build
+	opt.PerPage = 100
+	for page := 1; page <= buildsStatsMaxPages; page++ {
+		opt.Page = int32(page)
+		builds, err := cl.Builds.List(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, builds.Builds...)
+		if !builds.HasMore {
+			break
+		}
+	}
+	return all, nil
+}
+
+// percentile returns the p-th (0 < p <= 1) percentile of sorted using
+// the nearest-rank method, or 0 if sorted is empty.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
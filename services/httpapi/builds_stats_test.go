@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sqs/pbtypes"
+)
+
+func TestBuildsStats(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		switch {
+		case opt.Queued:
+			return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+				{ID: 1, Repo: "r/r"},
+				{ID: 2, Repo: "r/r"},
+			}}, nil
+		case opt.Active:
+			started := pbtypes.NewTimestamp(pbtypes.Timestamp{}.Time())
+			return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+				{ID: 3, Repo: "r/r", StartedAt: &started},
+			}}, nil
+		}
+		return &sourcegraph.BuildList{}, nil
+	}
+
+	var stats *buildsStats
+	if err := c.GetJSON("/builds/stats", &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Queued != 2 {
+		t.Errorf("got Queued %d, want 2", stats.Queued)
+	}
+	if stats.Active != 1 {
+		t.Errorf("got Active %d, want 1", stats.Active)
+	}
+	if stats.OldestQueuedSeconds <= 0 {
+		t.Errorf("got OldestQueuedSeconds %f, want > 0", stats.OldestQueuedSeconds)
+	}
+	if _, ok := stats.QueueLatencySeconds["p50"]; !ok {
+		t.Errorf("QueueLatencySeconds missing p50: %v", stats.QueueLatencySeconds)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0.50, 5},
+		{0.90, 9},
+		{0.99, 10},
+	}
+	for _, test := range tests {
+		if got := percentile(sorted, test.p); got != test.want {
+			t.Errorf("percentile(%v): got %f, want %f", test.p, got, test.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile(nil): got %f, want 0", got)
+	}
+}
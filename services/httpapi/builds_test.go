@@ -1,12 +1,72 @@
 package httpapi
 
 import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"golang.org/x/net/context"
 
 	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sqs/pbtypes"
 )
 
+func TestBuildLog_combined(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+	mock.Builds.ListBuildTasks_ = func(ctx context.Context, op *sourcegraph.BuildsListBuildTasksOp) (*sourcegraph.BuildTaskList, error) {
+		return &sourcegraph.BuildTaskList{BuildTasks: []*sourcegraph.BuildTask{
+			{ID: 1, Label: "Go build"},
+			{ID: 2, Label: "Go test"},
+			{ID: 3, Label: "Deploy"},
+		}}, nil
+	}
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		switch op.Task.ID {
+		case 1:
+			if want := "5"; op.Opt.MinID != want {
+				t.Errorf("task 1: got MinID %q, want %q", op.Opt.MinID, want)
+			}
+			return &sourcegraph.LogEntries{MaxID: "7", Entries: []string{"building", "built"}}, nil
+		case 2:
+			if op.Opt.MinID != "" {
+				t.Errorf("task 2: got MinID %q, want none", op.Opt.MinID)
+			}
+			return &sourcegraph.LogEntries{MaxID: "9", Entries: []string{"testing"}}, nil
+		default:
+			// No new entries for this task.
+			return &sourcegraph.LogEntries{}, nil
+		}
+	}
+
+	resp, err := c.GetOK("/repos/r/r/-/builds/123/log?MinID=1:5,3:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "==> task 1: Go build <==\nbuilding\nbuilt\n==> task 2: Go test <==\ntesting"
+	if string(body) != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+	if want := "1:7,2:9,3:2"; resp.Header.Get("x-sourcegraph-log-max-id") != want {
+		t.Errorf("got MaxID %q, want %q", resp.Header.Get("x-sourcegraph-log-max-id"), want)
+	}
+}
+
 func TestBuild(t *testing.T) {
 	c, mock := newTest()
 
@@ -46,6 +106,236 @@ func TestBuilds(t *testing.T) {
 	}
 }
 
+func TestBuilds_paginationLinks(t *testing.T) {
+	c, mock := newTest()
+
+	var calledList bool
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		calledList = true
+		return &sourcegraph.BuildList{
+			Builds:         []*sourcegraph.Build{{ID: 123, CommitID: "c", Repo: "r/r"}},
+			StreamResponse: sourcegraph.StreamResponse{HasMore: true},
+		}, nil
+	}
+
+	resp, err := c.GetOK("/builds?Page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !calledList {
+		t.Error("!calledList")
+	}
+	links := resp.Header.Get("Link")
+	for _, rel := range []string{`rel="first"`, `rel="prev"`, `rel="next"`} {
+		if !strings.Contains(links, rel) {
+			t.Errorf("Link header %q missing %s", links, rel)
+		}
+	}
+}
+
+func TestBuildTaskLog_download(t *testing.T) {
+	c, mock := newTest()
+
+	var calledGetTaskLog bool
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		calledGetTaskLog = true
+		return &sourcegraph.LogEntries{MaxID: "45", Entries: []string{"a", "b"}}, nil
+	}
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	resp, err := c.GetOK("/repos/r/r/-/builds/123/tasks/456/log?download=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !calledGetTaskLog {
+		t.Error("!calledGetTaskLog")
+	}
+	if want := `attachment; filename="build-123-task-456.log"`; resp.Header.Get("content-disposition") != want {
+		t.Errorf("got Content-Disposition %q, want %q", resp.Header.Get("content-disposition"), want)
+	}
+	if want := "45"; resp.Header.Get("x-sourcegraph-log-max-id") != want {
+		t.Errorf("got max ID %q, want %q", resp.Header.Get("x-sourcegraph-log-max-id"), want)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\nb"; string(body) != want {
+		t.Errorf("got body %q, want %q", string(body), want)
+	}
+}
+
+func TestIsClientDisconnect(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{context.Canceled, true},
+		{syscall.EPIPE, true},
+		{&net.OpError{Op: "write", Err: &os.SyscallError{Syscall: "write", Err: syscall.EPIPE}}, true},
+		{&net.OpError{Op: "write", Err: syscall.ECONNRESET}, true},
+		{errors.New("disk full"), false},
+	}
+	for _, test := range tests {
+		if got := isClientDisconnect(test.err); got != test.want {
+			t.Errorf("isClientDisconnect(%v): got %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestBuilds_pageSizeClamp(t *testing.T) {
+	c, mock := newTest()
+
+	var gotPerPage int32
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		gotPerPage = opt.PerPage
+		return &sourcegraph.BuildList{}, nil
+	}
+
+	var builds *sourcegraph.BuildList
+	if err := c.GetJSON("/builds", &builds); err != nil {
+		t.Fatal(err)
+	}
+	if gotPerPage != buildListDefaultPerPage {
+		t.Errorf("got default PerPage %d, want %d", gotPerPage, buildListDefaultPerPage)
+	}
+
+	if err := c.GetJSON("/builds?PerPage=100000", &builds); err != nil {
+		t.Fatal(err)
+	}
+	if gotPerPage != buildListMaxPerPage {
+		t.Errorf("got clamped PerPage %d, want %d", gotPerPage, buildListMaxPerPage)
+	}
+}
+
+func TestBuildTaskLog_repoMismatch(t *testing.T) {
+	c, mock := newTest()
+
+	// The build exists but belongs to a different repo than the one
+	// in the URL; the log must not be fetched.
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: "other/repo"}, nil
+	}
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		t.Error("fetched the log of a build outside the URL's repo")
+		return &sourcegraph.LogEntries{}, nil
+	}
+
+	req, _ := http.NewRequest("GET", "/repos/r/r/-/builds/123/tasks/456/log", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestBuildTaskLog_tail(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		return &sourcegraph.LogEntries{MaxID: "45", Entries: []string{"a", "b", "c", "d", "e"}}, nil
+	}
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	resp, err := c.GetOK("/repos/r/r/-/builds/123/tasks/456/log?tail=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// MaxID must be the log's real cursor, not the tail's, so the
+	// client can follow from where the log actually ends.
+	if want := "45"; resp.Header.Get("x-sourcegraph-log-max-id") != want {
+		t.Errorf("got max ID %q, want %q", resp.Header.Get("x-sourcegraph-log-max-id"), want)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "d\ne"; string(body) != want {
+		t.Errorf("got body %q, want %q", string(body), want)
+	}
+
+	resp, err = c.Get("/repos/r/r/-/builds/123/tasks/456/log?tail=bogus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestBuildTaskLog_search(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		return &sourcegraph.LogEntries{MaxID: "45", Entries: []string{"a", "b", "c", "error: boom", "d", "e", "f"}}, nil
+	}
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI}, nil
+	}
+
+	resp, err := c.GetOK("/repos/r/r/-/builds/123/tasks/456/log?q=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if want := "45"; resp.Header.Get("x-sourcegraph-log-max-id") != want {
+		t.Errorf("got max ID %q, want %q", resp.Header.Get("x-sourcegraph-log-max-id"), want)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "b\nc\nerror: boom\nd\ne"; string(body) != want {
+		t.Errorf("got body %q, want %q", string(body), want)
+	}
+}
+
+func TestBuildTaskLog_conditionalGet(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.GetTaskLog_ = func(ctx context.Context, op *sourcegraph.BuildsGetTaskLogOp) (*sourcegraph.LogEntries, error) {
+		return &sourcegraph.LogEntries{MaxID: "45", Entries: []string{"a", "b"}}, nil
+	}
+	ended := pbtypes.NewTimestamp(time.Unix(100, 0))
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI, EndedAt: &ended, Success: true}, nil
+	}
+
+	resp, err := c.GetOK("/repos/r/r/-/builds/123/tasks/456/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	etag := resp.Header.Get("etag")
+	if etag == "" {
+		t.Fatal("no ETag on finished build's task log")
+	}
+
+	req, _ := http.NewRequest("GET", "/repos/r/r/-/builds/123/tasks/456/log", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp2, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp2.StatusCode != http.StatusNotModified {
+		t.Errorf("got status %d, want %d", resp2.StatusCode, http.StatusNotModified)
+	}
+	body, _ := ioutil.ReadAll(resp2.Body)
+	if len(body) != 0 {
+		t.Errorf("expected empty 304 body, got %q", body)
+	}
+}
+
 func TestBuildTasks(t *testing.T) {
 	c, mock := newTest()
 
@@ -64,3 +354,39 @@ func TestBuildTasks(t *testing.T) {
 		t.Error("!calledListBuildTasks")
 	}
 }
+
+func TestBuildTasks_full(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.MockListBuildTasks(t,
+		&sourcegraph.BuildTask{ID: 1, Label: "build", Success: true},
+		&sourcegraph.BuildTask{ID: 2, ParentID: 1, Label: "compile", Success: true},
+		&sourcegraph.BuildTask{ID: 3, ParentID: 1, Label: "test", Failure: true},
+		&sourcegraph.BuildTask{ID: 4, Label: "deploy", Skipped: true},
+		// Parent not in the list: treated as top-level, not dropped.
+		&sourcegraph.BuildTask{ID: 5, ParentID: 99, Label: "orphan"},
+	)
+
+	var tree []*buildTaskNode
+	if err := c.GetJSON("/repos/r/-/builds/123/tasks?full=true", &tree); err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != 3 {
+		t.Fatalf("got %d top-level tasks, want 3", len(tree))
+	}
+	if tree[0].Task.Label != "build" || tree[0].Status != "succeeded" {
+		t.Errorf("got root %q/%q, want build/succeeded", tree[0].Task.Label, tree[0].Status)
+	}
+	if len(tree[0].Children) != 2 {
+		t.Fatalf("got %d children of build, want 2", len(tree[0].Children))
+	}
+	if tree[0].Children[1].Task.Label != "test" || tree[0].Children[1].Status != "failed" {
+		t.Errorf("got child %q/%q, want test/failed", tree[0].Children[1].Task.Label, tree[0].Children[1].Status)
+	}
+	if tree[1].Status != "skipped" {
+		t.Errorf("got status %q for deploy, want skipped", tree[1].Status)
+	}
+	if tree[2].Task.Label != "orphan" {
+		t.Errorf("got %q as last top-level task, want orphan", tree[2].Task.Label)
+	}
+}
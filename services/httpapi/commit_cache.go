@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+// buildCommitCache caches the resolved commits shown on build pages.
+// Commits are immutable, so repeated views of a popular build would
+// otherwise resolve the same commit on every page load; only
+// author/avatar metadata attached to the commit can go stale, which
+// the TTL bounds. Set the TTL to 0 to disable the cache.
+var buildCommitCache = newCommitCache(conf.GetenvDurationOrDefault("SG_BUILD_COMMIT_CACHE_TTL", "10m"))
+
+// commitCache is a TTL-bounded in-memory cache of resolved commits,
+// keyed by repo URI and full commit ID.
+type commitCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*commitCacheEntry
+}
+
+type commitCacheEntry struct {
+	commit *vcs.Commit
+	at     time.Time
+}
+
+func newCommitCache(ttl time.Duration) *commitCache {
+	return &commitCache{ttl: ttl, entries: map[string]*commitCacheEntry{}}
+}
+
+func (c *commitCache) get(repoURI, commitID string) (*vcs.Commit, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[repoURI+"@"+commitID]
+	if e == nil || time.Since(e.at) >= c.ttl {
+		return nil, false
+	}
+	return e.commit, true
+}
+
+func (c *commitCache) add(repoURI, commitID string, commit *vcs.Commit) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(time.Now())
+	c.entries[repoURI+"@"+commitID] = &commitCacheEntry{commit: commit, at: time.Now()}
+}
+
+// prune drops expired entries so the map doesn't grow without bound.
+// The caller must hold c.mu.
+func (c *commitCache) prune(now time.Time) {
+	for key, e := range c.entries {
+		if now.Sub(e.at) >= c.ttl {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// getCommitCached resolves a build's commit through buildCommitCache.
+// The caller has already been authorized for the repo (it fetched the
+// build), so serving a cached commit doesn't bypass access control.
+func getCommitCached(ctx context.Context, cl *sourcegraph.Client, repo sourcegraph.RepoSpec, commitID string) (*vcs.Commit, error) {
+	if commit, ok := buildCommitCache.get(repo.URI, commitID); ok {
+		return commit, nil
+	}
+	commit, err := cl.Repos.GetCommit(ctx, &sourcegraph.RepoRevSpec{RepoSpec: repo, CommitID: commitID})
+	if err != nil {
+		return nil, err
+	}
+	buildCommitCache.add(repo.URI, commitID, commit)
+	return commit, nil
+}
@@ -0,0 +1,41 @@
+package httpapi
+
+import (
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+func TestCommitCache(t *testing.T) {
+	c := newCommitCache(time.Minute)
+
+	if _, ok := c.get("r/r", "a"); ok {
+		t.Error("got cache hit on empty cache")
+	}
+	commit := &vcs.Commit{ID: "a"}
+	c.add("r/r", "a", commit)
+	if got, ok := c.get("r/r", "a"); !ok || got != commit {
+		t.Errorf("got (%v, %v), want cached commit", got, ok)
+	}
+	if _, ok := c.get("r/other", "a"); ok {
+		t.Error("got cache hit for a different repo")
+	}
+
+	// Expired entries are misses and are pruned on the next add.
+	c.entries["r/r@a"].at = time.Now().Add(-2 * time.Minute)
+	if _, ok := c.get("r/r", "a"); ok {
+		t.Error("got cache hit on expired entry")
+	}
+	c.add("r/r", "b", &vcs.Commit{ID: "b"})
+	if _, present := c.entries["r/r@a"]; present {
+		t.Error("expired entry not pruned")
+	}
+
+	// A zero TTL disables the cache.
+	disabled := newCommitCache(0)
+	disabled.add("r/r", "a", commit)
+	if _, ok := disabled.get("r/r", "a"); ok {
+		t.Error("got cache hit with cache disabled")
+	}
+}
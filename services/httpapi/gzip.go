@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// compressedContentTypes are content types that are already compressed
+// and so would only waste CPU if gzipped again.
+var compressedContentTypes = []string{
+	"application/gzip",
+	"application/zip",
+	"application/x-gzip",
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+}
+
+// gzipHandler wraps h and gzips its response body if the client
+// advertises gzip support via Accept-Encoding. Unlike
+// gziphandler.GzipHandler (which wraps the whole API in cli/serve_cmd.go),
+// it decides at the first write, so it can skip responses that are
+// already compressed — either by content type or because an outer
+// handler already set Content-Encoding. The wrapped writer implements
+// http.Flusher, so streaming responses still flush through the gzip
+// writer.
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gzw := &gzipResponseWriter{ResponseWriter: w}
+		defer gzw.close()
+		h.ServeHTTP(gzw, r)
+	})
+}
+
+// gzipResponseWriter gzips the response body unless the response turns
+// out to be already compressed. The decision is deferred until the
+// first write (or WriteHeader), by which point the handler has set its
+// response headers.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	passthrough bool
+	decided     bool
+}
+
+func (w *gzipResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if w.Header().Get("content-encoding") != "" || isCompressedContentType(w.Header().Get("content-type")) {
+		w.passthrough = true
+		return
+	}
+	w.Header().Set("content-encoding", "gzip")
+	w.Header().Del("content-length")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.decide()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.decide()
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Flush implements http.Flusher so follow-mode log streaming works
+// through the compressed writer.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+func isCompressedContentType(contentType string) bool {
+	for _, ct := range compressedContentTypes {
+		if strings.HasPrefix(contentType, ct) {
+			return true
+		}
+	}
+	return false
+}
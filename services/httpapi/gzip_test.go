@@ -0,0 +1,97 @@
+package httpapi
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGzipHandler(t *testing.T) {
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/plain; charset=utf-8")
+		w.Write([]byte("hello"))
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("wrapped ResponseWriter does not implement http.Flusher")
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(w, r)
+
+	if want := "gzip"; w.Header().Get("content-encoding") != want {
+		t.Errorf("got Content-Encoding %q, want %q", w.Header().Get("content-encoding"), want)
+	}
+	if want := "Accept-Encoding"; w.Header().Get("Vary") != want {
+		t.Errorf("got Vary %q, want %q", w.Header().Get("Vary"), want)
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; string(body) != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+}
+
+func TestGzipHandler_noAcceptEncoding(t *testing.T) {
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get("content-encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q, want none", got)
+	}
+	if want := "hello"; w.Body.String() != want {
+		t.Errorf("got body %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestGzipHandler_alreadyCompressedContentType(t *testing.T) {
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/gzip")
+		w.Write([]byte("gzipped bytes"))
+	}))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get("content-encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q, want none", got)
+	}
+	if want := "gzipped bytes"; w.Body.String() != want {
+		t.Errorf("got body %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestGzipHandler_alreadyEncoded(t *testing.T) {
+	// Simulates being nested under an outer gzip middleware (e.g. the
+	// site-wide gziphandler.GzipHandler), which sets Content-Encoding
+	// before the inner handler runs.
+	h := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("outer-compressed"))
+	}))
+
+	w := httptest.NewRecorder()
+	w.Header().Set("content-encoding", "gzip")
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(w, r)
+
+	if want := "outer-compressed"; w.Body.String() != want {
+		t.Errorf("got body %q, want %q (double compression?)", w.Body.String(), want)
+	}
+}
@@ -67,6 +67,18 @@ func writePaginationHeader(w http.ResponseWriter, current *url.URL, listOpts sou
 		})
 	}
 
+	// Emit a few numbered neighbor pages so clients can render direct
+	// page links without computing URLs themselves.
+	for p := page - 2; p <= page+2; p++ {
+		if p < 1 || p > numPages || p == page {
+			continue
+		}
+		links = append(links, link{
+			rel: fmt.Sprintf("page-%d", p),
+			url: urlWithListOptions(current, sourcegraph.ListOptions{Page: p, PerPage: perPage}),
+		})
+	}
+
 	linkStrs := make([]string, len(links))
 	for i, link := range links {
 		linkStrs[i] = fmt.Sprintf(`<%s>; rel="%s"`, link.url, link.rel)
@@ -74,6 +86,59 @@ func writePaginationHeader(w http.ResponseWriter, current *url.URL, listOpts sou
 
 	w.Header().Add("Link", strings.Join(linkStrs, ", "))
 	w.Header().Add("X-Total-Count", strconv.Itoa(total))
+	writePagePositionHeaders(w, page, perPage)
+	w.Header().Add("X-Total-Pages", strconv.Itoa(int(numPages)))
+}
+
+// writePaginationHeaderStream is like writePaginationHeader, but for
+// streaming result sets where only "is there another page?" is known
+// (sourcegraph.StreamResponse), not the total count. It omits the
+// "last" and numbered page links and the X-Total-Count header, since
+// those can't be computed without a total.
+func writePaginationHeaderStream(w http.ResponseWriter, current *url.URL, listOpts sourcegraph.ListOptions, hasMore bool) {
+	page, perPage := int32(listOpts.PageOrDefault()), int32(listOpts.PerPageOrDefault())
+
+	type link struct {
+		rel string
+		url string
+	}
+	var links []link
+
+	if page != 1 {
+		links = append(links, link{
+			rel: "first",
+			url: urlWithListOptions(current, sourcegraph.ListOptions{Page: 1, PerPage: perPage}),
+		})
+		links = append(links, link{
+			rel: "prev",
+			url: urlWithListOptions(current, sourcegraph.ListOptions{Page: page - 1, PerPage: perPage}),
+		})
+	}
+	if hasMore {
+		links = append(links, link{
+			rel: "next",
+			url: urlWithListOptions(current, sourcegraph.ListOptions{Page: page + 1, PerPage: perPage}),
+		})
+	}
+
+	linkStrs := make([]string, len(links))
+	for i, link := range links {
+		linkStrs[i] = fmt.Sprintf(`<%s>; rel="%s"`, link.url, link.rel)
+	}
+
+	w.Header().Add("Link", strings.Join(linkStrs, ", "))
+	// The total (and so X-Total-Count and X-Total-Pages) is unknown
+	// for streaming result sets; clients show "Page 3" instead of
+	// "Page 3 of 12".
+	writePagePositionHeaders(w, page, perPage)
+}
+
+// writePagePositionHeaders writes the current page number and page
+// size, so paginated views can display position context ("showing
+// 20–40") without re-deriving it from the request URL.
+func writePagePositionHeaders(w http.ResponseWriter, page, perPage int32) {
+	w.Header().Add("X-Page", strconv.Itoa(int(page)))
+	w.Header().Add("X-Per-Page", strconv.Itoa(int(perPage)))
 }
 
 func urlWithListOptions(u *url.URL, listOpts sourcegraph.ListOptions) string {
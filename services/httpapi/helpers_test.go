@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+)
+
+func TestWritePaginationHeader(t *testing.T) {
+	w := httptest.NewRecorder()
+	u, _ := url.Parse("/builds?Page=3")
+	writePaginationHeader(w, u, sourcegraph.ListOptions{Page: 3, PerPage: 10}, 115)
+
+	if want := "115"; w.Header().Get("X-Total-Count") != want {
+		t.Errorf("got X-Total-Count %q, want %q", w.Header().Get("X-Total-Count"), want)
+	}
+	if want := "3"; w.Header().Get("X-Page") != want {
+		t.Errorf("got X-Page %q, want %q", w.Header().Get("X-Page"), want)
+	}
+	if want := "10"; w.Header().Get("X-Per-Page") != want {
+		t.Errorf("got X-Per-Page %q, want %q", w.Header().Get("X-Per-Page"), want)
+	}
+	if want := "12"; w.Header().Get("X-Total-Pages") != want {
+		t.Errorf("got X-Total-Pages %q, want %q", w.Header().Get("X-Total-Pages"), want)
+	}
+	links := w.Header().Get("Link")
+	for _, rel := range []string{`rel="first"`, `rel="prev"`, `rel="next"`, `rel="last"`} {
+		if !strings.Contains(links, rel) {
+			t.Errorf("Link header %q missing %s", links, rel)
+		}
+	}
+}
+
+func TestWritePaginationHeaderStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	u, _ := url.Parse("/builds?Page=3")
+	writePaginationHeaderStream(w, u, sourcegraph.ListOptions{Page: 3, PerPage: 10}, true)
+
+	if want := "3"; w.Header().Get("X-Page") != want {
+		t.Errorf("got X-Page %q, want %q", w.Header().Get("X-Page"), want)
+	}
+	if want := "10"; w.Header().Get("X-Per-Page") != want {
+		t.Errorf("got X-Per-Page %q, want %q", w.Header().Get("X-Per-Page"), want)
+	}
+	// No total is known for streaming result sets.
+	if got := w.Header().Get("X-Total-Count"); got != "" {
+		t.Errorf("got X-Total-Count %q, want none", got)
+	}
+	if got := w.Header().Get("X-Total-Pages"); got != "" {
+		t.Errorf("got X-Total-Pages %q, want none", got)
+	}
+	links := w.Header().Get("Link")
+	for _, rel := range []string{`rel="first"`, `rel="prev"`, `rel="next"`} {
+		if !strings.Contains(links, rel) {
+			t.Errorf("Link header %q missing %s", links, rel)
+		}
+	}
+	if strings.Contains(links, `rel="last"`) {
+		t.Errorf("Link header %q should not contain last link", links)
+	}
+}
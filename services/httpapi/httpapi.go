@@ -63,8 +63,13 @@ func NewHandler(m *mux.Router) http.Handler {
 
 	m.Get(apirouter.Annotations).Handler(handler(serveAnnotations))
 	m.Get(apirouter.AuthInfo).Handler(handler(serveAuthInfo))
-	m.Get(apirouter.Builds).Handler(handler(serveBuilds))
-	m.Get(apirouter.BuildTaskLog).Handler(handler(serveBuildTaskLog))
+	m.Get(apirouter.Builds).Handler(gzipHandler(handler(serveBuilds)))
+	m.Get(apirouter.BuildsAdmin).Handler(gzipHandler(handler(serveBuildsAdmin)))
+	m.Get(apirouter.BuildsEvents).Handler(handler(serveBuildsEvents))
+	m.Get(apirouter.BuildsStats).Handler(handler(serveBuildsStats))
+	m.Get(apirouter.BuildTaskLog).Handler(gzipHandler(handler(serveBuildTaskLog)))
+	m.Get(apirouter.BuildTaskLogShared).Handler(gzipHandler(handler(serveSharedBuildTaskLog)))
+	m.Get(apirouter.BuildLog).Handler(gzipHandler(handler(serveBuildLog)))
 	m.Get(apirouter.ChannelListen).HandlerFunc(serveChannelListen)
 	m.Get(apirouter.ChannelSend).Handler(handler(serveChannelSend))
 	m.Get(apirouter.Coverage).Handler(handler(serveCoverage))
@@ -84,10 +89,21 @@ func NewHandler(m *mux.Router) http.Handler {
 	m.Get(apirouter.RepoTreeList).Handler(handler(serveRepoTreeList))
 	m.Get(apirouter.RepoTreeSearch).Handler(handler(serveRepoTreeSearch))
 	m.Get(apirouter.RepoBuild).Handler(handler(serveRepoBuild))
-	m.Get(apirouter.RepoBuilds).Handler(handler(serveRepoBuilds))
-	m.Get(apirouter.RepoBuildTasks).Handler(handler(serveBuildTasks))
+	m.Get(apirouter.RepoBuildCompare).Handler(handler(serveRepoBuildCompare))
+	m.Get(apirouter.RepoBuildRestart).Handler(handler(serveRepoBuildRestart))
+	m.Get(apirouter.RepoBuilds).Handler(gzipHandler(handler(serveRepoBuilds)))
+	m.Get(apirouter.RepoBuildTasks).Handler(gzipHandler(handler(serveBuildTasks)))
+	m.Get(apirouter.RepoBuildArtifacts).Handler(handler(serveBuildArtifacts))
+	m.Get(apirouter.RepoBuildArtifact).Handler(gzipHandler(handler(serveBuildArtifactDownload)))
 	m.Get(apirouter.RepoBuildsCreate).Handler(handler(serveRepoBuildsCreate))
+	m.Get(apirouter.RepoBuildsRebuildFailed).Handler(handler(serveRepoBuildsRebuildFailed))
+	m.Get(apirouter.RepoBuildsPrune).Handler(handler(serveRepoBuildsPrune))
+	m.Get(apirouter.RepoBuildsCancelQueued).Handler(handler(serveRepoBuildsCancelQueued))
+	m.Get(apirouter.RepoBuildAudit).Handler(handler(serveRepoBuildAudit))
+	m.Get(apirouter.RepoBuildHook).Handler(handler(serveRepoBuildHook))
+	m.Get(apirouter.RepoBuildsEvents).Handler(handler(serveRepoBuildsEvents))
 	m.Get(apirouter.RepoRefresh).Handler(handler(serveRepoRefresh))
+	m.Get(apirouter.RepoPurge).Handler(handler(serveRepoPurge))
 	m.Get(apirouter.RepoResolveRev).Handler(handler(serveRepoResolveRev))
 	m.Get(apirouter.RepoTags).Handler(handler(serveRepoTags))
 	m.Get(apirouter.Repos).Handler(handler(serveRepos))
@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+)
+
+// Build creation is rate limited per user and per repo so a
+// misbehaving script can't flood the build queue and starve legitimate
+// builds. The per-user limit is deliberately generous so scripted
+// multi-build submissions (e.g. rebuilding a branch across repos)
+// aren't falsely throttled. Set a limit to 0 to disable it.
+var (
+	buildCreateUserLimiter = newRateLimiter(conf.GetenvIntOrDefault("SG_BUILD_CREATE_RATE_LIMIT_PER_USER", 30), time.Minute)
+	buildCreateRepoLimiter = newRateLimiter(conf.GetenvIntOrDefault("SG_BUILD_CREATE_RATE_LIMIT_PER_REPO", 60), time.Minute)
+
+	// Bulk rebuilds can each create up to rebuildFailedMaxBuilds
+	// builds, so their limit is much tighter.
+	rebuildFailedLimiter = newRateLimiter(conf.GetenvIntOrDefault("SG_BUILD_REBUILD_FAILED_RATE_LIMIT_PER_REPO", 2), 10*time.Minute)
+)
+
+// rateLimitExceeded sets the Retry-After header and returns the 429
+// error for a request that exceeded a rate limit.
+func rateLimitExceeded(w http.ResponseWriter, retryAfter time.Duration) error {
+	w.Header().Set("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	return &errcode.HTTPErr{Status: http.StatusTooManyRequests, Err: errors.New("rate limit exceeded")}
+}
+
+// rateLimiter is a simple fixed-window rate limiter keyed by an
+// arbitrary string (a user ID, a repo URI, etc.).
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	n     int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: map[string]*rateWindow{}}
+}
+
+// allow records an event for key and reports whether it is within the
+// rate limit. If not, it also returns how long the caller should wait
+// before retrying.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= l.window {
+		l.prune(now)
+		l.windows[key] = &rateWindow{start: now, n: 1}
+		return true, 0
+	}
+	if w.n >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.n++
+	return true, 0
+}
+
+// prune drops expired windows so the map doesn't grow without bound.
+// The caller must hold l.mu.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatalf("request %d for key a should be allowed", i)
+		}
+	}
+	ok, retryAfter := l.allow("a")
+	if ok {
+		t.Error("third request for key a should be denied")
+	}
+	if retryAfter <= 0 {
+		t.Errorf("got retryAfter %v, want > 0", retryAfter)
+	}
+
+	// Other keys have their own windows.
+	if ok, _ := l.allow("b"); !ok {
+		t.Error("request for key b should be allowed")
+	}
+}
+
+func TestRateLimiter_disabled(t *testing.T) {
+	l := newRateLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.allow("a"); !ok {
+			t.Fatal("disabled limiter should allow everything")
+		}
+	}
+}
+
+func TestRepoBuildsCreate_rateLimited(t *testing.T) {
+	c, mock := newTest()
+
+	orig := buildCreateUserLimiter
+	buildCreateUserLimiter = newRateLimiter(1, time.Minute)
+	defer func() { buildCreateUserLimiter = orig }()
+
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+	mock.Repos.MockGet(t, "r/r")
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		return &sourcegraph.ResolvedRev{CommitID: commitID}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(repoRev.CommitID)}, nil
+	}
+	var createCalls int
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		createCalls++
+		return &sourcegraph.Build{ID: 123, Repo: "r/r", CommitID: commitID}, nil
+	}
+
+	post := func() (*http.Response, error) {
+		req, err := http.NewRequest("POST", "/repos/r/r/-/builds", strings.NewReader(url.Values{"Branch": []string{"b"}}.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return c.Do(req)
+	}
+
+	resp, err := post()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("first create: got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	resp, err = post()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second create: got status %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if resp.Header.Get("retry-after") == "" {
+		t.Error("missing Retry-After header on 429 response")
+	}
+	if createCalls != 1 {
+		t.Errorf("got %d create calls, want 1", createCalls)
+	}
+}
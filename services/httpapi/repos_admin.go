@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/gitserver"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// repoPurgeResult reports what a purge did. Existed is false when the
+// repository had no directory on any gitserver, which is still a
+// success: the purge is idempotent, so re-running it after a partial
+// failure is safe.
+type repoPurgeResult struct {
+	Existed bool
+}
+
+// serveRepoPurge deletes a repository's on-disk directory from the
+// gitserver cluster. It exists for reclaiming disk after a repo has
+// been removed from Sourcegraph itself, whose deletion may have failed
+// to clean up the filesystem. It is operator-only and destructive, so
+// it requires admin access and confirm=true. The path-traversal guards
+// in gitserver apply, so a purge can never delete anything outside the
+// repos dir.
+func serveRepoPurge(w http.ResponseWriter, r *http.Request) error {
+	ctx, _ := handlerutil.Client(r)
+
+	if !auth.ActorFromContext(ctx).HasAdminAccess() {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("admin access required")}
+	}
+	if r.FormValue("confirm") != "true" {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("purge requires confirm=true")}
+	}
+
+	repoSpec, err := getRepoSpec(r)
+	if err != nil {
+		return err
+	}
+
+	existed, err := gitserver.Remove(repoSpec.URI)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, &repoPurgeResult{Existed: existed})
+}
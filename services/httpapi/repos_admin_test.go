@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"net/http"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+)
+
+func TestRepoPurge(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	// No gitserver holds the repo in this test, so the purge is a
+	// successful no-op.
+	var res *repoPurgeResult
+	if err := c.DoJSON("POST", "/repos/r/r/-/purge?confirm=true", nil, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Existed {
+		t.Error("got Existed=true, want false")
+	}
+}
+
+func TestRepoPurge_requiresConfirm(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/purge", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoPurge_requiresAdmin(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1})
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/purge?confirm=true", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -2,16 +2,122 @@ package httpapi
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/rogpeppe/rog-go/parallel"
+	"golang.org/x/net/context"
 
 	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+	apirouter "sourcegraph.com/sourcegraph/sourcegraph/services/httpapi/router"
 )
 
+// buildFilter holds every query parameter the builds list accepts:
+// the BuildListOptions fields the Builds service filters on, the
+// handler's friendlier lowercase aliases, and the filters that can
+// only run handler-side. Decoding and validating them in one place
+// keeps each new filter on the same code path instead of being bolted
+// onto the handler ad hoc.
+type buildFilter struct {
+	opt sourcegraph.BuildListOptions
+
+	// msgPattern is the compiled message=<regex> filter, which runs
+	// against the augmented results in the handler (the Builds
+	// service can't filter by commit message); nil when unset.
+	msgPattern *regexp.Regexp
+
+	// full requests that each build be augmented with its commit.
+	// It is implied by msgPattern.
+	full bool
+}
+
+// decodeBuildFilter decodes and validates the builds list query
+// parameters. Invalid values are rejected with a 400, not silently
+// ignored.
+func decodeBuildFilter(r *http.Request) (*buildFilter, error) {
+	var f buildFilter
+
+	// The lowercase sort alias is handled below, not decoded into
+	// opt.Sort directly.
+	q := r.URL.Query()
+	sortAlias := q.Get("sort")
+	q.Del("sort")
+
+	if err := schemaDecoder.Decode(&f.opt, q); err != nil {
+		return nil, err
+	}
+	clampBuildListPageSize(&f.opt.ListOptions)
+
+	// Friendlier aliases for investigating a specific commit or
+	// branch.
+	if commit := q.Get("commit"); commit != "" {
+		f.opt.CommitID = commit
+	}
+	if branch := q.Get("branch"); branch != "" {
+		f.opt.Branch = branch
+	}
+	// trigger=manual|automated is a friendlier alias for the
+	// Manual/Automated filters, for separating builds a person kicked
+	// off from the ones CI hooks and workers create.
+	switch trigger := q.Get("trigger"); trigger {
+	case "":
+	case "manual":
+		f.opt.Manual = true
+	case "automated":
+		f.opt.Automated = true
+	default:
+		return nil, &errcode.HTTPErr{Status: http.StatusBadRequest, Err: fmt.Errorf("invalid trigger %q (valid: manual, automated)", trigger)}
+	}
+	// sort is a friendlier alias for the Sort/Direction pair: newest
+	// (the default) and oldest order by creation time, for
+	// investigating how a problem developed over time. An explicit
+	// Sort/Direction pair still takes precedence.
+	if f.opt.Sort == "" {
+		switch sortAlias {
+		case "", "newest":
+			f.opt.Sort, f.opt.Direction = "created_at", "desc"
+		case "oldest":
+			f.opt.Sort, f.opt.Direction = "created_at", "asc"
+		default:
+			return nil, &errcode.HTTPErr{Status: http.StatusBadRequest, Err: fmt.Errorf("invalid sort %q (valid: newest, oldest)", sortAlias)}
+		}
+	}
+
+	// message=<regex> filters to builds whose commit message matches
+	// (e.g. "hotfix" or a ticket ID).
+	if msg := q.Get("message"); msg != "" {
+		pattern, err := regexp.Compile(msg)
+		if err != nil {
+			return nil, &errcode.HTTPErr{Status: http.StatusBadRequest, Err: fmt.Errorf("invalid message regexp: %s", err)}
+		}
+		f.msgPattern = pattern
+	}
+
+	f.full = q.Get("full") == "true" || f.msgPattern != nil
+
+	return &f, nil
+}
+
+// listOptions returns the BuildListOptions to pass to Builds.List for
+// repo.
+func (f *buildFilter) listOptions(repo string) *sourcegraph.BuildListOptions {
+	opt := f.opt
+	opt.Repo = repo
+	return &opt
+}
+
 func serveRepoBuilds(w http.ResponseWriter, r *http.Request) error {
 	ctx, cl := handlerutil.Client(r)
 
@@ -20,14 +126,23 @@ func serveRepoBuilds(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	var opt sourcegraph.BuildListOptions
-	err = schemaDecoder.Decode(&opt, r.URL.Query())
-	opt.Repo = repoSpec.URI
+	f, err := decodeBuildFilter(r)
 	if err != nil {
 		return err
 	}
+	opt := f.listOptions(repoSpec.URI)
+
+	if opt.CommitID != "" && len(opt.CommitID) < 40 {
+		// Resolve short SHA prefixes to the full commit ID so the
+		// filter matches builds recorded with the full SHA.
+		res, err := cl.Repos.ResolveRev(ctx, &sourcegraph.ReposResolveRevOp{Repo: *repoSpec, Rev: opt.CommitID})
+		if err != nil {
+			return err
+		}
+		opt.CommitID = res.CommitID
+	}
 
-	builds, err := cl.Builds.List(ctx, &opt)
+	builds, err := cl.Builds.List(ctx, opt)
 	if err != nil {
 		return err
 	}
@@ -36,9 +151,88 @@ func serveRepoBuilds(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	// Tell clients which sort produced this page; the pagination links
+	// derived from the request URL keep the sort parameter, so paging
+	// stays in the same order.
+	w.Header().Set("x-sourcegraph-sort", fmt.Sprintf("%s %s", opt.Sort, opt.Direction))
+
+	writePaginationHeaderStream(w, r.URL, opt.ListOptions, builds.HasMore)
+	if f.full {
+		augmented := fetchCommitsForBuilds(ctx, cl, *repoSpec, builds.Builds)
+		if f.msgPattern != nil {
+			// The filter applies to the current page, after
+			// pagination: a page may come back with fewer (even zero)
+			// matches than PerPage while later pages still have some,
+			// so clients should keep following the pagination links
+			// rather than stop at the first short page.
+			augmented = filterBuildsByCommitMessage(augmented, f.msgPattern)
+		}
+		return writeJSON(w, augmented)
+	}
 	return writeJSON(w, builds)
 }
 
+// filterBuildsByCommitMessage keeps the builds whose commit message
+// matches pattern. Builds whose commit could not be resolved have no
+// message to match and are dropped.
+func filterBuildsByCommitMessage(builds []*buildAndCommit, pattern *regexp.Regexp) []*buildAndCommit {
+	matched := []*buildAndCommit{}
+	for _, b := range builds {
+		if b.Commit != nil && pattern.MatchString(b.Commit.Message) {
+			matched = append(matched, b)
+		}
+	}
+	return matched
+}
+
+// buildAndCommit pairs a build with its resolved commit, for the full
+// builds listing.
+type buildAndCommit struct {
+	Build  *sourcegraph.Build
+	Commit *vcs.Commit `json:",omitempty"`
+}
+
+// fetchCommitsParallelism bounds the concurrent Repos.GetCommit calls
+// made while resolving the commits of a page of builds.
+const fetchCommitsParallelism = 8
+
+// fetchCommitsForBuilds resolves each build's commit, fanning the
+// lookups out over a bounded worker pool and deduplicating repeated
+// commit IDs so multiple builds of the same commit resolve once. The
+// returned slice preserves the order of builds. A commit that can no
+// longer be resolved (e.g. after a force push) is left nil rather
+// than failing the listing.
+func fetchCommitsForBuilds(ctx context.Context, cl *sourcegraph.Client, repo sourcegraph.RepoSpec, builds []*sourcegraph.Build) []*buildAndCommit {
+	var mu sync.Mutex
+	commits := map[string]*vcs.Commit{}
+	seen := map[string]bool{}
+	par := parallel.NewRun(fetchCommitsParallelism)
+	for _, b := range builds {
+		if b.CommitID == "" || seen[b.CommitID] {
+			continue
+		}
+		seen[b.CommitID] = true
+		commitID := b.CommitID
+		par.Do(func() error {
+			commit, err := getCommitCached(ctx, cl, repo, commitID)
+			if err != nil {
+				return nil
+			}
+			mu.Lock()
+			commits[commitID] = commit
+			mu.Unlock()
+			return nil
+		})
+	}
+	par.Wait()
+
+	out := make([]*buildAndCommit, len(builds))
+	for i, b := range builds {
+		out[i] = &buildAndCommit{Build: b, Commit: commits[b.CommitID]}
+	}
+	return out
+}
+
 func serveRepoBuild(w http.ResponseWriter, r *http.Request) error {
 	ctx, cl := handlerutil.Client(r)
 
@@ -47,32 +241,618 @@ func serveRepoBuild(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	build, err := cl.Builds.Get(ctx, buildSpec)
+	build, err := getBuild(ctx, cl, buildSpec)
 	if err != nil {
 		return err
 	}
 
-	return writeJSON(w, build)
+	// The bare Build remains the default response so existing pollers
+	// keep working; full=true returns the computed status, commit,
+	// and task tree in one round trip (e.g. for CLI status displays).
+	if r.URL.Query().Get("full") != "true" {
+		return writeJSON(w, build)
+	}
+
+	detail := &buildDetail{Build: build, Status: buildStatus(build), Host: build.Host}
+	if dur, ok := buildDurationSeconds(build); ok {
+		detail.DurationSeconds = dur
+	}
+
+	// A commit that can no longer be resolved (e.g. after a force
+	// push) just means no commit details; the build itself is still
+	// reported.
+	detail.Commit, _ = getCommitCached(ctx, cl, buildSpec.Repo, build.CommitID)
+
+	// The queue position is recomputed on every request; it changes as
+	// workers dequeue builds, so there is no point caching it. A lookup
+	// failure just omits the position — it is advisory only.
+	if detail.Status == "queued" {
+		detail.QueuePosition, _ = buildQueuePosition(ctx, cl, build)
+	}
+
+	tasks, err := cl.Builds.ListBuildTasks(ctx, &sourcegraph.BuildsListBuildTasksOp{Build: *buildSpec})
+	if err != nil {
+		return err
+	}
+	// Now that the tasks are at hand, report the status they imply
+	// rather than the recorded build flags; see aggregateBuildStatus.
+	detail.Status = aggregateBuildStatus(build, tasks.BuildTasks)
+	detail.Tasks = make([]*buildTaskDetail, len(tasks.BuildTasks))
+	for i, task := range tasks.BuildTasks {
+		td := &buildTaskDetail{Task: task, Status: buildTaskStatus(task)}
+		if logURL, err := apirouter.URL(apirouter.BuildTaskLog, map[string]string{
+			"Repo":  build.Repo,
+			"Build": strconv.FormatUint(build.ID, 10),
+			"Task":  strconv.FormatUint(task.ID, 10),
+		}); err == nil {
+			td.LogURL = logURL.String()
+		}
+		detail.Tasks[i] = td
+	}
+
+	return writeJSON(w, detail)
 }
 
-func serveRepoBuildsCreate(w http.ResponseWriter, r *http.Request) error {
+// serveRepoBuildCompare diffs two builds of a repo — typically the
+// last green build and the first red one — so a regression can be
+// triaged without eyeballing two build pages. The response pairs the
+// overall status and duration of each build with a per-task diff
+// (matched by label) and a link to the git commit range between them.
+func serveRepoBuildCompare(w http.ResponseWriter, r *http.Request) error {
 	ctx, cl := handlerutil.Client(r)
 
-	var op sourcegraph.BuildsCreateOp
-	err := json.NewDecoder(r.Body).Decode(&op)
+	headSpec, err := getBuildSpec(r)
+	if err != nil {
+		return err
+	}
+	baseID, err := strconv.ParseUint(mux.Vars(r)["BaseBuild"], 10, 64)
 	if err != nil {
 		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
 	}
+	baseSpec := &sourcegraph.BuildSpec{Repo: headSpec.Repo, ID: baseID}
+
+	// Fetch each build through Builds.Get so both are authorized
+	// independently.
+	base, err := getBuild(ctx, cl, baseSpec)
+	if err != nil {
+		return err
+	}
+	head, err := getBuild(ctx, cl, headSpec)
+	if err != nil {
+		return err
+	}
+
+	cmp := &buildComparison{
+		Base:       base,
+		Head:       head,
+		BaseStatus: buildStatus(base),
+		HeadStatus: buildStatus(head),
+	}
+	if baseDur, ok := buildDurationSeconds(base); ok {
+		if headDur, ok := buildDurationSeconds(head); ok {
+			cmp.DurationDeltaSeconds = headDur - baseDur
+		}
+	}
+
+	baseTasks, err := cl.Builds.ListBuildTasks(ctx, &sourcegraph.BuildsListBuildTasksOp{Build: *baseSpec})
+	if err != nil {
+		return err
+	}
+	headTasks, err := cl.Builds.ListBuildTasks(ctx, &sourcegraph.BuildsListBuildTasksOp{Build: *headSpec})
+	if err != nil {
+		return err
+	}
+	cmp.Tasks = compareBuildTasks(baseTasks.BuildTasks, headTasks.BuildTasks)
+
+	if base.CommitID != "" && head.CommitID != "" {
+		if u, err := apirouter.URL(apirouter.RepoCommits, map[string]string{"Repo": headSpec.Repo.URI}); err == nil {
+			q := u.Query()
+			q.Set("Base", base.CommitID)
+			q.Set("Head", head.CommitID)
+			u.RawQuery = q.Encode()
+			cmp.CommitRangeURL = u.String()
+		}
+	}
+
+	return writeJSON(w, cmp)
+}
+
+// buildComparison is the response of the build-comparison endpoint.
+type buildComparison struct {
+	Base       *sourcegraph.Build
+	Head       *sourcegraph.Build
+	BaseStatus string
+	HeadStatus string
+
+	// DurationDeltaSeconds is head's duration minus base's; it is
+	// zero unless both builds have ended.
+	DurationDeltaSeconds float64 `json:",omitempty"`
+
+	Tasks []*buildTaskComparison
+
+	// CommitRangeURL links to the commits between the two builds.
+	CommitRangeURL string `json:",omitempty"`
+}
+
+// buildTaskComparison is one row of the task-level diff. Tasks are
+// matched across the two builds by label.
+type buildTaskComparison struct {
+	Label      string
+	BaseStatus string `json:",omitempty"` // empty if the task is new in head
+	HeadStatus string `json:",omitempty"` // empty if the task no longer runs in head
+	Changed    bool
+}
+
+// compareBuildTasks matches tasks by label and reports which outcomes
+// changed. Head's task order is preserved, with base-only tasks
+// appended at the end.
+func compareBuildTasks(base, head []*sourcegraph.BuildTask) []*buildTaskComparison {
+	baseByLabel := make(map[string]*sourcegraph.BuildTask, len(base))
+	for _, t := range base {
+		baseByLabel[t.Label] = t
+	}
+
+	var out []*buildTaskComparison
+	seen := map[string]bool{}
+	for _, t := range head {
+		seen[t.Label] = true
+		tc := &buildTaskComparison{Label: t.Label, HeadStatus: buildTaskStatus(t)}
+		if bt, ok := baseByLabel[t.Label]; ok {
+			tc.BaseStatus = buildTaskStatus(bt)
+		}
+		tc.Changed = tc.BaseStatus != tc.HeadStatus
+		out = append(out, tc)
+	}
+	for _, t := range base {
+		if seen[t.Label] {
+			continue
+		}
+		out = append(out, &buildTaskComparison{Label: t.Label, BaseStatus: buildTaskStatus(t), Changed: true})
+	}
+	return out
+}
+
+// buildDurationSeconds reports how long a build took, if it has both
+// started and ended.
+func buildDurationSeconds(b *sourcegraph.Build) (float64, bool) {
+	if b.StartedAt == nil || b.EndedAt == nil {
+		return 0, false
+	}
+	return b.EndedAt.Time().Sub(b.StartedAt.Time()).Seconds(), true
+}
+
+// buildDetail is the full=true response of the single-build endpoint:
+// the build joined with its computed status, duration, commit, and
+// task tree, so clients needn't make a request per task.
+type buildDetail struct {
+	Build           *sourcegraph.Build
+	Status          string
+	Host            string      `json:",omitempty"` // worker host that ran the build
+	DurationSeconds float64     `json:",omitempty"`
+	Commit          *vcs.Commit `json:",omitempty"`
+	QueuePosition   int         `json:",omitempty"` // 1-based position among queued builds
+	Tasks           []*buildTaskDetail
+}
+
+type buildTaskDetail struct {
+	Task   *sourcegraph.BuildTask
+	Status string
+	LogURL string `json:",omitempty"`
+}
+
+// queuePositionMaxScan bounds how many queued builds are examined when
+// computing a build's queue position. A build deeper in the queue than
+// this reports no position, which is fine — "somewhere past 1000" is
+// not actionable anyway.
+const queuePositionMaxScan = 1000
+
+// buildQueuePosition returns the 1-based position of build among all
+// queued builds, ordered by creation time (the order workers dequeue
+// them in). It returns 0 if the build is not found within the first
+// queuePositionMaxScan queued builds, e.g. because a worker picked it
+// up between the Get and the List.
+func buildQueuePosition(ctx context.Context, cl *sourcegraph.Client, build *sourcegraph.Build) (int, error) {
+	pos := 0
+	for page := int32(1); pos < queuePositionMaxScan; page++ {
+		queued, err := cl.Builds.List(ctx, &sourcegraph.BuildListOptions{
+			Queued:      true,
+			Sort:        "created_at",
+			Direction:   "asc",
+			ListOptions: sourcegraph.ListOptions{Page: page, PerPage: 100},
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, b := range queued.Builds {
+			pos++
+			if b.ID == build.ID && b.Repo == build.Repo {
+				return pos, nil
+			}
+		}
+		if len(queued.Builds) < 100 {
+			break
+		}
+	}
+	return 0, nil
+}
+
+// buildStatus reports the status recorded on the Build row itself. It
+// is the right choice when the build's tasks are not at hand (e.g.
+// list views). When they are, prefer aggregateBuildStatus, which
+// catches builds whose recorded flags diverge from what their tasks
+// actually did.
+func buildStatus(b *sourcegraph.Build) string {
+	switch {
+	case b.Purged:
+		return "purged"
+	case b.Killed:
+		return "killed"
+	case b.Failure:
+		return "failed"
+	case b.Success:
+		return "succeeded"
+	case b.StartedAt != nil && b.EndedAt == nil:
+		return "active"
+	case b.Queue:
+		return "queued"
+	default:
+		return "created"
+	}
+}
+
+func buildTaskStatus(t *sourcegraph.BuildTask) string {
+	switch {
+	case t.Skipped:
+		return "skipped"
+	case t.Failure:
+		return "failed"
+	case t.Success && t.Warnings:
+		return "warnings"
+	case t.Success:
+		return "succeeded"
+	case t.StartedAt != nil && t.EndedAt == nil:
+		return "active"
+	default:
+		return "pending"
+	}
+}
+
+// aggregateBuildStatus derives a build's effective status from its
+// tasks. The flags on the Build row can diverge from the tasks — e.g.
+// a worker that recorded overall success even though a subtask
+// failed, or died before writing the final state — and the tasks are
+// the ground truth. Any failed task makes the build failed; any
+// still-running task makes it active; if every task is done (skipped
+// counts as done), the build succeeded. Purged and killed are
+// administrative states the tasks can't see, so they, a build with
+// no tasks, and a build whose tasks haven't started yet all fall back
+// to buildStatus.
+func aggregateBuildStatus(b *sourcegraph.Build, tasks []*sourcegraph.BuildTask) string {
+	if b.Purged || b.Killed || len(tasks) == 0 {
+		return buildStatus(b)
+	}
+	anyActive := false
+	allDone := true
+	for _, t := range tasks {
+		switch buildTaskStatus(t) {
+		case "failed":
+			return "failed"
+		case "active":
+			anyActive = true
+		case "pending":
+			allDone = false
+		}
+	}
+	if anyActive {
+		return "active"
+	}
+	if allDone {
+		return "succeeded"
+	}
+	// Tasks exist but none have started, finished, or failed; the
+	// build-level flags (queued, created) describe that best.
+	return buildStatus(b)
+}
+
+// rebuildFailedMaxBuilds caps how many builds one bulk-rebuild
+// request will re-queue, so a repo with months of failures can't
+// flood the queue in one call.
+const rebuildFailedMaxBuilds = 100
+
+// serveRepoBuildsRebuildFailed re-queues every build of a repo that
+// is currently in "failed" status, optionally limited to builds
+// created within a recent time window (?since=24h). It exists for
+// recovering from transient infrastructure failures that took down a
+// batch of builds. The caller must pass confirm=true, and the action
+// is rate limited per repo.
+func serveRepoBuildsRebuildFailed(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
 
 	_, repoSpec, err := handlerutil.GetRepo(ctx, mux.Vars(r))
 	if err != nil {
 		return err
 	}
 
+	if r.FormValue("confirm") != "true" {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("bulk rebuild requires confirm=true")}
+	}
+	var since time.Duration
+	if s := r.FormValue("since"); s != "" {
+		since, err = time.ParseDuration(s)
+		if err != nil {
+			return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+		}
+	}
+
+	if ok, retryAfter := rebuildFailedLimiter.allow(repoSpec.URI); !ok {
+		return rateLimitExceeded(w, retryAfter)
+	}
+
+	builds, err := cl.Builds.List(ctx, &sourcegraph.BuildListOptions{
+		Repo:        repoSpec.URI,
+		Failed:      true,
+		Sort:        "created_at",
+		Direction:   "desc",
+		ListOptions: sourcegraph.ListOptions{PerPage: rebuildFailedMaxBuilds},
+	})
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Time{}
+	if since > 0 {
+		cutoff = time.Now().Add(-since)
+	}
+
+	summary := &rebuildFailedSummary{}
+	for _, b := range builds.Builds {
+		if buildStatus(b) != "failed" || b.CreatedAt.Time().Before(cutoff) {
+			continue
+		}
+		summary.Considered++
+		build, err := cl.Builds.Create(ctx, &sourcegraph.BuildsCreateOp{
+			Repo:     repoSpec,
+			CommitID: b.CommitID,
+			Branch:   b.Branch,
+			Tag:      b.Tag,
+			Config:   sourcegraph.BuildConfig{Queue: true, Priority: b.Priority},
+		})
+		if err != nil {
+			// Keep going; one unbuildable commit (e.g. force-pushed
+			// away) shouldn't abort the rest of the batch.
+			summary.Failed = append(summary.Failed, b.ID)
+			continue
+		}
+		buildAudit.record(ctx, build.Spec(), "requeued", b.Spec().IDString())
+		summary.Requeued = append(summary.Requeued, build.ID)
+	}
+
+	return writeJSON(w, summary)
+}
+
+// rebuildFailedSummary reports what a bulk rebuild did.
+type rebuildFailedSummary struct {
+	// Considered is how many failed builds matched the filters.
+	Considered int
+	// Requeued lists the IDs of the newly created builds.
+	Requeued []uint64
+	// Failed lists the IDs of the failed builds that could not be
+	// re-queued.
+	Failed []uint64 `json:",omitempty"`
+}
+
+// serveRepoBuildRestart creates a new build for the same commit as an
+// existing build, restarting from the task named by ?task=<ID>. The
+// Builds service has no task-reuse support, so the tasks before the
+// restart point that succeeded are recorded as reusable in the new
+// build's config (as comment lines, which the worker ignores) and
+// reported in the response; a worker that learns to reuse task
+// results can honor them later. Until then this is a full re-run that
+// documents which work was already known good.
+func serveRepoBuildRestart(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	buildSpec, err := getBuildSpec(r)
+	if err != nil {
+		return err
+	}
+	taskID, err := strconv.ParseUint(r.FormValue("task"), 10, 64)
+	if err != nil {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("restart requires a task=<ID> parameter")}
+	}
+
+	build, err := getBuild(ctx, cl, buildSpec)
+	if err != nil {
+		return err
+	}
+	tasks, err := cl.Builds.ListBuildTasks(ctx, &sourcegraph.BuildsListBuildTasksOp{Build: *buildSpec})
+	if err != nil {
+		return err
+	}
+
+	// Tasks are listed in execution order; everything before the
+	// restart point that succeeded is reusable.
+	var reusable []string
+	found := false
+	for _, task := range tasks.BuildTasks {
+		if task.ID == taskID {
+			found = true
+			break
+		}
+		if task.Success {
+			reusable = append(reusable, task.Label)
+		}
+	}
+	if !found {
+		return &errcode.HTTPErr{Status: http.StatusNotFound, Err: fmt.Errorf("build %d has no task %d", buildSpec.ID, taskID)}
+	}
+
+	intent := fmt.Sprintf("# restart-of: build %d from task %d\n", build.ID, taskID)
+	if len(reusable) > 0 {
+		intent += fmt.Sprintf("# reusable-tasks: %s\n", strings.Join(reusable, ", "))
+	}
+	newBuild, err := cl.Builds.Create(ctx, &sourcegraph.BuildsCreateOp{
+		Repo:     buildSpec.Repo,
+		CommitID: build.CommitID,
+		Branch:   build.Branch,
+		Tag:      build.Tag,
+		Config: sourcegraph.BuildConfig{
+			Queue:         true,
+			Priority:      build.Priority,
+			BuilderConfig: intent + build.BuilderConfig,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	buildAudit.record(ctx, newBuild.Spec(), "restarted", buildSpec.IDString())
+	return writeJSON(w, &buildRestart{Build: newBuild, ReusableTasks: reusable})
+}
+
+// buildRestart is the response of the build restart endpoint.
+type buildRestart struct {
+	Build *sourcegraph.Build
+	// ReusableTasks lists the labels of the original build's tasks
+	// that had already succeeded before the restart point.
+	ReusableTasks []string `json:",omitempty"`
+}
+
+func serveRepoBuildsCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	var op sourcegraph.BuildsCreateOp
+	if ct := r.Header.Get("Content-Type"); strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "multipart/form-data") {
+		// The create-build form posts its fields directly (e.g.
+		// Branch, Tag, Config.Priority) instead of a JSON body.
+		if err := r.ParseForm(); err != nil {
+			return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+		}
+		if err := schemaDecoder.Decode(&op, r.PostForm); err != nil {
+			return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+		}
+		// The form's common case is enqueueing a build, so queue
+		// unless it says otherwise.
+		if _, ok := r.PostForm["Config.Queue"]; !ok {
+			op.Config.Queue = true
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+	}
+
+	if op.Branch != "" && op.Tag != "" {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("build may specify either a branch or a tag, not both")}
+	}
+
+	// dryRun=true runs the full validation below but stops short of
+	// creating the build, returning the build op as it would be
+	// submitted. Useful for CI scripts that want to check a ref and
+	// their parameters before committing to a build.
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	repo, repoSpec, err := handlerutil.GetRepo(ctx, mux.Vars(r))
+	if err != nil {
+		return err
+	}
 	op.Repo = repoSpec
+
+	// Throttle build creation per user and per repo so a runaway
+	// script can't flood the build queue. Dry runs don't enqueue
+	// anything, so they don't count against the budget.
+	if !dryRun {
+		actorKey := strconv.Itoa(auth.ActorFromContext(ctx).UID)
+		if ok, retryAfter := buildCreateUserLimiter.allow(actorKey); !ok {
+			return rateLimitExceeded(w, retryAfter)
+		}
+		if ok, retryAfter := buildCreateRepoLimiter.allow(repoSpec.URI); !ok {
+			return rateLimitExceeded(w, retryAfter)
+		}
+	}
+
+	// Resolve and validate the requested ref before creating the
+	// build, so a mistyped branch name comes back as a client error
+	// instead of a failure deep inside the build system.
+	rev := op.CommitID
+	if rev == "" {
+		rev = op.Branch
+	}
+	if rev == "" {
+		rev = op.Tag
+	}
+	if rev == "" {
+		// No explicit ref: build the repo's actual default branch
+		// (which may be "main" or "trunk", not "master") instead of
+		// rejecting the request or guessing.
+		if repo.DefaultBranch == "" {
+			return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("no commit, branch, or tag to build, and the repository has no default branch")}
+		}
+		op.Branch = repo.DefaultBranch
+		rev = op.Branch
+	}
+	res, err := cl.Repos.ResolveRev(ctx, &sourcegraph.ReposResolveRevOp{Repo: repoSpec, Rev: rev})
+	if err != nil {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+	}
+	if _, err := cl.Repos.GetCommit(ctx, &sourcegraph.RepoRevSpec{RepoSpec: repoSpec, CommitID: res.CommitID}); err != nil {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+	}
+	op.CommitID = res.CommitID
+
+	if dryRun {
+		return writeJSON(w, &op)
+	}
+
+	// dedupe=true returns an already queued or running build for the
+	// same commit and config instead of creating another one — webhook
+	// triggers often fire several events for one push. The caller can
+	// tell the two apart by the x-sourcegraph-deduplicated header. The
+	// check is best-effort: if the lookup fails, the build is created
+	// normally, since a duplicate build is better than no build.
+	if r.URL.Query().Get("dedupe") == "true" {
+		if existing, err := findDuplicateBuild(ctx, cl, &op); err == nil && existing != nil {
+			w.Header().Set("x-sourcegraph-deduplicated", "true")
+			return writeJSON(w, existing)
+		}
+	}
+
 	build, err := cl.Builds.Create(ctx, &op)
 	if err != nil {
 		return err
 	}
+	buildAudit.record(ctx, build.Spec(), "created", "")
 	return writeJSON(w, build)
 }
+
+// buildDedupWindow bounds how old a queued or running build may be and
+// still suppress a dedupe=true creation for the same commit. Without
+// the window, a stuck queued build would swallow every retriggered
+// build of its commit forever. Zero disables deduplication entirely.
+var buildDedupWindow = conf.GetenvDurationOrDefault("SG_BUILD_CREATE_DEDUP_WINDOW", "10m")
+
+// findDuplicateBuild returns a build of op's commit with the same
+// branch, tag, and config that is already queued or running and was
+// created within buildDedupWindow, or nil if there is none.
+func findDuplicateBuild(ctx context.Context, cl *sourcegraph.Client, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+	if buildDedupWindow <= 0 {
+		return nil, nil
+	}
+	for _, state := range []sourcegraph.BuildListOptions{{Queued: true}, {Active: true}} {
+		opt := state
+		opt.Repo = op.Repo.URI
+		opt.CommitID = op.CommitID
+		opt.ListOptions = sourcegraph.ListOptions{PerPage: buildListMaxPerPage}
+		builds, err := cl.Builds.List(ctx, &opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range builds.Builds {
+			if b.Branch != op.Branch || b.Tag != op.Tag || b.BuildConfig != op.Config {
+				continue
+			}
+			if time.Since(b.CreatedAt.Time()) > buildDedupWindow {
+				continue
+			}
+			return b, nil
+		}
+	}
+	return nil, nil
+}
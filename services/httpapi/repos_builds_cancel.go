@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// cancelQueuedMaxBuilds bounds how many queued builds one invocation
+// cancels; re-run the action to drain a larger backlog.
+const cancelQueuedMaxBuilds = 500
+
+// cancelQueuedSummary reports what a bulk cancellation did.
+type cancelQueuedSummary struct {
+	// Cancelled lists the IDs of the builds marked killed.
+	Cancelled []uint64
+	// Failed lists queued builds whose update failed.
+	Failed []uint64 `json:",omitempty"`
+}
+
+// serveRepoBuildsCancelQueued cancels every queued build of a repo at
+// once, for clearing a flooded build backlog during an incident. Only
+// builds still waiting in the queue are touched: anything already
+// running is left to finish (or be dealt with individually). Each
+// cancellation is a kill update through the Builds service, so it
+// lands in the build's audit trail. The action is operator-only and
+// requires confirm=true.
+func serveRepoBuildsCancelQueued(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	if !auth.ActorFromContext(ctx).HasAdminAccess() {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("admin access required")}
+	}
+	if r.FormValue("confirm") != "true" {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("bulk cancellation requires confirm=true")}
+	}
+
+	repoSpec, err := getRepoSpec(r)
+	if err != nil {
+		return err
+	}
+
+	builds, err := cl.Builds.List(ctx, &sourcegraph.BuildListOptions{
+		Repo:        repoSpec.URI,
+		Queued:      true,
+		ListOptions: sourcegraph.ListOptions{PerPage: cancelQueuedMaxBuilds},
+	})
+	if err != nil {
+		return err
+	}
+
+	summary := &cancelQueuedSummary{Cancelled: []uint64{}}
+	for _, b := range builds.Builds {
+		// Double-check the status: the list filter is server-side,
+		// but a build may have started (or ended) since.
+		if buildStatus(b) != "queued" {
+			continue
+		}
+		if _, err := cl.Builds.Update(ctx, &sourcegraph.BuildsUpdateOp{
+			Build: b.Spec(),
+			Info:  sourcegraph.BuildUpdate{Killed: true},
+		}); err != nil {
+			// Keep going; one failed update shouldn't abort the
+			// rest of the batch.
+			summary.Failed = append(summary.Failed, b.ID)
+			continue
+		}
+		buildAudit.record(ctx, b.Spec(), "cancelled", "")
+		summary.Cancelled = append(summary.Cancelled, b.ID)
+	}
+
+	return writeJSON(w, summary)
+}
@@ -0,0 +1,90 @@
+package httpapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sqs/pbtypes"
+)
+
+func TestRepoBuildsCancelQueued(t *testing.T) {
+	c, mock := newTest()
+	resetBuildAudit()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	started := pbtypes.NewTimestamp(time.Now())
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		if !opt.Queued {
+			t.Error("expected Queued filter")
+		}
+		return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+			{ID: 1, Repo: "r/r", BuildConfig: sourcegraph.BuildConfig{Queue: true}},
+			{ID: 2, Repo: "r/r", BuildConfig: sourcegraph.BuildConfig{Queue: true}},
+			// Started since the queue listing; must be left alone.
+			{ID: 3, Repo: "r/r", BuildConfig: sourcegraph.BuildConfig{Queue: true}, StartedAt: &started},
+		}}, nil
+	}
+	var killed []uint64
+	mock.Builds.Update_ = func(ctx context.Context, op *sourcegraph.BuildsUpdateOp) (*sourcegraph.Build, error) {
+		if !op.Info.Killed {
+			t.Errorf("got update %+v for build #%d, want Killed", op.Info, op.Build.ID)
+		}
+		killed = append(killed, op.Build.ID)
+		return &sourcegraph.Build{ID: op.Build.ID, Repo: "r/r", Killed: true}, nil
+	}
+
+	var summary *cancelQueuedSummary
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds/cancel-queued?confirm=true", nil, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 2}; !reflect.DeepEqual(killed, want) {
+		t.Errorf("killed builds %v, want %v", killed, want)
+	}
+	if !reflect.DeepEqual(summary.Cancelled, killed) {
+		t.Errorf("got Cancelled %v, want %v", summary.Cancelled, killed)
+	}
+
+	events := buildAudit.trail(sourcegraph.BuildSpec{Repo: sourcegraph.RepoSpec{URI: "r/r"}, ID: 1})
+	if len(events) != 1 || events[0].Action != "cancelled" {
+		t.Errorf("got audit trail %+v, want one cancelled event", events)
+	}
+}
+
+func TestRepoBuildsCancelQueued_requiresConfirm(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	mock.Builds.Update_ = func(ctx context.Context, op *sourcegraph.BuildsUpdateOp) (*sourcegraph.Build, error) {
+		t.Error("build cancelled without confirmation")
+		return nil, nil
+	}
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds/cancel-queued", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuildsCancelQueued_requiresAdmin(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1})
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds/cancel-queued?confirm=true", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
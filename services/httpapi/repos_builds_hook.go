@@ -0,0 +1,173 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/conf"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// The build hook lets external systems (deploy pipelines, mirroring
+// scripts, ...) trigger a build for a commit by POSTing a signed JSON
+// payload, without a browser session. It is authenticated by an
+// HMAC-SHA256 signature over the raw request body using a
+// deployment-wide shared secret; replays of a captured request are
+// rejected via a timestamp window plus a nonce that must be unique
+// within it.
+
+var (
+	// buildHookSecret is the shared secret the payload signature is
+	// verified against. The hook is disabled while it is unset.
+	buildHookSecret = conf.GetenvOrDefault("SG_BUILD_HOOK_SECRET", "")
+
+	// buildHookMaxClockSkew bounds how far a payload's timestamp may
+	// deviate from the server clock. It is also how long nonces are
+	// remembered: outside the window the timestamp check alone
+	// rejects a replay.
+	buildHookMaxClockSkew = conf.GetenvDurationOrDefault("SG_BUILD_HOOK_MAX_CLOCK_SKEW", "5m")
+)
+
+// buildHookSignatureHeader carries the lowercase hex HMAC-SHA256 of
+// the raw request body.
+const buildHookSignatureHeader = "x-sourcegraph-build-hook-signature"
+
+// buildHookMaxPayloadSize bounds the hook's request body; real
+// payloads are a few hundred bytes.
+const buildHookMaxPayloadSize = 1 << 20
+
+// buildHookPayload is the signed JSON body of a build hook request.
+type buildHookPayload struct {
+	// Repo must match the repo in the request URL, so a payload
+	// signed for one repo can't be replayed against another.
+	Repo string
+
+	// CommitID is the commit to build. Branch, if set, associates the
+	// build with that branch (see Build.Branch for why that matters).
+	CommitID string
+	Branch   string `json:",omitempty"`
+
+	// Timestamp is the Unix time (seconds) the payload was signed; it
+	// must be within buildHookMaxClockSkew of the server clock.
+	Timestamp int64
+
+	// Nonce must be unique per request within the timestamp window.
+	Nonce string
+}
+
+// buildHookNonces remembers recently seen nonces to reject replayed
+// payloads. Entries only need to outlive the timestamp window, so the
+// map stays small and in-memory suffices (a replay against another
+// frontend instance within the window is additionally bounded by the
+// timestamp check).
+var buildHookNonces = &nonceCache{seen: map[string]time.Time{}}
+
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // nonce -> expiry
+}
+
+// remember records nonce and reports whether it was new. Expired
+// entries are pruned as a side effect.
+func (c *nonceCache) remember(nonce string, now time.Time, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n, expiry := range c.seen {
+		if now.After(expiry) {
+			delete(c.seen, n)
+		}
+	}
+	if _, seen := c.seen[nonce]; seen {
+		return false
+	}
+	c.seen[nonce] = now.Add(ttl)
+	return true
+}
+
+func serveRepoBuildHook(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	if buildHookSecret == "" {
+		return &errcode.HTTPErr{Status: http.StatusNotFound, Err: errors.New("build hook is not enabled (SG_BUILD_HOOK_SECRET is unset)")}
+	}
+
+	repoSpec, err := getRepoSpec(r)
+	if err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, buildHookMaxPayloadSize))
+	if err != nil {
+		return err
+	}
+
+	// Verify the signature before trusting anything in the body.
+	mac := hmac.New(sha256.New, []byte(buildHookSecret))
+	mac.Write(body)
+	sig, err := hex.DecodeString(r.Header.Get(buildHookSignatureHeader))
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("invalid build hook signature")}
+	}
+
+	var payload buildHookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+	}
+	if payload.Repo != repoSpec.URI {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: fmt.Errorf("payload is for repo %q, not %q", payload.Repo, repoSpec.URI)}
+	}
+	if payload.CommitID == "" {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("no commit to build")}
+	}
+
+	now := time.Now()
+	if ts := time.Unix(payload.Timestamp, 0); ts.Before(now.Add(-buildHookMaxClockSkew)) || ts.After(now.Add(buildHookMaxClockSkew)) {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("stale or future-dated build hook payload")}
+	}
+	if payload.Nonce == "" {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("missing nonce")}
+	}
+	if !buildHookNonces.remember(payload.Nonce, now, 2*buildHookMaxClockSkew) {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("replayed build hook payload")}
+	}
+
+	// Validate the commit exists (and resolve any abbreviation)
+	// before creating the build.
+	commit, err := cl.Repos.GetCommit(ctx, &sourcegraph.RepoRevSpec{RepoSpec: *repoSpec, CommitID: payload.CommitID})
+	if err != nil {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: err}
+	}
+
+	// The signature proves possession of the deployment secret, which
+	// authorizes build creation regardless of who (if anyone) is
+	// logged in; the hook is typically called without a session.
+	ctx = auth.WithActor(ctx, auth.Actor{Scope: map[string]bool{"internal:build-hook": true}})
+
+	build, err := cl.Builds.Create(ctx, &sourcegraph.BuildsCreateOp{
+		Repo:     *repoSpec,
+		CommitID: string(commit.ID),
+		Branch:   payload.Branch,
+		Config:   sourcegraph.BuildConfig{Queue: true},
+	})
+	if err != nil {
+		return err
+	}
+	buildAudit.record(ctx, build.Spec(), "created", "")
+
+	return writeJSON(w, &struct {
+		ID   uint64
+		Repo string
+	}{ID: build.ID, Repo: build.Repo})
+}
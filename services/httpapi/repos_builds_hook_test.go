@@ -0,0 +1,175 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/httptestutil"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+)
+
+const testBuildHookSecret = "hunter2"
+
+// postBuildHook signs payload with secret and POSTs it to the build
+// hook endpoint for repo r/r, returning the response.
+func postBuildHook(t *testing.T, c *httptestutil.Client, payload *buildHookPayload, secret string) *http.Response {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	req, err := http.NewRequest("POST", "/repos/r/r/-/builds/hook", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(buildHookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func testBuildHookPayload() *buildHookPayload {
+	return &buildHookPayload{
+		Repo:      "r/r",
+		CommitID:  "cccccccccccccccccccccccccccccccccccccccc",
+		Timestamp: time.Now().Unix(),
+		Nonce:     "nonce-1",
+	}
+}
+
+func TestRepoBuildHook(t *testing.T) {
+	c, mock := newTest()
+	defer func(secret string) { buildHookSecret = secret }(buildHookSecret)
+	buildHookSecret = testBuildHookSecret
+	buildHookNonces = &nonceCache{seen: map[string]time.Time{}}
+
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+
+	var calledGetCommit bool
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		calledGetCommit = true
+		if repoRev.CommitID != commitID {
+			t.Errorf("got CommitID %q, want %q", repoRev.CommitID, commitID)
+		}
+		return &vcs.Commit{ID: vcs.CommitID(commitID)}, nil
+	}
+	var calledCreate bool
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		calledCreate = true
+		if op.CommitID != commitID {
+			t.Errorf("got CommitID %q, want %q", op.CommitID, commitID)
+		}
+		if !op.Config.Queue {
+			t.Error("build not queued")
+		}
+		if !auth.ActorFromContext(ctx).HasScope("internal:build-hook") {
+			t.Error("actor does not have the internal:build-hook scope")
+		}
+		return &sourcegraph.Build{ID: 123, Repo: "r/r", CommitID: commitID}, nil
+	}
+
+	resp := postBuildHook(t, c, testBuildHookPayload(), testBuildHookSecret)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var created struct {
+		ID   uint64
+		Repo string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != 123 || created.Repo != "r/r" {
+		t.Errorf("got build %+v, want ID 123 in repo r/r", created)
+	}
+	if !calledGetCommit {
+		t.Error("!calledGetCommit")
+	}
+	if !calledCreate {
+		t.Error("!calledCreate")
+	}
+
+	// Replaying the same payload (same nonce) must be rejected.
+	calledCreate = false
+	if resp := postBuildHook(t, c, testBuildHookPayload(), testBuildHookSecret); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("replay: got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if calledCreate {
+		t.Error("replay created a build")
+	}
+}
+
+func TestRepoBuildHook_badSignature(t *testing.T) {
+	c, mock := newTest()
+	defer func(secret string) { buildHookSecret = secret }(buildHookSecret)
+	buildHookSecret = testBuildHookSecret
+	buildHookNonces = &nonceCache{seen: map[string]time.Time{}}
+
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("created a build despite bad signature")
+		return &sourcegraph.Build{}, nil
+	}
+
+	if resp := postBuildHook(t, c, testBuildHookPayload(), "wrong-secret"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestRepoBuildHook_staleTimestamp(t *testing.T) {
+	c, mock := newTest()
+	defer func(secret string) { buildHookSecret = secret }(buildHookSecret)
+	buildHookSecret = testBuildHookSecret
+	buildHookNonces = &nonceCache{seen: map[string]time.Time{}}
+
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("created a build despite stale timestamp")
+		return &sourcegraph.Build{}, nil
+	}
+
+	payload := testBuildHookPayload()
+	payload.Timestamp = time.Now().Add(-buildHookMaxClockSkew - time.Minute).Unix()
+	if resp := postBuildHook(t, c, payload, testBuildHookSecret); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestRepoBuildHook_repoMismatch(t *testing.T) {
+	c, mock := newTest()
+	defer func(secret string) { buildHookSecret = secret }(buildHookSecret)
+	buildHookSecret = testBuildHookSecret
+	buildHookNonces = &nonceCache{seen: map[string]time.Time{}}
+
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("created a build despite repo mismatch")
+		return &sourcegraph.Build{}, nil
+	}
+
+	payload := testBuildHookPayload()
+	payload.Repo = "other/repo"
+	if resp := postBuildHook(t, c, payload, testBuildHookSecret); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuildHook_disabled(t *testing.T) {
+	c, _ := newTest()
+	defer func(secret string) { buildHookSecret = secret }(buildHookSecret)
+	buildHookSecret = ""
+
+	if resp := postBuildHook(t, c, testBuildHookPayload(), "any"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,130 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/errcode"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/handlerutil"
+)
+
+// buildsPruneMaxBuilds bounds how many builds one prune invocation
+// examines, so a repo with years of history can't tie up the request.
+// Pruning is idempotent (purged builds are not considered again), so
+// re-running it continues where the last run left off.
+const buildsPruneMaxBuilds = 500
+
+// buildsPruneSummary reports what a prune did (or, for a dry run,
+// would do).
+type buildsPruneSummary struct {
+	// DryRun is true when confirm=true was not passed; Pruned then
+	// lists the builds that would have been pruned.
+	DryRun bool `json:",omitempty"`
+	// Considered is how many builds matched the retention filters.
+	Considered int
+	// Pruned lists the IDs of the purged builds.
+	Pruned []uint64
+	// InProgress lists builds that matched the filters but were
+	// skipped because they are queued or running.
+	InProgress []uint64 `json:",omitempty"`
+	// Failed lists builds whose purge update failed.
+	Failed []uint64 `json:",omitempty"`
+}
+
+// serveRepoBuildsPrune deletes old builds of a repo to reclaim build
+// storage. The retention policy comes from the query: age=<duration>
+// prunes builds created longer ago than that, keep=<N> prunes builds
+// beyond the N most recent, and passing both prunes only builds
+// outside both limits. At least one is required. Deletion goes
+// through the Builds service as a purge update, which is what makes
+// the build's logs and artifacts unreachable; in-progress (queued or
+// running) builds are never pruned. Without confirm=true the handler
+// is a dry run that reports what would be pruned.
+func serveRepoBuildsPrune(w http.ResponseWriter, r *http.Request) error {
+	ctx, cl := handlerutil.Client(r)
+
+	if !auth.ActorFromContext(ctx).HasAdminAccess() {
+		return &errcode.HTTPErr{Status: http.StatusForbidden, Err: errors.New("admin access required")}
+	}
+
+	repoSpec, err := getRepoSpec(r)
+	if err != nil {
+		return err
+	}
+
+	var age time.Duration
+	if s := r.FormValue("age"); s != "" {
+		age, err = time.ParseDuration(s)
+		if err != nil || age <= 0 {
+			return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("invalid age: " + s)}
+		}
+	}
+	keep := -1
+	if s := r.FormValue("keep"); s != "" {
+		keep, err = strconv.Atoi(s)
+		if err != nil || keep < 0 {
+			return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("invalid keep: " + s)}
+		}
+	}
+	if age == 0 && keep == -1 {
+		return &errcode.HTTPErr{Status: http.StatusBadRequest, Err: errors.New("prune requires age=<duration> and/or keep=<N>")}
+	}
+	dryRun := r.FormValue("confirm") != "true"
+
+	builds, err := cl.Builds.List(ctx, &sourcegraph.BuildListOptions{
+		Repo:        repoSpec.URI,
+		Sort:        "created_at",
+		Direction:   "desc",
+		ListOptions: sourcegraph.ListOptions{PerPage: buildsPruneMaxBuilds},
+	})
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Time{}
+	if age > 0 {
+		cutoff = time.Now().Add(-age)
+	}
+
+	summary := &buildsPruneSummary{DryRun: dryRun}
+	seen := 0 // retained (non-purged) builds, newest first
+	for _, b := range builds.Builds {
+		status := buildStatus(b)
+		if status == "purged" {
+			continue
+		}
+		seen++
+		if keep != -1 && seen <= keep {
+			continue
+		}
+		if age > 0 && !b.CreatedAt.Time().Before(cutoff) {
+			continue
+		}
+		summary.Considered++
+		if status == "active" || status == "queued" {
+			summary.InProgress = append(summary.InProgress, b.ID)
+			continue
+		}
+		if dryRun {
+			summary.Pruned = append(summary.Pruned, b.ID)
+			continue
+		}
+		if _, err := cl.Builds.Update(ctx, &sourcegraph.BuildsUpdateOp{
+			Build: b.Spec(),
+			Info:  sourcegraph.BuildUpdate{Purged: true},
+		}); err != nil {
+			// Keep going; one failed update shouldn't abort the
+			// rest of the batch.
+			summary.Failed = append(summary.Failed, b.ID)
+			continue
+		}
+		buildAudit.record(ctx, b.Spec(), "purged", "")
+		summary.Pruned = append(summary.Pruned, b.ID)
+	}
+
+	return writeJSON(w, summary)
+}
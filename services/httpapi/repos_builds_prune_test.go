@@ -0,0 +1,149 @@
+package httpapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/auth"
+	"sourcegraph.com/sqs/pbtypes"
+)
+
+// pruneTestBuilds returns a List mock with four builds, newest first:
+// an active build, a recent success, an old success, and an
+// already-purged build.
+func pruneTestBuilds(now time.Time) []*sourcegraph.Build {
+	recent := pbtypes.NewTimestamp(now.Add(-time.Hour))
+	old := pbtypes.NewTimestamp(now.Add(-30 * 24 * time.Hour))
+	started := pbtypes.NewTimestamp(now)
+	return []*sourcegraph.Build{
+		{ID: 4, Repo: "r/r", CreatedAt: recent, StartedAt: &started},
+		{ID: 3, Repo: "r/r", CreatedAt: recent, Success: true},
+		{ID: 2, Repo: "r/r", CreatedAt: old, Success: true},
+		{ID: 1, Repo: "r/r", CreatedAt: old, Success: true, Purged: true},
+	}
+}
+
+func TestRepoBuildsPrune(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		if opt.Sort != "created_at" || opt.Direction != "desc" {
+			t.Errorf("got sort %q %q, want created_at desc", opt.Sort, opt.Direction)
+		}
+		return &sourcegraph.BuildList{Builds: pruneTestBuilds(time.Now())}, nil
+	}
+	var purged []uint64
+	mock.Builds.Update_ = func(ctx context.Context, op *sourcegraph.BuildsUpdateOp) (*sourcegraph.Build, error) {
+		if !op.Info.Purged {
+			t.Errorf("got update %+v for build #%d, want Purged", op.Info, op.Build.ID)
+		}
+		purged = append(purged, op.Build.ID)
+		return &sourcegraph.Build{ID: op.Build.ID, Repo: "r/r", Purged: true}, nil
+	}
+
+	// keep=0 considers every build, but the active build is skipped
+	// and the already-purged build is not considered.
+	var summary *buildsPruneSummary
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds/prune?keep=0&confirm=true", nil, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.DryRun {
+		t.Error("got DryRun=true, want false")
+	}
+	if summary.Considered != 3 {
+		t.Errorf("got %d considered, want 3", summary.Considered)
+	}
+	if want := []uint64{3, 2}; !reflect.DeepEqual(purged, want) {
+		t.Errorf("purged builds %v, want %v", purged, want)
+	}
+	if !reflect.DeepEqual(summary.Pruned, purged) {
+		t.Errorf("got Pruned %v, want %v", summary.Pruned, purged)
+	}
+	if want := []uint64{4}; !reflect.DeepEqual(summary.InProgress, want) {
+		t.Errorf("got InProgress %v, want %v", summary.InProgress, want)
+	}
+}
+
+func TestRepoBuildsPrune_age(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		return &sourcegraph.BuildList{Builds: pruneTestBuilds(time.Now())}, nil
+	}
+	var purged []uint64
+	mock.Builds.Update_ = func(ctx context.Context, op *sourcegraph.BuildsUpdateOp) (*sourcegraph.Build, error) {
+		purged = append(purged, op.Build.ID)
+		return &sourcegraph.Build{ID: op.Build.ID, Repo: "r/r", Purged: true}, nil
+	}
+
+	// Only build #2 is older than a week (and not already purged).
+	var summary *buildsPruneSummary
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds/prune?age=168h&confirm=true", nil, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{2}; !reflect.DeepEqual(purged, want) {
+		t.Errorf("purged builds %v, want %v", purged, want)
+	}
+	if summary.Considered != 1 {
+		t.Errorf("got %d considered, want 1", summary.Considered)
+	}
+}
+
+func TestRepoBuildsPrune_dryRun(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		return &sourcegraph.BuildList{Builds: pruneTestBuilds(time.Now())}, nil
+	}
+	mock.Builds.Update_ = func(ctx context.Context, op *sourcegraph.BuildsUpdateOp) (*sourcegraph.Build, error) {
+		t.Errorf("build #%d updated during dry run", op.Build.ID)
+		return nil, nil
+	}
+
+	var summary *buildsPruneSummary
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds/prune?keep=0", nil, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if !summary.DryRun {
+		t.Error("got DryRun=false, want true")
+	}
+	if want := []uint64{3, 2}; !reflect.DeepEqual(summary.Pruned, want) {
+		t.Errorf("got Pruned %v, want %v", summary.Pruned, want)
+	}
+}
+
+func TestRepoBuildsPrune_requiresFilter(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1, Admin: true})
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds/prune?confirm=true", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuildsPrune_requiresAdmin(t *testing.T) {
+	c, mock := newTest()
+	mock.Ctx = auth.WithActor(mock.Ctx, auth.Actor{UID: 1})
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds/prune?keep=0&confirm=true", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
@@ -1,28 +1,354 @@
 package httpapi
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"sourcegraph.com/sourcegraph/sourcegraph/api/sourcegraph"
+	"sourcegraph.com/sourcegraph/sourcegraph/pkg/vcs"
+	"sourcegraph.com/sqs/pbtypes"
 )
 
+func TestRepoBuilds_filterByCommitAndBranch(t *testing.T) {
+	c, mock := newTest()
+
+	const fullCommitID = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+	var calledResolveRev bool
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		calledResolveRev = true
+		if want := "aaaa"; op.Rev != want {
+			t.Errorf("got rev %q, want %q", op.Rev, want)
+		}
+		return &sourcegraph.ResolvedRev{CommitID: fullCommitID}, nil
+	}
+	var calledList bool
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		calledList = true
+		if opt.CommitID != fullCommitID {
+			t.Errorf("got CommitID %q, want %q", opt.CommitID, fullCommitID)
+		}
+		if want := "master"; opt.Branch != want {
+			t.Errorf("got Branch %q, want %q", opt.Branch, want)
+		}
+		return &sourcegraph.BuildList{}, nil
+	}
+
+	var builds *sourcegraph.BuildList
+	if err := c.GetJSON("/repos/r/r/-/builds?commit=aaaa&branch=master", &builds); err != nil {
+		t.Fatal(err)
+	}
+	if !calledResolveRev {
+		t.Error("!calledResolveRev")
+	}
+	if !calledList {
+		t.Error("!calledList")
+	}
+}
+
+func TestDecodeBuildFilter(t *testing.T) {
+	tests := []struct {
+		query string
+
+		wantErr      bool
+		wantOpt      sourcegraph.BuildListOptions
+		wantFull     bool
+		wantMsgRegex bool
+	}{
+		{query: "", wantOpt: sourcegraph.BuildListOptions{Sort: "created_at", Direction: "desc"}},
+		{query: "commit=aaaa&branch=master", wantOpt: sourcegraph.BuildListOptions{CommitID: "aaaa", Branch: "master", Sort: "created_at", Direction: "desc"}},
+		{query: "trigger=manual", wantOpt: sourcegraph.BuildListOptions{Manual: true, Sort: "created_at", Direction: "desc"}},
+		{query: "trigger=automated", wantOpt: sourcegraph.BuildListOptions{Automated: true, Sort: "created_at", Direction: "desc"}},
+		{query: "sort=oldest", wantOpt: sourcegraph.BuildListOptions{Sort: "created_at", Direction: "asc"}},
+		// An explicit Sort takes precedence over the sort alias.
+		{query: "sort=oldest&Sort=updated_at&Direction=desc", wantOpt: sourcegraph.BuildListOptions{Sort: "updated_at", Direction: "desc"}},
+		{query: "full=true", wantOpt: sourcegraph.BuildListOptions{Sort: "created_at", Direction: "desc"}, wantFull: true},
+		// A message filter needs the commits, so it implies full.
+		{query: "message=hotfix", wantOpt: sourcegraph.BuildListOptions{Sort: "created_at", Direction: "desc"}, wantFull: true, wantMsgRegex: true},
+		{query: "trigger=bot", wantErr: true},
+		{query: "sort=sideways", wantErr: true},
+		{query: "message=%5B", wantErr: true}, // "[" is not a valid regexp
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/repos/r/r/-/builds?"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The page size is always clamped to the default when unset.
+		test.wantOpt.PerPage = buildListDefaultPerPage
+		f, err := decodeBuildFilter(r)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%q: got nil error, want one", test.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(f.opt, test.wantOpt) {
+			t.Errorf("%q: got opt %+v, want %+v", test.query, f.opt, test.wantOpt)
+		}
+		if f.full != test.wantFull {
+			t.Errorf("%q: got full %v, want %v", test.query, f.full, test.wantFull)
+		}
+		if (f.msgPattern != nil) != test.wantMsgRegex {
+			t.Errorf("%q: got msgPattern %v, want set: %v", test.query, f.msgPattern, test.wantMsgRegex)
+		}
+	}
+}
+
+func TestRepoBuilds_triggerFilter(t *testing.T) {
+	c, mock := newTest()
+
+	var wantManual, wantAutomated bool
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		if opt.Manual != wantManual {
+			t.Errorf("got Manual %v, want %v", opt.Manual, wantManual)
+		}
+		if opt.Automated != wantAutomated {
+			t.Errorf("got Automated %v, want %v", opt.Automated, wantAutomated)
+		}
+		return &sourcegraph.BuildList{}, nil
+	}
+
+	var builds *sourcegraph.BuildList
+	wantManual, wantAutomated = true, false
+	if err := c.GetJSON("/repos/r/r/-/builds?trigger=manual", &builds); err != nil {
+		t.Fatal(err)
+	}
+	wantManual, wantAutomated = false, true
+	if err := c.GetJSON("/repos/r/r/-/builds?trigger=automated", &builds); err != nil {
+		t.Fatal(err)
+	}
+	// The filter also decodes directly, as pagination links repeat it.
+	wantManual, wantAutomated = true, false
+	if err := c.GetJSON("/repos/r/r/-/builds?Manual=true", &builds); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Get("/repos/r/r/-/builds?trigger=bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuild_full(t *testing.T) {
+	c, mock := newTest()
+	buildCommitCache = newCommitCache(0) // don't serve commits cached by other tests
+
+	start := pbtypes.NewTimestamp(time.Unix(100, 0))
+	end := pbtypes.NewTimestamp(time.Unix(160, 0))
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI, CommitID: "c", StartedAt: &start, EndedAt: &end, Success: true, Host: "worker-1"}, nil
+	}
+	mock.Builds.ListBuildTasks_ = func(ctx context.Context, op *sourcegraph.BuildsListBuildTasksOp) (*sourcegraph.BuildTaskList, error) {
+		return &sourcegraph.BuildTaskList{BuildTasks: []*sourcegraph.BuildTask{
+			{ID: 1, Label: "Go build", Success: true},
+			{ID: 2, Label: "Go test", Failure: true},
+		}}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(repoRev.CommitID)}, nil
+	}
+
+	var detail *buildDetail
+	if err := c.GetJSON("/repos/r/r/-/builds/123?full=true", &detail); err != nil {
+		t.Fatal(err)
+	}
+	// The build row says Success, but one of its tasks failed; the
+	// task-derived status wins.
+	if detail.Status != "failed" {
+		t.Errorf("got status %q, want failed", detail.Status)
+	}
+	if want := 60.0; detail.DurationSeconds != want {
+		t.Errorf("got duration %v, want %v", detail.DurationSeconds, want)
+	}
+	if detail.Commit == nil {
+		t.Error("missing commit")
+	}
+	if want := "worker-1"; detail.Host != want {
+		t.Errorf("got host %q, want %q", detail.Host, want)
+	}
+	if len(detail.Tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(detail.Tasks))
+	}
+	if detail.Tasks[1].Status != "failed" {
+		t.Errorf("got task status %q, want failed", detail.Tasks[1].Status)
+	}
+	if want := "/repos/r/r/-/builds/123/tasks/1/log"; detail.Tasks[0].LogURL != want {
+		t.Errorf("got LogURL %q, want %q", detail.Tasks[0].LogURL, want)
+	}
+
+	// Without full=true the response stays a bare Build.
+	var build *sourcegraph.Build
+	if err := c.GetJSON("/repos/r/r/-/builds/123", &build); err != nil {
+		t.Fatal(err)
+	}
+	if build.ID != 123 {
+		t.Errorf("got build ID %d, want 123", build.ID)
+	}
+}
+
+func TestAggregateBuildStatus(t *testing.T) {
+	succeeded := &sourcegraph.BuildTask{Success: true}
+	failed := &sourcegraph.BuildTask{Failure: true}
+	skipped := &sourcegraph.BuildTask{Skipped: true}
+	pending := &sourcegraph.BuildTask{}
+	start := pbtypes.NewTimestamp(time.Unix(100, 0))
+	active := &sourcegraph.BuildTask{StartedAt: &start}
+
+	tests := []struct {
+		label string
+		build *sourcegraph.Build
+		tasks []*sourcegraph.BuildTask
+		want  string
+	}{
+		{"no tasks falls back to build flags", &sourcegraph.Build{Success: true}, nil, "succeeded"},
+		{"any failed task wins", &sourcegraph.Build{Success: true}, []*sourcegraph.BuildTask{succeeded, failed}, "failed"},
+		{"failed wins over active", &sourcegraph.Build{}, []*sourcegraph.BuildTask{active, failed}, "failed"},
+		{"any active task means active", &sourcegraph.Build{Success: true}, []*sourcegraph.BuildTask{succeeded, active}, "active"},
+		{"all done means succeeded", &sourcegraph.Build{}, []*sourcegraph.BuildTask{succeeded, skipped}, "succeeded"},
+		{"nothing started falls back to build flags", &sourcegraph.Build{BuildConfig: sourcegraph.BuildConfig{Queue: true}}, []*sourcegraph.BuildTask{pending, pending}, "queued"},
+		{"killed is administrative", &sourcegraph.Build{Killed: true, Failure: true}, []*sourcegraph.BuildTask{succeeded}, "killed"},
+	}
+	for _, test := range tests {
+		if got := aggregateBuildStatus(test.build, test.tasks); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.label, got, test.want)
+		}
+	}
+}
+
+func TestRepoBuild_queuePosition(t *testing.T) {
+	c, mock := newTest()
+	buildCommitCache = newCommitCache(0)
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI, CommitID: "c", BuildConfig: sourcegraph.BuildConfig{Queue: true}}, nil
+	}
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		if !opt.Queued || opt.Sort != "created_at" || opt.Direction != "asc" {
+			t.Errorf("got list options %+v, want queued builds sorted by created_at asc", opt)
+		}
+		return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+			{ID: 7, Repo: "a/a"},
+			{ID: 123, Repo: "other/other"}, // same ID, different repo
+			{ID: 123, Repo: "r/r"},
+			{ID: 9, Repo: "b/b"},
+		}}, nil
+	}
+	mock.Builds.ListBuildTasks_ = func(ctx context.Context, op *sourcegraph.BuildsListBuildTasksOp) (*sourcegraph.BuildTaskList, error) {
+		return &sourcegraph.BuildTaskList{}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(repoRev.CommitID)}, nil
+	}
+
+	var detail *buildDetail
+	if err := c.GetJSON("/repos/r/r/-/builds/123?full=true", &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.Status != "queued" {
+		t.Errorf("got status %q, want queued", detail.Status)
+	}
+	if want := 3; detail.QueuePosition != want {
+		t.Errorf("got queue position %d, want %d", detail.QueuePosition, want)
+	}
+
+	// A build missing from the queue list (e.g. dequeued between the
+	// Get and the List) reports no position.
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		return &sourcegraph.BuildList{}, nil
+	}
+	detail = nil // QueuePosition is omitempty; don't reuse the decoded struct
+	if err := c.GetJSON("/repos/r/r/-/builds/123?full=true", &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.QueuePosition != 0 {
+		t.Errorf("got queue position %d, want 0", detail.QueuePosition)
+	}
+}
+
+func TestRepoBuild_lookupErrors(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return nil, grpc.Errorf(codes.NotFound, "no such build")
+	}
+	resp, err := c.Get("/repos/r/r/-/builds/123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		return nil, grpc.Errorf(codes.PermissionDenied, "nope")
+	}
+	resp, err = c.Get("/repos/r/r/-/builds/123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	// The mapped messages distinguish the cases and point at the
+	// builds list.
+	spec := &sourcegraph.BuildSpec{Repo: sourcegraph.RepoSpec{URI: "r/r"}, ID: 123}
+	nf := buildLookupError(spec, grpc.Errorf(codes.NotFound, "no such build"))
+	if want := "/repos/r/r/-/builds"; !strings.Contains(nf.Error(), want) {
+		t.Errorf("not-found error %q does not mention %q", nf, want)
+	}
+	pd := buildLookupError(spec, grpc.Errorf(codes.PermissionDenied, "nope"))
+	if want := `repository "r/r"`; !strings.Contains(pd.Error(), want) {
+		t.Errorf("permission error %q does not mention %q", pd, want)
+	}
+	other := errors.New("boom")
+	if got := buildLookupError(spec, other); got != other {
+		t.Errorf("unrelated error was rewritten to %q", got)
+	}
+}
+
 func TestRepoBuildsCreate(t *testing.T) {
 	c, mock := newTest()
 
-	wantBuild := &sourcegraph.Build{ID: 123, Repo: "r/r", CommitID: "c"}
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+	wantBuild := &sourcegraph.Build{ID: 123, Repo: "r/r", CommitID: commitID}
 
 	calledRepoGet := mock.Repos.MockGet(t, "r/r")
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		return &sourcegraph.ResolvedRev{CommitID: commitID}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(commitID)}, nil
+	}
 	var calledCreate bool
 	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
 		calledCreate = true
+		if op.CommitID != commitID {
+			t.Errorf("got CommitID %q, want %q", op.CommitID, commitID)
+		}
 		return wantBuild, nil
 	}
 
 	var build *sourcegraph.Build
-	if err := c.DoJSON("POST", "/repos/r/r/-/builds", &sourcegraph.BuildsCreateOp{}, &build); err != nil {
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds", &sourcegraph.BuildsCreateOp{CommitID: commitID}, &build); err != nil {
 		t.Fatal(err)
 	}
 	if !*calledRepoGet {
@@ -35,3 +361,519 @@ func TestRepoBuildsCreate(t *testing.T) {
 		t.Errorf("got %+v, want %+v", build, wantBuild)
 	}
 }
+
+func TestRepoBuildsCreate_dedupe(t *testing.T) {
+	c, mock := newTest()
+
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+
+	mock.Repos.MockGet(t, "r/r")
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		return &sourcegraph.ResolvedRev{CommitID: commitID}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(commitID)}, nil
+	}
+
+	existing := &sourcegraph.Build{
+		ID:          7,
+		Repo:        "r/r",
+		CommitID:    commitID,
+		Branch:      "master",
+		CreatedAt:   pbtypes.NewTimestamp(time.Now()),
+		BuildConfig: sourcegraph.BuildConfig{Queue: true},
+	}
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		if opt.Repo != "r/r" || opt.CommitID != commitID {
+			t.Errorf("got list filter repo=%q commit=%q, want r/r and the resolved commit", opt.Repo, opt.CommitID)
+		}
+		if opt.Queued {
+			return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{existing}}, nil
+		}
+		return &sourcegraph.BuildList{}, nil
+	}
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("build created despite a queued duplicate")
+		return nil, nil
+	}
+
+	op := &sourcegraph.BuildsCreateOp{Branch: "master", Config: sourcegraph.BuildConfig{Queue: true}}
+	var build *sourcegraph.Build
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds?dedupe=true", op, &build); err != nil {
+		t.Fatal(err)
+	}
+	if build.ID != existing.ID {
+		t.Errorf("got build ID %d, want existing build %d", build.ID, existing.ID)
+	}
+
+	// A stale queued build outside the dedup window doesn't suppress a
+	// new one.
+	existing.CreatedAt = pbtypes.NewTimestamp(time.Now().Add(-buildDedupWindow - time.Minute))
+	var calledCreate bool
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		calledCreate = true
+		return &sourcegraph.Build{ID: 8, Repo: "r/r", CommitID: commitID}, nil
+	}
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds?dedupe=true", op, &build); err != nil {
+		t.Fatal(err)
+	}
+	if !calledCreate {
+		t.Error("!calledCreate")
+	}
+}
+
+func TestRepoBuildsCreate_form(t *testing.T) {
+	c, mock := newTest()
+
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+
+	mock.Repos.MockGet(t, "r/r")
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		if want := "mybranch"; op.Rev != want {
+			t.Errorf("got rev %q, want %q", op.Rev, want)
+		}
+		return &sourcegraph.ResolvedRev{CommitID: commitID}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(commitID)}, nil
+	}
+	var calledCreate bool
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		calledCreate = true
+		if op.CommitID != commitID {
+			t.Errorf("got CommitID %q, want %q", op.CommitID, commitID)
+		}
+		if want := "mybranch"; op.Branch != want {
+			t.Errorf("got Branch %q, want %q", op.Branch, want)
+		}
+		if !op.Config.Queue {
+			t.Error("expected Config.Queue to default to true for form posts")
+		}
+		if want := int32(5); op.Config.Priority != want {
+			t.Errorf("got Priority %d, want %d", op.Config.Priority, want)
+		}
+		return &sourcegraph.Build{ID: 1, Repo: "r/r", CommitID: commitID}, nil
+	}
+
+	form := url.Values{"Branch": {"mybranch"}, "Config.Priority": {"5"}}
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := c.DoOK(req); err != nil {
+		t.Fatal(err)
+	}
+	if !calledCreate {
+		t.Error("!calledCreate")
+	}
+}
+
+func TestRepoBuildsCreate_invalidRef(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Repos.MockGet(t, "r/r")
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		return nil, grpc.Errorf(codes.NotFound, "no such rev")
+	}
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("build created despite invalid ref")
+		return nil, nil
+	}
+
+	form := url.Values{"Branch": {"doesnotexist"}}
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuildsCreate_defaultBranch(t *testing.T) {
+	c, mock := newTest()
+
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+
+	mock.Repos.Get_ = func(ctx context.Context, repo *sourcegraph.RepoSpec) (*sourcegraph.Repo, error) {
+		return &sourcegraph.Repo{URI: repo.URI, DefaultBranch: "main"}, nil
+	}
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		if op.Rev != "main" {
+			t.Errorf("got rev %q, want the default branch \"main\"", op.Rev)
+		}
+		return &sourcegraph.ResolvedRev{CommitID: commitID}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(commitID)}, nil
+	}
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		if op.Branch != "main" {
+			t.Errorf("got branch %q, want \"main\"", op.Branch)
+		}
+		return &sourcegraph.Build{ID: 1, Repo: "r/r", CommitID: op.CommitID, Branch: op.Branch}, nil
+	}
+
+	// No CommitID, Branch, or Tag: the repo's default branch is used.
+	var build *sourcegraph.Build
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds", &sourcegraph.BuildsCreateOp{}, &build); err != nil {
+		t.Fatal(err)
+	}
+	if build.Branch != "main" {
+		t.Errorf("got build branch %q, want \"main\"", build.Branch)
+	}
+}
+
+func TestRepoBuildsCreate_noDefaultBranch(t *testing.T) {
+	c, mock := newTest()
+
+	// MockGet's repo has no DefaultBranch, so a ref-less request has
+	// nothing to build.
+	mock.Repos.MockGet(t, "r/r")
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("build created without a ref")
+		return nil, nil
+	}
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds", strings.NewReader("{}"))
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuildsCreate_dryRun(t *testing.T) {
+	c, mock := newTest()
+
+	const commitID = "cccccccccccccccccccccccccccccccccccccccc"
+
+	mock.Repos.MockGet(t, "r/r")
+	calledResolveRev := false
+	mock.Repos.ResolveRev_ = func(ctx context.Context, op *sourcegraph.ReposResolveRevOp) (*sourcegraph.ResolvedRev, error) {
+		calledResolveRev = true
+		return &sourcegraph.ResolvedRev{CommitID: commitID}, nil
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(commitID)}, nil
+	}
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("Builds.Create called during dry run")
+		return nil, nil
+	}
+
+	var op sourcegraph.BuildsCreateOp
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds?dryRun=true", &sourcegraph.BuildsCreateOp{Branch: "mybranch"}, &op); err != nil {
+		t.Fatal(err)
+	}
+	if !calledResolveRev {
+		t.Error("!calledResolveRev")
+	}
+	if op.CommitID != commitID {
+		t.Errorf("got CommitID %q, want %q", op.CommitID, commitID)
+	}
+	if want := "mybranch"; op.Branch != want {
+		t.Errorf("got Branch %q, want %q", op.Branch, want)
+	}
+}
+
+func TestRepoBuildRestart(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		b := &sourcegraph.Build{ID: spec.ID, Repo: spec.Repo.URI, CommitID: "c", Branch: "master"}
+		b.BuilderConfig = "pipeline: {}"
+		return b, nil
+	}
+	mock.Builds.ListBuildTasks_ = func(ctx context.Context, op *sourcegraph.BuildsListBuildTasksOp) (*sourcegraph.BuildTaskList, error) {
+		return &sourcegraph.BuildTaskList{BuildTasks: []*sourcegraph.BuildTask{
+			{ID: 1, Label: "deps", Success: true},
+			{ID: 2, Label: "build", Success: true},
+			{ID: 3, Label: "deploy", Failure: true},
+		}}, nil
+	}
+	var calledCreate bool
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		calledCreate = true
+		if op.CommitID != "c" || op.Branch != "master" || !op.Config.Queue {
+			t.Errorf("unexpected op %+v", op)
+		}
+		if !strings.Contains(op.Config.BuilderConfig, "# restart-of: build 123 from task 3") ||
+			!strings.Contains(op.Config.BuilderConfig, "# reusable-tasks: deps, build") ||
+			!strings.Contains(op.Config.BuilderConfig, "pipeline: {}") {
+			t.Errorf("unexpected BuilderConfig %q", op.Config.BuilderConfig)
+		}
+		return &sourcegraph.Build{ID: 124, Repo: "r/r", CommitID: "c"}, nil
+	}
+
+	var restart *buildRestart
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds/123/restart?task=3", nil, &restart); err != nil {
+		t.Fatal(err)
+	}
+	if !calledCreate {
+		t.Error("!calledCreate")
+	}
+	if restart.Build.ID != 124 {
+		t.Errorf("got new build ID %d, want 124", restart.Build.ID)
+	}
+	if want := []string{"deps", "build"}; !reflect.DeepEqual(restart.ReusableTasks, want) {
+		t.Errorf("got reusable tasks %v, want %v", restart.ReusableTasks, want)
+	}
+
+	// An unknown task is a 404, and no build is created.
+	calledCreate = false
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds/123/restart?task=99", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if calledCreate {
+		t.Error("build created despite unknown task")
+	}
+}
+
+func TestRepoBuilds_full(t *testing.T) {
+	c, mock := newTest()
+	buildCommitCache = newCommitCache(0) // the call counts below assume no cache
+
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+			{ID: 1, Repo: "r/r", CommitID: "a"},
+			{ID: 2, Repo: "r/r", CommitID: "b"},
+			{ID: 3, Repo: "r/r", CommitID: "a"},
+		}}, nil
+	}
+	var mu sync.Mutex
+	getCommitCalls := map[string]int{}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		mu.Lock()
+		getCommitCalls[repoRev.CommitID]++
+		mu.Unlock()
+		return &vcs.Commit{ID: vcs.CommitID(repoRev.CommitID)}, nil
+	}
+
+	var rows []*buildAndCommit
+	if err := c.GetJSON("/repos/r/r/-/builds?full=true", &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	// Ordering matches the build list, and shared commits resolve once.
+	for i, wantCommit := range []string{"a", "b", "a"} {
+		if rows[i].Commit == nil || string(rows[i].Commit.ID) != wantCommit {
+			t.Errorf("row %d: got commit %v, want %q", i, rows[i].Commit, wantCommit)
+		}
+	}
+	if getCommitCalls["a"] != 1 || getCommitCalls["b"] != 1 {
+		t.Errorf("got GetCommit calls %v, want one per distinct commit", getCommitCalls)
+	}
+}
+
+func TestRepoBuilds_messageFilter(t *testing.T) {
+	c, mock := newTest()
+	buildCommitCache = newCommitCache(0) // don't serve commits cached by other tests
+
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+			{ID: 1, Repo: "r/r", CommitID: "m1"},
+			{ID: 2, Repo: "r/r", CommitID: "m2"},
+			{ID: 3, Repo: "r/r", CommitID: "m3"},
+		}}, nil
+	}
+	messages := map[string]string{
+		"m1": "hotfix: stop the bleeding",
+		"m2": "add feature",
+		"m3": "revert hotfix",
+	}
+	mock.Repos.GetCommit_ = func(ctx context.Context, repoRev *sourcegraph.RepoRevSpec) (*vcs.Commit, error) {
+		return &vcs.Commit{ID: vcs.CommitID(repoRev.CommitID), Message: messages[repoRev.CommitID]}, nil
+	}
+
+	var rows []*buildAndCommit
+	if err := c.GetJSON("/repos/r/r/-/builds?message=hotfix", &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	for i, wantID := range []uint64{1, 3} {
+		if rows[i].Build.ID != wantID {
+			t.Errorf("row %d: got build %d, want %d", i, rows[i].Build.ID, wantID)
+		}
+	}
+
+	// A broken regexp is a client error, not a server error.
+	resp, err := c.Get("/repos/r/r/-/builds?message=" + url.QueryEscape("("))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuildCompare(t *testing.T) {
+	c, mock := newTest()
+
+	baseStart := pbtypes.NewTimestamp(time.Unix(100, 0))
+	baseEnd := pbtypes.NewTimestamp(time.Unix(160, 0))
+	headStart := pbtypes.NewTimestamp(time.Unix(200, 0))
+	headEnd := pbtypes.NewTimestamp(time.Unix(290, 0))
+	mock.Builds.Get_ = func(ctx context.Context, spec *sourcegraph.BuildSpec) (*sourcegraph.Build, error) {
+		switch spec.ID {
+		case 1:
+			return &sourcegraph.Build{ID: 1, Repo: spec.Repo.URI, CommitID: "base", StartedAt: &baseStart, EndedAt: &baseEnd, Success: true}, nil
+		case 2:
+			return &sourcegraph.Build{ID: 2, Repo: spec.Repo.URI, CommitID: "head", StartedAt: &headStart, EndedAt: &headEnd, Failure: true}, nil
+		}
+		t.Fatalf("unexpected build ID %d", spec.ID)
+		return nil, nil
+	}
+	mock.Builds.ListBuildTasks_ = func(ctx context.Context, op *sourcegraph.BuildsListBuildTasksOp) (*sourcegraph.BuildTaskList, error) {
+		switch op.Build.ID {
+		case 1:
+			return &sourcegraph.BuildTaskList{BuildTasks: []*sourcegraph.BuildTask{
+				{ID: 1, Label: "Go build", Success: true},
+				{ID: 2, Label: "Go test", Success: true},
+				{ID: 3, Label: "lint", Success: true},
+			}}, nil
+		case 2:
+			return &sourcegraph.BuildTaskList{BuildTasks: []*sourcegraph.BuildTask{
+				{ID: 4, Label: "Go build", Success: true},
+				{ID: 5, Label: "Go test", Failure: true},
+				{ID: 6, Label: "docs", Success: true},
+			}}, nil
+		}
+		return nil, nil
+	}
+
+	var cmp *buildComparison
+	if err := c.GetJSON("/repos/r/r/-/builds/2/compare/1", &cmp); err != nil {
+		t.Fatal(err)
+	}
+	if cmp.BaseStatus != "succeeded" || cmp.HeadStatus != "failed" {
+		t.Errorf("got statuses %q -> %q, want succeeded -> failed", cmp.BaseStatus, cmp.HeadStatus)
+	}
+	if want := 30.0; cmp.DurationDeltaSeconds != want {
+		t.Errorf("got duration delta %v, want %v", cmp.DurationDeltaSeconds, want)
+	}
+	wantTasks := []*buildTaskComparison{
+		{Label: "Go build", BaseStatus: "succeeded", HeadStatus: "succeeded"},
+		{Label: "Go test", BaseStatus: "succeeded", HeadStatus: "failed", Changed: true},
+		{Label: "docs", HeadStatus: "succeeded", Changed: true},
+		{Label: "lint", BaseStatus: "succeeded", Changed: true},
+	}
+	if !reflect.DeepEqual(cmp.Tasks, wantTasks) {
+		t.Errorf("got tasks %v, want %v", cmp.Tasks, wantTasks)
+	}
+	if cmp.CommitRangeURL == "" || !strings.Contains(cmp.CommitRangeURL, "Base=base") || !strings.Contains(cmp.CommitRangeURL, "Head=head") {
+		t.Errorf("got CommitRangeURL %q, want commit range link", cmp.CommitRangeURL)
+	}
+}
+
+func TestRepoBuilds_sort(t *testing.T) {
+	c, mock := newTest()
+
+	var gotSort, gotDirection string
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		gotSort, gotDirection = opt.Sort, opt.Direction
+		return &sourcegraph.BuildList{}, nil
+	}
+
+	tests := []struct {
+		url               string
+		wantSort, wantDir string
+	}{
+		{"/repos/r/r/-/builds", "created_at", "desc"},
+		{"/repos/r/r/-/builds?sort=newest", "created_at", "desc"},
+		{"/repos/r/r/-/builds?sort=oldest", "created_at", "asc"},
+		// An explicit Sort param bypasses the alias.
+		{"/repos/r/r/-/builds?Sort=priority&Direction=asc", "priority", "asc"},
+	}
+	for _, test := range tests {
+		var builds *sourcegraph.BuildList
+		if err := c.GetJSON(test.url, &builds); err != nil {
+			t.Fatalf("%s: %v", test.url, err)
+		}
+		if gotSort != test.wantSort || gotDirection != test.wantDir {
+			t.Errorf("%s: got sort %q %q, want %q %q", test.url, gotSort, gotDirection, test.wantSort, test.wantDir)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/repos/r/r/-/builds?sort=bogus", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d for invalid sort, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRepoBuildsRebuildFailed(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Repos.MockGet(t, "r/r")
+	now := pbtypes.NewTimestamp(time.Now())
+	mock.Builds.List_ = func(ctx context.Context, opt *sourcegraph.BuildListOptions) (*sourcegraph.BuildList, error) {
+		if !opt.Failed {
+			t.Error("expected Failed filter")
+		}
+		return &sourcegraph.BuildList{Builds: []*sourcegraph.Build{
+			{ID: 1, Repo: "r/r", CommitID: "a", Branch: "master", CreatedAt: now, Failure: true},
+			{ID: 2, Repo: "r/r", CommitID: "b", CreatedAt: now, Failure: true},
+			// Killed, not failed; must not be re-queued.
+			{ID: 3, Repo: "r/r", CommitID: "c", CreatedAt: now, Failure: true, Killed: true},
+		}}, nil
+	}
+	var created []string
+	nextID := uint64(100)
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		if !op.Config.Queue {
+			t.Error("expected re-queued build to have Queue set")
+		}
+		created = append(created, op.CommitID)
+		nextID++
+		return &sourcegraph.Build{ID: nextID, Repo: "r/r", CommitID: op.CommitID}, nil
+	}
+
+	var summary *rebuildFailedSummary
+	if err := c.DoJSON("POST", "/repos/r/r/-/builds/rebuild-failed?confirm=true", nil, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Considered != 2 {
+		t.Errorf("got %d considered, want 2", summary.Considered)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(created, want) {
+		t.Errorf("got created commits %v, want %v", created, want)
+	}
+	if len(summary.Requeued) != 2 {
+		t.Errorf("got %d requeued, want 2", len(summary.Requeued))
+	}
+}
+
+func TestRepoBuildsRebuildFailed_requiresConfirm(t *testing.T) {
+	c, mock := newTest()
+
+	mock.Repos.MockGet(t, "r/r")
+	mock.Builds.Create_ = func(ctx context.Context, op *sourcegraph.BuildsCreateOp) (*sourcegraph.Build, error) {
+		t.Error("build created without confirmation")
+		return nil, nil
+	}
+
+	req, _ := http.NewRequest("POST", "/repos/r/r/-/builds/rebuild-failed", nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -23,7 +23,12 @@ const (
 	Annotations              = "annotations"
 	AuthInfo                 = "auth-info"
 	Builds                   = "builds"
+	BuildsAdmin              = "builds.admin"
+	BuildsEvents             = "builds.events"
+	BuildsStats              = "builds.stats"
 	BuildTaskLog             = "build.task.log"
+	BuildTaskLogShared       = "build.task.log.shared"
+	BuildLog                 = "build.log"
 	ChannelListen            = "channel.listen"
 	ChannelSend              = "channel.send"
 	Coverage                 = "coverage"
@@ -36,14 +41,25 @@ const (
 	Repo                     = "repo"
 	RepoResolve              = "repo.resolve"
 	RepoCreate               = "repo.create"
+	RepoPurge                = "repo.purge"
 	RepoRefresh              = "repo.refresh"
 	RepoInventory            = "repo.inventory"
 	RepoBranches             = "repo.branches"
 	RepoBuild                = "repo.build"
+	RepoBuildCompare         = "build.compare"
+	RepoBuildRestart         = "build.restart"
 	RepoTree                 = "repo.tree"
 	RepoBuilds               = "repo.builds"
 	RepoBuildTasks           = "build.tasks"
+	RepoBuildAudit           = "build.audit"
+	RepoBuildArtifacts       = "build.artifacts"
+	RepoBuildArtifact        = "build.artifact"
 	RepoBuildsCreate         = "repo.builds.create"
+	RepoBuildsRebuildFailed  = "repo.builds.rebuild-failed"
+	RepoBuildsPrune          = "repo.builds.prune"
+	RepoBuildsCancelQueued   = "repo.builds.cancel-queued"
+	RepoBuildHook            = "repo.builds.hook"
+	RepoBuildsEvents         = "repo.builds.events"
 	RepoCommits              = "repo.commits"
 	RepoResolveRev           = "repo.resolve-rev"
 	RepoTags                 = "repo.tags"
@@ -79,6 +95,9 @@ func New(base *mux.Router) *mux.Router {
 	base.Path("/annotations").Methods("GET").Name(Annotations)
 
 	base.Path("/builds").Methods("GET").Name(Builds)
+	base.Path("/builds/admin").Methods("GET").Name(BuildsAdmin)
+	base.Path("/builds/events").Methods("GET").Name(BuildsEvents)
+	base.Path("/builds/stats").Methods("GET").Name(BuildsStats)
 	base.Path("/coverage").Methods("GET").Name(Coverage)
 
 	base.Path("/repos").Methods("GET").Name(Repos)
@@ -102,6 +121,7 @@ func New(base *mux.Router) *mux.Router {
 	repoRev := base.PathPrefix(repoPath + routevar.RepoRevSuffix + "/" + routevar.RepoPathDelim + "/").Subrouter()
 	repo.Path("/resolve").Methods("GET").Name(RepoResolve)
 	repo.Path("/refresh").Methods("POST").Name(RepoRefresh)
+	repo.Path("/purge").Methods("POST").Name(RepoPurge)
 	repo.Path("/branches").Methods("GET").Name(RepoBranches)
 	repo.Path("/commits").Methods("GET").Name(RepoCommits) // uses Head/Base query params, not {Rev} route var
 	repoRev.Path("/tree-list").Methods("GET").Name(RepoTreeList)
@@ -113,11 +133,23 @@ func New(base *mux.Router) *mux.Router {
 
 	repo.Path("/builds").Methods("GET").Name(RepoBuilds)
 	repo.Path("/builds").Methods("POST").Name(RepoBuildsCreate)
+	repo.Path("/builds/rebuild-failed").Methods("POST").Name(RepoBuildsRebuildFailed)
+	repo.Path("/builds/prune").Methods("POST").Name(RepoBuildsPrune)
+	repo.Path("/builds/cancel-queued").Methods("POST").Name(RepoBuildsCancelQueued)
+	repo.Path("/builds/hook").Methods("POST").Name(RepoBuildHook)
+	repo.Path("/builds/events").Methods("GET").Name(RepoBuildsEvents)
 	buildPath := `/builds/{Build:\d+}`
 	repo.Path(buildPath).Methods("GET").Name(RepoBuild)
 	build := repo.PathPrefix(buildPath).Subrouter()
 	build.Path("/tasks").Methods("GET").Name(RepoBuildTasks)
+	build.Path("/audit").Methods("GET").Name(RepoBuildAudit)
+	build.Path(`/compare/{BaseBuild:\d+}`).Methods("GET").Name(RepoBuildCompare)
+	build.Path("/restart").Methods("POST").Name(RepoBuildRestart)
 	build.Path(`/tasks/{Task:\d+}/log`).Methods("GET").Name(BuildTaskLog)
+	build.Path(`/tasks/{Task:\d+}/log/shared`).Methods("GET").Name(BuildTaskLogShared)
+	build.Path("/log").Methods("GET").Name(BuildLog)
+	build.Path("/artifacts").Methods("GET").Name(RepoBuildArtifacts)
+	build.Path("/artifacts/{Artifact}").Methods("GET").Name(RepoBuildArtifact)
 
 	base.Path("/defs").Methods("GET").Name(Defs)
 
@@ -3,6 +3,7 @@ package worker
 import (
 	"fmt"
 	"io"
+	"os"
 	"time"
 
 	"gopkg.in/inconshreveable/log15.v2"
@@ -32,9 +33,11 @@ func startBuild(ctx context.Context, build *sourcegraph.BuildJob) {
 		log15.Error("Updating build starting state failed", "build", build.Spec, "err", err)
 		return
 	}
+	// Stamp the worker's hostname on the build so odd behavior can be
+	// correlated with the host that ran it.
 	_, err = c.Builds.Update(ctx, &sourcegraph.BuildsUpdateOp{
 		Build: build.Spec,
-		Info:  sourcegraph.BuildUpdate{StartedAt: now()},
+		Info:  sourcegraph.BuildUpdate{StartedAt: now(), Host: hostname()},
 	})
 	if err != nil {
 		log15.Error("Updating build starting state failed", "build", build.Spec, "err", err)
@@ -189,3 +192,11 @@ func now() *pbtypes.Timestamp {
 	now := pbtypes.NewTimestamp(time.Now())
 	return &now
 }
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return h
+}
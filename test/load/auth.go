@@ -1,8 +1,10 @@
 package load
 
 import (
+	"log"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
@@ -17,6 +19,31 @@ import (
 const testUserName = e2etestuser.Prefix + "loadtest"
 const testPassword = "e2etest"
 
+// ExpiryBuffer is how long before an access token actually expires we
+// treat its cookie as expired, leaving time to refresh it.
+var ExpiryBuffer = 5 * time.Minute
+
+// AuthRetries and AuthRetryBaseDelay control the retry loop around
+// Auth.GetAccessToken. A transient failure (e.g. the server is still
+// warming up) is retried up to AuthRetries times, with exponential
+// backoff starting at AuthRetryBaseDelay.
+var (
+	AuthRetries        = 3
+	AuthRetryBaseDelay = time.Second
+)
+
+// cookieExpiry returns how long an authed cookie should be considered
+// valid for a token with the given lifetime. The buffer is subtracted
+// from the lifetime, but is capped at a tenth of it so that short-lived
+// tokens (e.g. 5-minute tokens) don't yield a zero or negative
+// duration.
+func cookieExpiry(lifetime, buffer time.Duration) time.Duration {
+	if max := lifetime / 10; buffer > max {
+		buffer = max
+	}
+	return lifetime - buffer
+}
+
 type authedCookie struct {
 	// HeaderValue is the cookie serialized for the Cookie header
 	HeaderValue string
@@ -25,12 +52,7 @@ type authedCookie struct {
 }
 
 func getAuthedCookie(endpoint *url.URL, username, password string) (*authedCookie, error) {
-	ctx := sourcegraph.WithGRPCEndpoint(context.Background(), endpoint)
-	cl, err := sourcegraph.NewClientFromContext(ctx)
-	if err != nil {
-		return nil, err
-	}
-	tok, err := cl.Auth.GetAccessToken(ctx, &sourcegraph.AccessTokenRequest{
+	cookie, _, err := getAuthedCookieForGrant(endpoint, &sourcegraph.AccessTokenRequest{
 		AuthorizationGrant: &sourcegraph.AccessTokenRequest_ResourceOwnerPassword{
 			ResourceOwnerPassword: &sourcegraph.LoginCredentials{
 				Login:    username,
@@ -38,24 +60,155 @@ func getAuthedCookie(endpoint *url.URL, username, password string) (*authedCooki
 			},
 		},
 	})
+	return cookie, err
+}
+
+// getAuthedCookieForGrant authenticates against endpoint with any
+// authorization grant (resource owner password, client credentials,
+// refresh token, ...) and returns both the session cookie and the raw
+// token response, so callers can e.g. exercise refresh-token rotation.
+func getAuthedCookieForGrant(endpoint *url.URL, req *sourcegraph.AccessTokenRequest) (*authedCookie, *sourcegraph.AccessTokenResponse, error) {
+	ctx := sourcegraph.WithGRPCEndpoint(context.Background(), endpoint)
+	cl, err := sourcegraph.NewClientFromContext(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	var tok *sourcegraph.AccessTokenResponse
+	for attempt := 0; ; attempt++ {
+		tok, err = cl.Auth.GetAccessToken(ctx, req)
+		if err == nil {
+			break
+		}
+		switch grpc.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			// Transient; worth retrying.
+		default:
+			return nil, nil, err
+		}
+		if attempt == AuthRetries {
+			return nil, nil, err
+		}
+		delay := AuthRetryBaseDelay << uint(attempt)
+		log.Printf("GetAccessToken failed with transient error (retrying in %s): %s", delay, err)
+		time.Sleep(delay)
 	}
 
 	cookie, err := auth.NewSessionCookie(auth.Session{AccessToken: tok.AccessToken})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	// If only Name and Value are set, then Cookie.String returns the
 	// serialization of the cookie for use in a Cookie header.
 	cookie = &http.Cookie{Name: cookie.Name, Value: cookie.Value}
 
-	// Say the token expires 5 minutes earlier so we have time to refresh it
-	expires := (time.Duration(tok.ExpiresInSec) * time.Second) - (5 * time.Minute)
+	// Say the token expires earlier so we have time to refresh it
+	expires := cookieExpiry(time.Duration(tok.ExpiresInSec)*time.Second, ExpiryBuffer)
 	return &authedCookie{
 		HeaderValue: cookie.String(),
 		Expires:     expires,
-	}, nil
+	}, tok, nil
+}
+
+var (
+	cookieCacheMu sync.Mutex
+	cookieCache   = map[string]*RefreshingCookie{}
+)
+
+// SharedCookie returns the RefreshingCookie for (endpoint, username),
+// authenticating on first use only. Load-test workers hitting the same
+// credentials should use this instead of authenticating independently,
+// so the test measures app throughput rather than auth throughput.
+func SharedCookie(endpoint *url.URL, username, password string) (*RefreshingCookie, error) {
+	key := endpoint.String() + "|" + username
+	cookieCacheMu.Lock()
+	defer cookieCacheMu.Unlock()
+	if c, ok := cookieCache[key]; ok {
+		return c, nil
+	}
+	c, err := NewRefreshingCookie(endpoint, username, password)
+	if err != nil {
+		return nil, err
+	}
+	cookieCache[key] = c
+	return c, nil
+}
+
+// RefreshingCookie is an authed cookie that re-authenticates in the
+// background before its access token expires, so long-running load
+// tests don't start failing with 401s partway through.
+type RefreshingCookie struct {
+	endpoint *url.URL
+	username string
+	password string
+
+	mu          sync.RWMutex
+	headerValue string
+
+	stop chan struct{}
+}
+
+// NewRefreshingCookie authenticates against endpoint and starts a
+// background goroutine that keeps the cookie fresh until Stop is
+// called.
+func NewRefreshingCookie(endpoint *url.URL, username, password string) (*RefreshingCookie, error) {
+	cookie, err := getAuthedCookie(endpoint, username, password)
+	if err != nil {
+		return nil, err
+	}
+	c := &RefreshingCookie{
+		endpoint:    endpoint,
+		username:    username,
+		password:    password,
+		headerValue: cookie.HeaderValue,
+		stop:        make(chan struct{}),
+	}
+	go c.refreshLoop(cookie.Expires)
+	return c, nil
+}
+
+// HeaderValue returns the current valid Cookie header value. It is
+// safe to call from concurrent load-test workers.
+func (c *RefreshingCookie) HeaderValue() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.headerValue
+}
+
+// Stop ends the background refreshing.
+func (c *RefreshingCookie) Stop() {
+	close(c.stop)
+}
+
+func (c *RefreshingCookie) refreshLoop(next time.Duration) {
+	const (
+		initialRetryDelay = time.Second
+		maxRetryDelay     = time.Minute
+	)
+	retryDelay := initialRetryDelay
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(next):
+		}
+		cookie, err := getAuthedCookie(c.endpoint, c.username, c.password)
+		if err != nil {
+			// The old cookie may still be valid (Expires has a refresh
+			// buffer built in), so retry with backoff rather than give
+			// up.
+			log.Printf("Refreshing load test cookie failed (retrying in %s): %s", retryDelay, err)
+			next = retryDelay
+			if retryDelay *= 2; retryDelay > maxRetryDelay {
+				retryDelay = maxRetryDelay
+			}
+			continue
+		}
+		c.mu.Lock()
+		c.headerValue = cookie.HeaderValue
+		c.mu.Unlock()
+		next = cookie.Expires
+		retryDelay = initialRetryDelay
+	}
 }
 
 func createLoadTestUser(endpoint *url.URL) error {
@@ -0,0 +1,25 @@
+package load
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCookieExpiry(t *testing.T) {
+	tests := []struct {
+		lifetime, buffer, want time.Duration
+	}{
+		// Long-lived token: the full buffer is subtracted.
+		{time.Hour, 5 * time.Minute, 55 * time.Minute},
+		// Short-lived token: the buffer is capped at a tenth of the
+		// lifetime instead of producing a negative duration.
+		{5 * time.Minute, 5 * time.Minute, 270 * time.Second},
+		{time.Minute, 5 * time.Minute, 54 * time.Second},
+		{0, 5 * time.Minute, 0},
+	}
+	for _, test := range tests {
+		if got := cookieExpiry(test.lifetime, test.buffer); got != test.want {
+			t.Errorf("cookieExpiry(%s, %s): got %s, want %s", test.lifetime, test.buffer, got, test.want)
+		}
+	}
+}